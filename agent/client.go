@@ -614,6 +614,8 @@ func (d *dialer) Config() *wg.Config {
 	return d.config
 }
 
+// DialContext dials addr through the tunnel of the agent. UDP networks are supported as
+// well, with every Read and Write of the returned connection carrying a single datagram.
 func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
 	if conn, err = d.client.dialContext(ctx); err != nil {
 		return
@@ -624,10 +626,16 @@ func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn ne
 		}
 	}()
 
+	var remote net.Addr
 	c := make(chan error, 1)
 	go func() {
+		// the network is always sent, for the agent to reply with the address it dialed
+		transport := "tcp"
+		if isPacketNetwork(network) {
+			transport = "udp"
+		}
 		timeout := strconv.FormatInt(int64(d.timeout), 10)
-		if err := proto.Write(conn, "connect", d.slug, addr, timeout); err != nil {
+		if err := proto.Write(conn, "connect", d.slug, addr, timeout, transport); err != nil {
 			c <- err
 			return
 		}
@@ -643,6 +651,9 @@ func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn ne
 			c <- errInvalidResponse(data)
 		case string(data) == "ok":
 			close(c)
+		case isOK(data):
+			remote = tunnelAddr{network: network, addr: string(extractOK(data))}
+			close(c)
 		case isError(data):
 			c <- extractError(data)
 		}
@@ -653,7 +664,14 @@ func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn ne
 		err = ctx.Err()
 	case err = <-c:
 	}
-	return
+	if err != nil {
+		return
+	}
+
+	if isPacketNetwork(network) {
+		return &packetConn{tunnelConn{Conn: conn, remote: remote}}, nil
+	}
+	return &tunnelConn{Conn: conn, remote: remote}, nil
 }
 
 // Pinger wraps a connection to the flyctl agent over which ICMP
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"net"
+	"strings"
+
+	"github.com/superfly/flyctl/agent/internal/proto"
+)
+
+// isPacketNetwork reports whether network is one of the UDP networks.
+func isPacketNetwork(network string) bool {
+	return strings.HasPrefix(network, "udp")
+}
+
+// tunnelAddr is the address the agent dialed through the tunnel.
+type tunnelAddr struct {
+	network string
+	addr    string
+}
+
+func (a tunnelAddr) Network() string { return a.network }
+
+func (a tunnelAddr) String() string { return a.addr }
+
+// tunnelConn is a connection relayed by the agent. Its remote address is the one the
+// agent dialed through the tunnel rather than the one of the agent.
+type tunnelConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *tunnelConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// CloseWrite closes the write half of the connection to the agent or, when that isn't
+// supported, the whole connection.
+func (c *tunnelConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// packetConn is a UDP connection relayed by the agent, which frames every datagram.
+// Each Read returns a single datagram, truncated to the size of the buffer.
+type packetConn struct {
+	tunnelConn
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	data, err := proto.Read(c.Conn)
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, data), nil
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	if err := proto.WriteFrame(c.Conn, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
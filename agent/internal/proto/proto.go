@@ -3,9 +3,14 @@ package proto
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
+	"math"
 )
 
+// ErrFrameTooLarge is returned by WriteFrame for data that doesn't fit in a frame.
+var ErrFrameTooLarge = errors.New("frame too large")
+
 func Read(r io.Reader) (data []byte, err error) {
 	var b [2]byte
 	if _, err = io.ReadFull(r, b[:]); err == nil {
@@ -46,3 +51,17 @@ func Write(w io.Writer, verb string, args ...string) (err error) {
 
 	return
 }
+
+// WriteFrame writes data as a single frame, which Read returns as is.
+func WriteFrame(w io.Writer, data []byte) (err error) {
+	if len(data) > math.MaxUint16 {
+		return ErrFrameTooLarge
+	}
+
+	frame := make([]byte, 2+len(data))
+	binary.LittleEndian.PutUint16(frame, uint16(len(data)))
+	copy(frame[2:], data)
+
+	_, err = w.Write(frame)
+	return
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"os"
 	"regexp"
@@ -313,10 +314,22 @@ var (
 )
 
 func (s *session) connect(ctx context.Context, args ...string) {
-	if !s.exactArgs(3, args, errMalformedConnect) {
+	// an optional fourth argument sets the network, tcp unless it's udp
+	if len(args) != 3 && len(args) != 4 {
+		s.error(errMalformedConnect)
+
 		return
 	}
 
+	network := "tcp"
+	if len(args) == 4 {
+		if network = args[3]; network != "tcp" && network != "udp" {
+			s.error(errMalformedConnect)
+
+			return
+		}
+	}
+
 	timeout, err := strconv.ParseUint(args[2], 10, 32)
 	if err != nil {
 		s.error(err)
@@ -340,7 +353,7 @@ func (s *session) connect(ctx context.Context, args ...string) {
 	}
 	defer cancel()
 
-	outconn, err := tunnel.DialContext(dialContext, "tcp", args[1])
+	outconn, err := tunnel.DialContext(dialContext, network, args[1])
 	if err != nil {
 		s.error(err)
 
@@ -352,7 +365,12 @@ func (s *session) connect(ctx context.Context, args ...string) {
 		}
 	}()
 
-	if !s.ok() {
+	// clients sending the network get the dialed address, the others expect a bare ok
+	var reply []string
+	if len(args) == 4 {
+		reply = append(reply, outconn.RemoteAddr().String())
+	}
+	if !s.ok(reply...) {
 		return
 	}
 
@@ -367,6 +385,38 @@ func (s *session) connect(ctx context.Context, args ...string) {
 		return errDone
 	})
 
+	if network == "udp" {
+		// datagrams are framed on the connection to the client
+		eg.Go(func() error {
+			buf := make([]byte, math.MaxUint16)
+			for {
+				n, err := outconn.Read(buf)
+				if err != nil {
+					return err
+				}
+				if err := proto.WriteFrame(s.conn, buf[:n]); err != nil {
+					return err
+				}
+			}
+		})
+
+		eg.Go(func() error {
+			for {
+				data, err := proto.Read(s.conn)
+				if err != nil {
+					return err
+				}
+				if _, err := outconn.Write(data); err != nil {
+					return err
+				}
+			}
+		})
+
+		_ = eg.Wait()
+
+		return
+	}
+
 	eg.Go(func() (err error) {
 		if _, err = io.Copy(s.conn, outconn); err == nil {
 			err = io.EOF
@@ -70,8 +70,15 @@ type Config struct {
 }
 
 type Deploy struct {
-	ReleaseCommand string `toml:"release_command,omitempty" json:"release_command,omitempty"`
-	Strategy       string `toml:"strategy,omitempty" json:"strategy,omitempty"`
+	ReleaseCommand    string           `toml:"release_command,omitempty" json:"release_command,omitempty"`
+	ReleaseCommands   []ReleaseCommand `toml:"release_commands,omitempty" json:"release_commands,omitempty"`
+	Strategy          string           `toml:"strategy,omitempty" json:"strategy,omitempty"`
+	RollbackOnFailure bool             `toml:"rollback_on_failure,omitempty" json:"rollback_on_failure,omitempty"`
+	MaxUnavailable    string           `toml:"max_unavailable,omitempty" json:"max_unavailable,omitempty"`
+	RegionOrder       []string         `toml:"region_order,omitempty" json:"region_order,omitempty"`
+	RegionBakeTime    *api.Duration    `toml:"region_bake_time,omitempty" json:"region_bake_time,omitempty"`
+	PreDeploy         []DeployHook     `toml:"pre_deploy,omitempty" json:"pre_deploy,omitempty"`
+	PostDeploy        []DeployHook     `toml:"post_deploy,omitempty" json:"post_deploy,omitempty"`
 }
 
 type Static struct {
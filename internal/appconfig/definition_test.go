@@ -204,8 +204,27 @@ func TestToDefinition(t *testing.T) {
 		},
 
 		"deploy": map[string]any{
-			"release_command": "release command",
-			"strategy":        "rolling-eyes",
+			"release_command":     "release command",
+			"strategy":            "rolling-eyes",
+			"rollback_on_failure": true,
+			"max_unavailable":     "2",
+			"region_order":        []any{"ord", "ams"},
+			"region_bake_time":    "5m0s",
+			"release_commands": []map[string]any{{
+				"command": "bin/warm-cache",
+				"vm_size": "performance-1x",
+				"timeout": "10m0s",
+				"env":     map[string]any{"CACHE_SCOPE": "all"},
+			}},
+			"pre_deploy": []map[string]any{{
+				"command": "bin/check-migrations",
+				"timeout": "2m0s",
+			}},
+			"post_deploy": []map[string]any{{
+				"command": "bin/smoke-test",
+				"run_on":  "machine",
+				"timeout": "30s",
+			}},
 		},
 		"env": map[string]any{
 			"FOO": "BAR",
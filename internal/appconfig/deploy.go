@@ -0,0 +1,119 @@
+package appconfig
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/superfly/flyctl/api"
+	"golang.org/x/exp/slices"
+)
+
+// ReleaseCommand is a release step run in its own ephemeral machine before the app machines are updated.
+// VMSize and Env override the machine size and environment the step would get otherwise.
+type ReleaseCommand struct {
+	Command string            `toml:"command,omitempty" json:"command,omitempty"`
+	VMSize  string            `toml:"vm_size,omitempty" json:"vm_size,omitempty"`
+	Env     map[string]string `toml:"env,omitempty" json:"env,omitempty"`
+	Timeout *api.Duration     `toml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// ReleaseCommandSteps returns the release commands to run in order,
+// starting with the release_command string if it is set.
+func (d *Deploy) ReleaseCommandSteps() []ReleaseCommand {
+	if d == nil {
+		return nil
+	}
+	var steps []ReleaseCommand
+	if d.ReleaseCommand != "" {
+		steps = append(steps, ReleaseCommand{Command: d.ReleaseCommand})
+	}
+	return append(steps, d.ReleaseCommands...)
+}
+
+func (rc ReleaseCommand) validate() error {
+	if strings.TrimSpace(rc.Command) == "" {
+		return fmt.Errorf("command is required")
+	}
+	if _, err := shlex.Split(rc.Command); err != nil {
+		return fmt.Errorf("can't shell split command '%s'", rc.Command)
+	}
+	if rc.VMSize != "" {
+		if err := (&api.MachineGuest{}).SetSize(rc.VMSize); err != nil {
+			return err
+		}
+	}
+	if rc.Timeout != nil && rc.Timeout.Duration <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+const (
+	DeployHookRunOnEphemeral = "ephemeral"
+	DeployHookRunOnMachine   = "machine"
+)
+
+// DeployHook is a command run before or after the app machines are updated. It runs in its own
+// ephemeral machine like the release command, or on one of the app machines with run_on = "machine".
+type DeployHook struct {
+	Command string        `toml:"command,omitempty" json:"command,omitempty"`
+	RunOn   string        `toml:"run_on,omitempty" json:"run_on,omitempty"`
+	Timeout *api.Duration `toml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// RunsOnMachine reports whether the hook runs on an existing app machine instead of an ephemeral one.
+func (h DeployHook) RunsOnMachine() bool {
+	return h.RunOn == DeployHookRunOnMachine
+}
+
+func (h DeployHook) validate() error {
+	if strings.TrimSpace(h.Command) == "" {
+		return fmt.Errorf("command is required")
+	}
+	if _, err := shlex.Split(h.Command); err != nil {
+		return fmt.Errorf("can't shell split command '%s'", h.Command)
+	}
+	if h.RunOn != "" && !slices.Contains([]string{DeployHookRunOnEphemeral, DeployHookRunOnMachine}, h.RunOn) {
+		return fmt.Errorf("invalid run_on '%s', must be '%s' or '%s'", h.RunOn, DeployHookRunOnEphemeral, DeployHookRunOnMachine)
+	}
+	if h.Timeout != nil && h.Timeout.Duration <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+// MaxUnavailableMachines returns how many of total machines a rolling deployment may update at once.
+// max_unavailable is either an absolute number of machines or a percentage of them like "25%",
+// the result is always between 1 and total.
+func (d *Deploy) MaxUnavailableMachines(total int) (int, error) {
+	if d == nil || d.MaxUnavailable == "" {
+		return 1, nil
+	}
+
+	var n int
+	value := strings.TrimSpace(d.MaxUnavailable)
+	if pct, ok := strings.CutSuffix(value, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || v <= 0 || v > 100 {
+			return 0, fmt.Errorf("invalid max_unavailable '%s', percentages must be between 0%% and 100%%", d.MaxUnavailable)
+		}
+		n = int(math.Floor(float64(total) * v / 100))
+	} else {
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 1 {
+			return 0, fmt.Errorf("invalid max_unavailable '%s', must be a positive number of machines or a percentage", d.MaxUnavailable)
+		}
+		n = v
+	}
+
+	if n > total {
+		n = total
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n, nil
+}
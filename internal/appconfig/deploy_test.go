@@ -0,0 +1,130 @@
+package appconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func TestMaxUnavailableMachines(t *testing.T) {
+	cases := []struct {
+		value    string
+		total    int
+		expected int
+	}{
+		{"", 10, 1},
+		{"3", 10, 3},
+		{"3", 2, 2},
+		{"25%", 10, 2},
+		{"25%", 2, 1},
+		{"100%", 7, 7},
+		{" 50 % ", 4, 2},
+	}
+	for _, tc := range cases {
+		n, err := (&Deploy{MaxUnavailable: tc.value}).MaxUnavailableMachines(tc.total)
+		require.NoError(t, err, tc.value)
+		assert.Equal(t, tc.expected, n, tc.value)
+	}
+
+	var nilDeploy *Deploy
+	n, err := nilDeploy.MaxUnavailableMachines(10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	for _, value := range []string{"0", "-1", "0%", "101%", "half", "0.5"} {
+		_, err := (&Deploy{MaxUnavailable: value}).MaxUnavailableMachines(10)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestPatchDeployMaxUnavailable(t *testing.T) {
+	cfg, err := applyPatches(map[string]any{
+		"deploy": map[string]any{"max_unavailable": int64(4)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "4", cfg.Deploy.MaxUnavailable)
+
+	cfg, err = applyPatches(map[string]any{
+		"deploy": map[string]any{"max_unavailable": "30%"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "30%", cfg.Deploy.MaxUnavailable)
+}
+
+func TestDeployHookValidate(t *testing.T) {
+	valid := []DeployHook{
+		{Command: "bin/smoke-test"},
+		{Command: "bin/smoke-test --all", RunOn: "machine", Timeout: api.MustParseDuration("30s")},
+		{Command: "bin/migrate", RunOn: "ephemeral"},
+	}
+	for _, hook := range valid {
+		assert.NoError(t, hook.validate(), hook.Command)
+	}
+
+	invalid := []DeployHook{
+		{},
+		{Command: "bin/smoke-test 'unterminated"},
+		{Command: "bin/smoke-test", RunOn: "everywhere"},
+		{Command: "bin/smoke-test", Timeout: api.MustParseDuration("0s")},
+	}
+	for _, hook := range invalid {
+		assert.Error(t, hook.validate(), hook.Command)
+	}
+}
+
+func TestPatchDeployReleaseCommands(t *testing.T) {
+	// The string form is kept as is
+	cfg, err := applyPatches(map[string]any{
+		"deploy": map[string]any{"release_command": "bin/migrate"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "bin/migrate", cfg.Deploy.ReleaseCommand)
+	assert.Empty(t, cfg.Deploy.ReleaseCommands)
+	assert.Equal(t, []ReleaseCommand{{Command: "bin/migrate"}}, cfg.Deploy.ReleaseCommandSteps())
+
+	// A list of commands
+	cfg, err = applyPatches(map[string]any{
+		"deploy": map[string]any{"release_command": []any{"bin/migrate", "bin/warm-cache"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "", cfg.Deploy.ReleaseCommand)
+	assert.Equal(t, []ReleaseCommand{{Command: "bin/migrate"}, {Command: "bin/warm-cache"}}, cfg.Deploy.ReleaseCommandSteps())
+
+	// Tables under release_command are merged with release_commands
+	cfg, err = applyPatches(map[string]any{
+		"deploy": map[string]any{
+			"release_command": []map[string]any{{
+				"command": "bin/migrate",
+				"vm_size": "shared-cpu-4x",
+				"timeout": "10m",
+				"env":     map[string]any{"VERBOSE": true},
+			}},
+			"release_commands": map[string]any{"command": "bin/warm-cache"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []ReleaseCommand{
+		{
+			Command: "bin/migrate",
+			VMSize:  "shared-cpu-4x",
+			Timeout: api.MustParseDuration("10m"),
+			Env:     map[string]string{"VERBOSE": "true"},
+		},
+		{Command: "bin/warm-cache"},
+	}, cfg.Deploy.ReleaseCommandSteps())
+
+	_, err = applyPatches(map[string]any{
+		"deploy": map[string]any{"release_command": int64(3)},
+	})
+	assert.Error(t, err)
+}
+
+func TestReleaseCommandValidate(t *testing.T) {
+	assert.NoError(t, ReleaseCommand{Command: "bin/migrate", VMSize: "shared-cpu-2x", Timeout: api.MustParseDuration("1m")}.validate())
+	assert.Error(t, ReleaseCommand{}.validate())
+	assert.Error(t, ReleaseCommand{Command: "bin/migrate 'unterminated"}.validate())
+	assert.Error(t, ReleaseCommand{Command: "bin/migrate", VMSize: "huge"}.validate())
+	assert.Error(t, ReleaseCommand{Command: "bin/migrate", Timeout: api.MustParseDuration("0s")}.validate())
+}
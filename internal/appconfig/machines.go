@@ -17,15 +17,37 @@ func (c *Config) ToMachineConfig(processGroup string, src *api.MachineConfig) (*
 	return fc.updateMachineConfig(src)
 }
 
-func (c *Config) ToReleaseMachineConfig() (*api.MachineConfig, error) {
-	releaseCmd, err := shlex.Split(c.Deploy.ReleaseCommand)
+// ToReleaseMachineConfig returns the config of the ephemeral machine that runs a release command step
+func (c *Config) ToReleaseMachineConfig(rc ReleaseCommand) (*api.MachineConfig, error) {
+	mConfig, err := c.toEphemeralMachineConfig(rc.Command, api.MachineProcessGroupFlyAppReleaseCommand)
+	if err != nil {
+		return nil, err
+	}
+	mConfig.Env = lo.Assign(mConfig.Env, rc.Env)
+	mConfig.Env["RELEASE_COMMAND"] = "1"
+	return mConfig, nil
+}
+
+// ToDeployHookMachineConfig returns the config of the ephemeral machine that runs a deploy hook
+func (c *Config) ToDeployHookMachineConfig(hook DeployHook) (*api.MachineConfig, error) {
+	mConfig, err := c.toEphemeralMachineConfig(hook.Command, api.MachineProcessGroupFlyAppDeployHook)
+	if err != nil {
+		return nil, err
+	}
+	mConfig.Env["DEPLOY_HOOK"] = "1"
+	return mConfig, nil
+}
+
+// toEphemeralMachineConfig returns the config of a machine that runs command once and is destroyed after it exits
+func (c *Config) toEphemeralMachineConfig(command, processGroup string) (*api.MachineConfig, error) {
+	cmd, err := shlex.Split(command)
 	if err != nil {
 		return nil, err
 	}
 
 	mConfig := &api.MachineConfig{
 		Init: api.MachineInit{
-			Cmd: releaseCmd,
+			Cmd: cmd,
 		},
 		Restart: api.MachineRestart{
 			Policy: api.MachineRestartPolicyNo,
@@ -36,13 +58,12 @@ func (c *Config) ToReleaseMachineConfig() (*api.MachineConfig, error) {
 		},
 		Metadata: map[string]string{
 			api.MachineConfigMetadataKeyFlyPlatformVersion: api.MachineFlyPlatformVersion2,
-			api.MachineConfigMetadataKeyFlyProcessGroup:    api.MachineProcessGroupFlyAppReleaseCommand,
+			api.MachineConfigMetadataKeyFlyProcessGroup:    processGroup,
 		},
 		Env: lo.Assign(c.Env),
 	}
 
-	mConfig.Env["RELEASE_COMMAND"] = "1"
-	mConfig.Env["FLY_PROCESS_GROUP"] = api.MachineProcessGroupFlyAppReleaseCommand
+	mConfig.Env["FLY_PROCESS_GROUP"] = processGroup
 	if c.PrimaryRegion != "" {
 		mConfig.Env["PRIMARY_REGION"] = c.PrimaryRegion
 	}
@@ -122,7 +122,31 @@ func TestToReleaseMachineConfig(t *testing.T) {
 		DNS:         &api.DNSConfig{SkipRegistration: true},
 	}
 
-	got, err := cfg.ToReleaseMachineConfig()
+	got, err := cfg.ToReleaseMachineConfig(cfg.Deploy.ReleaseCommandSteps()[0])
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Release command steps can override the environment
+	got, err = cfg.ToReleaseMachineConfig(ReleaseCommand{Command: "warm-cache", Env: map[string]string{"FOO": "BAZ"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"warm-cache"}, got.Init.Cmd)
+	assert.Equal(t, map[string]string{"FOO": "BAZ", "PRIMARY_REGION": "mia", "RELEASE_COMMAND": "1", "FLY_PROCESS_GROUP": "fly_app_release_command"}, got.Env)
+}
+
+func TestToDeployHookMachineConfig(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine.toml")
+	require.NoError(t, err)
+
+	want := &api.MachineConfig{
+		Init:        api.MachineInit{Cmd: []string{"bin/smoke-test", "--all"}},
+		Env:         map[string]string{"FOO": "BAR", "PRIMARY_REGION": "mia", "DEPLOY_HOOK": "1", "FLY_PROCESS_GROUP": "fly_app_deploy_hook"},
+		Metadata:    map[string]string{"fly_platform_version": "v2", "fly_process_group": "fly_app_deploy_hook"},
+		AutoDestroy: true,
+		Restart:     api.MachineRestart{Policy: api.MachineRestartPolicyNo},
+		DNS:         &api.DNSConfig{SkipRegistration: true},
+	}
+
+	got, err := cfg.ToDeployHookMachineConfig(DeployHook{Command: "bin/smoke-test --all"})
 	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
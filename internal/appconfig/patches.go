@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/samber/lo"
 )
 
 type patchFuncType func(map[string]any) (map[string]any, error)
@@ -17,6 +19,7 @@ var configPatches = []patchFuncType{
 	patchExperimental,
 	patchTopLevelChecks,
 	patchMounts,
+	patchDeploy,
 }
 
 func applyPatches(cfgMap map[string]any) (*Config, error) {
@@ -133,6 +136,81 @@ func patchMounts(cfg map[string]any) (map[string]any, error) {
 	return cfg, nil
 }
 
+func patchDeploy(cfg map[string]any) (map[string]any, error) {
+	raw, ok := cfg["deploy"]
+	if !ok {
+		return cfg, nil
+	}
+
+	cast, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'deploy' section of unknown type: %T", raw)
+	}
+
+	// max_unavailable can be a number of machines or a percentage string
+	if v, ok := cast["max_unavailable"]; ok {
+		switch n := v.(type) {
+		case string:
+			// Nothing to do here
+		case int64, float64:
+			cast["max_unavailable"] = fmt.Sprintf("%v", n)
+		default:
+			return nil, fmt.Errorf("Unknown type for max_unavailable: %T", n)
+		}
+	}
+
+	// release_command is either a single command or a list of release steps with their own settings.
+	// The string form stays as is for backward compatibility while steps are moved to release_commands
+	var releaseCommands []map[string]any
+	if v, ok := cast["release_command"]; ok {
+		if _, isString := v.(string); !isString {
+			steps, err := _patchReleaseCommands(v)
+			if err != nil {
+				return nil, err
+			}
+			releaseCommands = append(releaseCommands, steps...)
+			delete(cast, "release_command")
+		}
+	}
+	if v, ok := cast["release_commands"]; ok {
+		steps, err := _patchReleaseCommands(v)
+		if err != nil {
+			return nil, err
+		}
+		releaseCommands = append(releaseCommands, steps...)
+	}
+	if len(releaseCommands) > 0 {
+		cast["release_commands"] = releaseCommands
+	}
+	return cfg, nil
+}
+
+func _patchReleaseCommands(raw any) ([]map[string]any, error) {
+	// A list of plain commands
+	if cast, ok := raw.([]any); ok && len(cast) > 0 {
+		if _, isString := cast[0].(string); isString {
+			cmds, err := stringOrSliceToSlice(cast, "release_command")
+			if err != nil {
+				return nil, err
+			}
+			return lo.Map(cmds, func(cmd string, _ int) map[string]any {
+				return map[string]any{"command": cmd}
+			}), nil
+		}
+	}
+
+	steps, err := ensureArrayOfMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error processing release commands: %w", err)
+	}
+	for idx, step := range steps {
+		if steps[idx], err = patchEnv(step); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
 func patchTopLevelChecks(cfg map[string]any) (map[string]any, error) {
 	raw, ok := cfg["checks"]
 	if !ok {
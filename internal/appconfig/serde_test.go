@@ -315,8 +315,27 @@ func TestLoadTOMLAppConfigReferenceFormat(t *testing.T) {
 		},
 
 		Deploy: &Deploy{
-			ReleaseCommand: "release command",
-			Strategy:       "rolling-eyes",
+			ReleaseCommand:    "release command",
+			Strategy:          "rolling-eyes",
+			RollbackOnFailure: true,
+			MaxUnavailable:    "2",
+			RegionOrder:       []string{"ord", "ams"},
+			RegionBakeTime:    api.MustParseDuration("5m"),
+			ReleaseCommands: []ReleaseCommand{{
+				Command: "bin/warm-cache",
+				VMSize:  "performance-1x",
+				Timeout: api.MustParseDuration("10m"),
+				Env:     map[string]string{"CACHE_SCOPE": "all"},
+			}},
+			PreDeploy: []DeployHook{{
+				Command: "bin/check-migrations",
+				Timeout: api.MustParseDuration("2m"),
+			}},
+			PostDeploy: []DeployHook{{
+				Command: "bin/smoke-test",
+				RunOn:   "machine",
+				Timeout: api.MustParseDuration("30s"),
+			}},
 		},
 
 		Env: map[string]string{
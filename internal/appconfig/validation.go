@@ -135,6 +135,28 @@ func (cfg *Config) validateDeploySection() (extraInfo string, err error) {
 			extraInfo += fmt.Sprintf("Can't shell split release command: '%s'\n", cfg.Deploy.ReleaseCommand)
 			err = ValidationError
 		}
+		for i, rc := range cfg.Deploy.ReleaseCommands {
+			if vErr := rc.validate(); vErr != nil {
+				extraInfo += fmt.Sprintf("Invalid release command #%d: %s\n", i+1, vErr)
+				err = ValidationError
+			}
+		}
+		if _, vErr := cfg.Deploy.MaxUnavailableMachines(1); vErr != nil {
+			extraInfo += fmt.Sprintf("%s\n", vErr)
+			err = ValidationError
+		}
+		for i, hook := range cfg.Deploy.PreDeploy {
+			if vErr := hook.validate(); vErr != nil {
+				extraInfo += fmt.Sprintf("Invalid pre_deploy hook #%d: %s\n", i+1, vErr)
+				err = ValidationError
+			}
+		}
+		for i, hook := range cfg.Deploy.PostDeploy {
+			if vErr := hook.validate(); vErr != nil {
+				extraInfo += fmt.Sprintf("Invalid post_deploy hook #%d: %s\n", i+1, vErr)
+				err = ValidationError
+			}
+		}
 	}
 	return
 }
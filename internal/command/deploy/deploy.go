@@ -16,6 +16,7 @@ import (
 	"github.com/superfly/flyctl/internal/build/imgsrc"
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/machine"
 	"github.com/superfly/flyctl/internal/render"
 	"github.com/superfly/flyctl/internal/sentry"
 
@@ -34,6 +35,7 @@ var CommonFlags = flag.Set{
 	flag.Push(),
 	flag.Detach(),
 	flag.Strategy(),
+	flag.WaitForLeases(),
 	flag.Dockerfile(),
 	flag.Ignorefile(),
 	flag.ImageLabel(),
@@ -72,6 +74,19 @@ var CommonFlags = flag.Set{
 		Description: "Use the Apps v2 platform built with Machines",
 		Default:     false,
 	},
+	flag.Bool{
+		Name:        "rollback-on-failure",
+		Description: "Restore the machines updated by a failed Apps v2 deployment to their previous configuration",
+		Default:     false,
+	},
+	flag.StringSlice{
+		Name:        "region-order",
+		Description: "Comma separated list of regions to deploy one after another. Machines in regions not listed are deployed last",
+	},
+	flag.Duration{
+		Name:        "region-bake-time",
+		Description: "Time to wait after a region is deployed before checking its machines are healthy and moving on to the next one",
+	},
 	flag.String{
 		Name:        "vm-size",
 		Description: `The VM size to use when deploying for the first time. See "fly platform vm-sizes" for valid values`,
@@ -99,6 +114,12 @@ func New() (cmd *cobra.Command) {
 		CommonFlags,
 		flag.App(),
 		flag.AppConfig(),
+		flag.Bool{
+			Name:        "dry-run",
+			Description: "Print the changes the deployment would apply to the app machines without applying them. Nothing is built or pushed, apps deployed from source show a placeholder instead of the image",
+			Default:     false,
+		},
+		flag.JSONOutput(),
 	)
 
 	return
@@ -141,8 +162,24 @@ func DeployWithConfig(ctx context.Context, appConfig *appconfig.Config, args Dep
 		return err
 	}
 
+	isV2App, err := useMachines(ctx, appConfig, appCompact, args, apiClient)
+	if err != nil {
+		return err
+	}
+
+	// A dry run neither builds nor pushes, so it is rejected before anything happens
+	dryRun := flag.GetBool(ctx, "dry-run")
+	if dryRun && !isV2App {
+		return fmt.Errorf("--dry-run is only supported for Apps v2 (machines) apps")
+	}
+
 	// Fetch an image ref or build from source to get the final image reference to deploy
-	img, err := determineImage(ctx, appConfig)
+	var img *imgsrc.DeploymentImage
+	if dryRun {
+		img, err = dryRunImage(ctx, appConfig)
+	} else {
+		img, err = determineImage(ctx, appConfig)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to fetch an image or build from source: %w", err)
 	}
@@ -151,22 +188,21 @@ func DeployWithConfig(ctx context.Context, appConfig *appconfig.Config, args Dep
 		return nil
 	}
 
-	switch isV2App, err := useMachines(ctx, appConfig, appCompact, args, apiClient); {
-	case err != nil:
-		return err
-	case isV2App:
+	if isV2App {
 		if err := appConfig.EnsureV2Config(); err != nil {
 			return fmt.Errorf("Can't deploy an invalid v2 app config: %s", err)
 		}
 		return deployToMachines(ctx, appConfig, appCompact, img)
-	default:
-		return deployToNomad(ctx, appConfig, appCompact, img)
 	}
+	return deployToNomad(ctx, appConfig, appCompact, img)
 }
 
 func deployToMachines(ctx context.Context, appConfig *appconfig.Config, appCompact *api.AppCompact, img *imgsrc.DeploymentImage) error {
 	// It's important to push appConfig into context because MachineDeployment will fetch it from there
 	ctx = appconfig.WithConfig(ctx, appConfig)
+	if flag.GetBool(ctx, "wait-for-leases") {
+		ctx = machine.WithWaitForLeases(ctx)
+	}
 
 	md, err := NewMachineDeployment(ctx, MachineDeploymentArgs{
 		AppCompact:        appCompact,
@@ -178,12 +214,21 @@ func deployToMachines(ctx context.Context, appConfig *appconfig.Config, appCompa
 		WaitTimeout:       time.Duration(flag.GetInt(ctx, "wait-timeout")) * time.Second,
 		LeaseTimeout:      time.Duration(flag.GetInt(ctx, "lease-timeout")) * time.Second,
 		VMSize:            flag.GetString(ctx, "vm-size"),
+		RollbackOnFailure: flag.GetBool(ctx, "rollback-on-failure"),
+		RegionOrder:       flag.GetStringSlice(ctx, "region-order"),
+		RegionBakeTime:    flag.GetDuration(ctx, "region-bake-time"),
+		DryRun:            flag.GetBool(ctx, "dry-run"),
+		ResumeRollback:    true,
 	})
 	if err != nil {
 		sentry.CaptureExceptionWithAppInfo(err, "deploy", appCompact)
 		return err
 	}
 
+	if flag.GetBool(ctx, "dry-run") {
+		return printDeploymentPlan(ctx, md)
+	}
+
 	err = md.DeployMachinesApp(ctx)
 	if err != nil {
 		sentry.CaptureExceptionWithAppInfo(err, "deploy", appCompact)
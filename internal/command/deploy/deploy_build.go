@@ -191,3 +191,19 @@ func fetchImageRef(ctx context.Context, cfg *appconfig.Config) (ref string, err
 
 	return ref, nil
 }
+
+// dryRunImageFromSource stands for the image a dry run would otherwise build from source.
+const dryRunImageFromSource = "(image built from source)"
+
+// dryRunImage returns the image to plan a dry run with, without building or pushing anything.
+// That's the pre-built image reference when there is one.
+func dryRunImage(ctx context.Context, appConfig *appconfig.Config) (*imgsrc.DeploymentImage, error) {
+	ref, err := fetchImageRef(ctx, appConfig)
+	if err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		ref = dryRunImageFromSource
+	}
+	return &imgsrc.DeploymentImage{Tag: ref}, nil
+}
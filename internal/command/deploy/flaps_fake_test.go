@@ -0,0 +1,196 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/logger"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// fakeFlaps serves the machines API endpoints used by deployments from memory.
+type fakeFlaps struct {
+	mu       sync.Mutex
+	machines map[string]*api.Machine
+	launches int
+	// destroyed lists the IDs of destroyed machines in the order they were destroyed
+	destroyed []string
+	// failWait makes the next waits on these machines fail as if they didn't exist, as many as given
+	failWait map[string]int
+	// failDestroy makes destroying these machines fail
+	failDestroy map[string]bool
+	// stuck keeps these machines from ever reaching a state, waits on it block until canceled
+	stuck map[string]string
+	// hook, when set, is called before every request is handled
+	hook func(method, id, action string)
+}
+
+// newFakeFlaps starts a fakeFlaps with machines and returns a flaps client talking to it.
+func newFakeFlaps(t *testing.T, machines ...*api.Machine) (*fakeFlaps, *flaps.Client) {
+	t.Helper()
+
+	f := &fakeFlaps{machines: map[string]*api.Machine{}, failWait: map[string]int{}, failDestroy: map[string]bool{}, stuck: map[string]string{}}
+	for _, m := range machines {
+		f.machines[m.ID] = m
+	}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+	ctx := logger.NewContext(context.Background(), logger.FromEnv(io.Discard))
+	client, err := flaps.NewFromAppName(ctx, "my-cool-app")
+	require.NoError(t, err)
+	return f, client
+}
+
+// stubFlapsDeployment returns a deployment of machines backed by a fakeFlaps.
+func stubFlapsDeployment(t *testing.T, machines ...*api.Machine) (*machineDeployment, *fakeFlaps) {
+	t.Helper()
+
+	f, client := newFakeFlaps(t, machines...)
+	ios, _, _, _ := iostreams.Test()
+	md, err := stabMachineDeployment(nil)
+	require.NoError(t, err)
+	md.flapsClient = client
+	md.io = ios
+	md.colorize = ios.ColorScheme()
+	md.waitTimeout = 5 * time.Second
+	md.machineSet = machine.NewMachineSet(client, ios, machines)
+	return md, f
+}
+
+// updateEntries returns an entry updating every machine of md in place with its own config.
+func updateEntries(md *machineDeployment) []*machineUpdateEntry {
+	var entries []*machineUpdateEntry
+	for _, lm := range md.machineSet.GetMachines() {
+		entries = append(entries, &machineUpdateEntry{
+			leasableMachine: lm,
+			launchInput: &api.LaunchMachineInput{
+				ID:     lm.Machine().ID,
+				Region: lm.Machine().Region,
+				Config: machine.CloneConfig(lm.Machine().Config),
+			},
+		})
+	}
+	return entries
+}
+
+func (f *fakeFlaps) has(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.machines[id]
+	return ok
+}
+
+// get returns a copy of machine id, or nil if there is none.
+func (f *fakeFlaps) get(id string) *api.Machine {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.machines[id]
+	if !ok {
+		return nil
+	}
+	c := *m
+	return &c
+}
+
+func (f *fakeFlaps) launched() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.launches
+}
+
+func (f *fakeFlaps) destroyedIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.destroyed...)
+}
+
+func (f *fakeFlaps) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/apps/my-cool-app/machines")
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	id, action := parts[0], ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if f.hook != nil {
+		f.hook(r.Method, id, action)
+	}
+	if action == "wait" && f.stuck[id] == r.URL.Query().Get("state") {
+		<-r.Context().Done()
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id == "" && r.Method == http.MethodPost {
+		var input api.LaunchMachineInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.launches++
+		m := &api.Machine{
+			ID:     fmt.Sprintf("new%d", f.launches),
+			Region: input.Region,
+			State:  api.MachineStateCreated,
+			Config: input.Config,
+		}
+		f.machines[m.ID] = m
+		json.NewEncoder(w).Encode(m)
+		return
+	}
+
+	m, ok := f.machines[id]
+	if !ok {
+		http.Error(w, "machine not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(m)
+	case action == "" && r.Method == http.MethodPost:
+		var input api.LaunchMachineInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.Config = input.Config
+		json.NewEncoder(w).Encode(m)
+	case action == "" && r.Method == http.MethodDelete:
+		if f.failDestroy[id] {
+			http.Error(w, "failed to destroy machine", http.StatusInternalServerError)
+			return
+		}
+		delete(f.machines, id)
+		f.destroyed = append(f.destroyed, id)
+	case action == "wait":
+		if f.failWait[id] > 0 {
+			f.failWait[id]--
+			http.Error(w, "machine not found", http.StatusNotFound)
+		}
+	case action == "lease" && r.Method == http.MethodPost:
+		json.NewEncoder(w).Encode(&api.MachineLease{
+			Status: "success",
+			Data:   &api.MachineLeaseData{Nonce: "nonce-" + id, ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		})
+	case action == "lease" && r.Method == http.MethodDelete,
+		action == "signal", action == "stop", action == "start":
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/superfly/flyctl/internal/machine"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/terminal"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -26,8 +27,11 @@ const (
 	DefaultLeaseTtl    = 13 * time.Second
 )
 
+var supportedStrategies = []string{"rolling", "immediate", "canary", "bluegreen"}
+
 type MachineDeployment interface {
 	DeployMachinesApp(context.Context) error
+	PlanMachinesApp(context.Context) (*DeploymentPlan, error)
 }
 
 type MachineDeploymentArgs struct {
@@ -41,6 +45,14 @@ type MachineDeploymentArgs struct {
 	WaitTimeout       time.Duration
 	LeaseTimeout      time.Duration
 	VMSize            string
+	RollbackOnFailure bool
+	RegionOrder       []string
+	RegionBakeTime    time.Duration
+	// DryRun skips every step that changes the app, the deployment can only be planned
+	DryRun bool
+	// ResumeRollback finishes a rollback that a previous deployment left halfway. Without it,
+	// the deployment only warns about the interrupted rollback.
+	ResumeRollback bool
 }
 
 type machineDeployment struct {
@@ -65,6 +77,11 @@ type machineDeployment struct {
 	leaseDelayBetween     time.Duration
 	isFirstDeploy         bool
 	machineGuest          *api.MachineGuest
+	rollbackOnFailure     bool
+	rollbackJournalPath   string
+	maxUnavailable        int
+	regionOrder           []string
+	regionBakeTime        time.Duration
 }
 
 func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (MachineDeployment, error) {
@@ -89,8 +106,8 @@ func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (Mach
 	if err != nil {
 		return nil, err
 	}
-	if appConfig.Deploy != nil {
-		_, err = shlex.Split(appConfig.Deploy.ReleaseCommand)
+	for _, step := range appConfig.Deploy.ReleaseCommandSteps() {
+		_, err = shlex.Split(step.Command)
 		if err != nil {
 			return nil, err
 		}
@@ -110,19 +127,21 @@ func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (Mach
 	io := iostreams.FromContext(ctx)
 	apiClient := client.FromContext(ctx).API()
 	md := &machineDeployment{
-		apiClient:         apiClient,
-		gqlClient:         apiClient.GenqClient,
-		flapsClient:       flapsClient,
-		io:                io,
-		colorize:          io.ColorScheme(),
-		app:               args.AppCompact,
-		appConfig:         appConfig,
-		img:               args.DeploymentImage,
-		skipHealthChecks:  args.SkipHealthChecks,
-		restartOnly:       args.RestartOnly,
-		waitTimeout:       waitTimeout,
-		leaseTimeout:      leaseTimeout,
-		leaseDelayBetween: leaseDelayBetween,
+		apiClient:           apiClient,
+		gqlClient:           apiClient.GenqClient,
+		flapsClient:         flapsClient,
+		io:                  io,
+		colorize:            io.ColorScheme(),
+		app:                 args.AppCompact,
+		appConfig:           appConfig,
+		img:                 args.DeploymentImage,
+		skipHealthChecks:    args.SkipHealthChecks,
+		restartOnly:         args.RestartOnly,
+		waitTimeout:         waitTimeout,
+		leaseTimeout:        leaseTimeout,
+		leaseDelayBetween:   leaseDelayBetween,
+		rollbackOnFailure:   args.RollbackOnFailure || (appConfig.Deploy != nil && appConfig.Deploy.RollbackOnFailure),
+		rollbackJournalPath: rollbackJournalPath(ctx, args.AppCompact.Name),
 	}
 	if err := md.setStrategy(args.Strategy); err != nil {
 		return nil, err
@@ -130,14 +149,22 @@ func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (Mach
 	if err := md.setMachineGuest(args.VMSize); err != nil {
 		return nil, err
 	}
+	md.setRegionOrder(args.RegionOrder, args.RegionBakeTime)
+	if !args.DryRun {
+		if err := md.resumeInterruptedRollback(ctx, args.ResumeRollback); err != nil {
+			return nil, err
+		}
+	}
 	if err := md.setMachinesForDeployment(ctx); err != nil {
 		return nil, err
 	}
 	if err := md.setFirstDeploy(ctx); err != nil {
 		return nil, err
 	}
-	if err := md.provisionFirstDeploy(ctx); err != nil {
-		return nil, err
+	if !args.DryRun {
+		if err := md.provisionFirstDeploy(ctx); err != nil {
+			return nil, err
+		}
 	}
 	if err := md.setImg(ctx); err != nil {
 		return nil, err
@@ -148,6 +175,9 @@ func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (Mach
 	if err := md.validateVolumeConfig(); err != nil {
 		return nil, err
 	}
+	if args.DryRun {
+		return md, nil
+	}
 	if err = md.createReleaseInBackend(ctx); err != nil {
 		return nil, err
 	}
@@ -197,6 +227,11 @@ func (md *machineDeployment) setMachinesForDeployment(ctx context.Context) error
 		}
 	}
 
+	md.maxUnavailable, err = md.appConfig.Deploy.MaxUnavailableMachines(len(machines))
+	if err != nil {
+		return err
+	}
+
 	md.machineSet = machine.NewMachineSet(md.flapsClient, md.io, machines)
 	var releaseCmdSet []*api.Machine
 	if releaseCmdMachine != nil {
@@ -350,12 +385,26 @@ func (md *machineDeployment) setStrategy(passedInStrategy string) error {
 	} else {
 		md.strategy = "rolling"
 	}
-	if md.strategy != "rolling" && md.strategy != "immediate" {
-		return fmt.Errorf("error unsupported deployment strategy '%s'; fly deploy for machines supports rolling and immediate strategies", md.strategy)
+	if !slices.Contains(supportedStrategies, md.strategy) {
+		return fmt.Errorf("error unsupported deployment strategy '%s'; fly deploy for machines supports %s strategies", md.strategy, strings.Join(supportedStrategies, ", "))
 	}
 	return nil
 }
 
+func (md *machineDeployment) setRegionOrder(regionOrder []string, bakeTime time.Duration) {
+	md.regionOrder = regionOrder
+	md.regionBakeTime = bakeTime
+	if md.appConfig.Deploy == nil {
+		return
+	}
+	if len(md.regionOrder) == 0 {
+		md.regionOrder = md.appConfig.Deploy.RegionOrder
+	}
+	if md.regionBakeTime == 0 && md.appConfig.Deploy.RegionBakeTime != nil {
+		md.regionBakeTime = md.appConfig.Deploy.RegionBakeTime.Duration
+	}
+}
+
 func (md *machineDeployment) createReleaseInBackend(ctx context.Context) error {
 	_ = `# @genqlient
 	mutation MachinesCreateRelease($input:CreateReleaseInput!) {
@@ -404,8 +453,8 @@ func (md *machineDeployment) updateReleaseInBackend(ctx context.Context, status
 	return nil
 }
 
-func (md *machineDeployment) logClearLinesAbove(count int) {
-	if md.io.IsInteractive() {
+func (md *machineDeployment) logClearLinesAbove(ctx context.Context, count int) {
+	if machine.CanRedraw(ctx, md.io) {
 		builder := aec.EmptyBuilder
 		str := builder.Up(uint(count)).EraseLine(aec.EraseModes.All).ANSI
 		fmt.Fprint(md.io.ErrOut, str.String())
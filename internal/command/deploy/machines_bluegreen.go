@@ -0,0 +1,122 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/terminal"
+)
+
+// updateMachinesBlueGreen launches a clone of every machine with its new launch input (the green set),
+// waits for all of them to be healthy and only then destroys the original machines (the blue set).
+// If any green machine fails, all green machines are destroyed and the blue set is left untouched.
+func (md *machineDeployment) updateMachinesBlueGreen(ctx context.Context, updateEntries []*machineUpdateEntry) error {
+	for _, e := range updateEntries {
+		if len(e.launchInput.Config.Mounts) > 0 {
+			return fmt.Errorf(
+				"machine %s has a volume attached and can't be cloned; bluegreen strategy doesn't support machines with volumes, use rolling or canary instead",
+				e.leasableMachine.FormattedMachineId(),
+			)
+		}
+	}
+
+	// Launch machines that aren't standbys first so standbys can point to their new IDs
+	ordered := append(
+		lo.Filter(updateEntries, func(e *machineUpdateEntry, _ int) bool { return len(e.launchInput.Config.Standbys) == 0 }),
+		lo.Filter(updateEntries, func(e *machineUpdateEntry, _ int) bool { return len(e.launchInput.Config.Standbys) > 0 })...,
+	)
+
+	fmt.Fprintf(md.io.ErrOut, "  Creating green machines\n")
+	greenIDs := map[string]string{}
+	var greens []machine.LeasableMachine
+	for i, e := range ordered {
+		indexStr := formatIndex(i, len(ordered))
+		launchInput := md.launchInputForGreen(e, greenIDs)
+
+		newMachineRaw, err := md.flapsClient.Launch(ctx, *launchInput)
+		if err != nil {
+			err = fmt.Errorf("error creating green machine for %s: %w", e.leasableMachine.FormattedMachineId(), err)
+			return md.abortBlueGreen(ctx, greens, err)
+		}
+		lm := machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
+		greens = append(greens, lm)
+		greenIDs[e.leasableMachine.Machine().ID] = newMachineRaw.ID
+		fmt.Fprintf(md.io.ErrOut, "  %s Created green machine %s for %s\n",
+			indexStr,
+			md.colorize.Bold(lm.FormattedMachineId()),
+			md.colorize.Bold(e.leasableMachine.FormattedMachineId()),
+		)
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "  Waiting for all green machines to be healthy\n")
+	for i, lm := range greens {
+		indexStr := formatIndex(i, len(greens))
+
+		// Don't wait for Standby machines, they are created but not started
+		if len(lm.Machine().Config.Standbys) > 0 {
+			continue
+		}
+		if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
+			return md.abortBlueGreen(ctx, greens, err)
+		}
+		if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, indexStr); err != nil {
+			return md.abortBlueGreen(ctx, greens, err)
+		}
+		md.logClearLinesAbove(ctx, 1)
+		fmt.Fprintf(md.io.ErrOut, "  %s Green machine %s is healthy\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "  Destroying blue machines\n")
+	blues := lo.Map(updateEntries, func(e *machineUpdateEntry, _ int) machine.LeasableMachine { return e.leasableMachine })
+	if err := md.machineSet.RemoveMachines(ctx, blues); err != nil {
+		return err
+	}
+	for i, lm := range blues {
+		indexStr := formatIndex(i, len(blues))
+		if err := lm.Destroy(ctx, true); err != nil {
+			return fmt.Errorf("error destroying blue machine %s: %w", lm.FormattedMachineId(), err)
+		}
+		fmt.Fprintf(md.io.ErrOut, "  %s Destroyed blue machine %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+	return nil
+}
+
+// launchInputForGreen clones the entry's launch input as a brand new machine, rewriting standby
+// references from blue machine IDs to the green machines launched so far.
+func (md *machineDeployment) launchInputForGreen(e *machineUpdateEntry, greenIDs map[string]string) *api.LaunchMachineInput {
+	launchInput := *e.launchInput
+	launchInput.ID = ""
+	launchInput.Config = machine.CloneConfig(e.launchInput.Config)
+	if len(launchInput.Config.Standbys) > 0 {
+		launchInput.Config.Standbys = lo.Map(launchInput.Config.Standbys, func(id string, _ int) string {
+			if greenID, ok := greenIDs[id]; ok {
+				return greenID
+			}
+			return id
+		})
+	}
+	return &launchInput
+}
+
+// abortBlueGreen destroys every green machine launched so far and returns the original error.
+func (md *machineDeployment) abortBlueGreen(ctx context.Context, greens []machine.LeasableMachine, err error) error {
+	fmt.Fprintf(md.io.ErrOut, "  Green machines failed: %s\n", md.colorize.Red(err.Error()))
+	fmt.Fprintf(md.io.ErrOut, "  Destroying %d green machine(s), blue machines were left untouched\n", len(greens))
+	var leftovers []string
+	for _, lm := range greens {
+		if destroyErr := lm.Destroy(ctx, true); destroyErr != nil {
+			terminal.Warnf("failed to destroy green machine %s: %v\n", lm.Machine().ID, destroyErr)
+			leftovers = append(leftovers, lm.Machine().ID)
+		}
+	}
+	if len(leftovers) > 0 {
+		return fmt.Errorf("bluegreen deployment failed: %w; green machines %s could not be destroyed, remove them with `fly machine destroy --force`", err, strings.Join(leftovers, ", "))
+	}
+	return fmt.Errorf("bluegreen deployment failed: %w", err)
+}
@@ -0,0 +1,55 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_launchInputForGreen(t *testing.T) {
+	md, err := stabMachineDeployment(nil)
+	assert.NoError(t, err)
+
+	standby := stubUpdateEntry("standby", "cron", "standby", []string{"cron1", "other"})
+	li := md.launchInputForGreen(standby, map[string]string{"cron1": "green1"})
+
+	assert.Equal(t, "", li.ID)
+	assert.Equal(t, []string{"green1", "other"}, li.Config.Standbys)
+	// The original launch input must be left untouched
+	assert.Equal(t, "standby", standby.launchInput.ID)
+	assert.Equal(t, []string{"cron1", "other"}, standby.launchInput.Config.Standbys)
+}
+
+func bluegreenMachines() []*api.Machine {
+	return []*api.Machine{
+		{ID: "web1", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "web"}}},
+		{ID: "web2", Region: "ord", Config: &api.MachineConfig{Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "web"}}},
+	}
+}
+
+func Test_updateMachinesBlueGreen(t *testing.T) {
+	md, flaps := stubFlapsDeployment(t, bluegreenMachines()...)
+
+	require.NoError(t, md.updateMachinesBlueGreen(context.Background(), updateEntries(md)))
+	assert.True(t, flaps.has("new1"))
+	assert.True(t, flaps.has("new2"))
+	// Blue machines are only destroyed once every green machine is healthy
+	assert.Equal(t, []string{"web1", "web2"}, flaps.destroyedIDs())
+	assert.True(t, md.machineSet.IsEmpty())
+}
+
+func Test_updateMachinesBlueGreen_Abort(t *testing.T) {
+	md, flaps := stubFlapsDeployment(t, bluegreenMachines()...)
+	flaps.failWait["new2"] = 1
+
+	err := md.updateMachinesBlueGreen(context.Background(), updateEntries(md))
+	require.ErrorContains(t, err, "bluegreen deployment failed")
+	// Every green machine is torn down and the blue ones are left untouched
+	assert.Equal(t, []string{"new1", "new2"}, flaps.destroyedIDs())
+	assert.True(t, flaps.has("web1"))
+	assert.True(t, flaps.has("web2"))
+	assert.Len(t, md.machineSet.GetMachines(), 2)
+}
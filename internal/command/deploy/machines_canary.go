@@ -0,0 +1,98 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+// splitCanaryEntries picks one machine per process group to act as canary and returns it apart from the rest.
+// Machines updated in place are preferred over replacements, and standby machines are never picked
+// because they don't start after an update.
+func splitCanaryEntries(entries []*machineUpdateEntry) (canaries, rest []*machineUpdateEntry) {
+	canaryByGroup := map[string]*machineUpdateEntry{}
+	var groups []string
+
+	for _, e := range entries {
+		if len(e.launchInput.Config.Standbys) > 0 {
+			continue
+		}
+		group := e.leasableMachine.Machine().ProcessGroup()
+		current, ok := canaryByGroup[group]
+		switch {
+		case !ok:
+			groups = append(groups, group)
+			canaryByGroup[group] = e
+		case current.launchInput.ID != current.leasableMachine.Machine().ID && e.launchInput.ID == e.leasableMachine.Machine().ID:
+			canaryByGroup[group] = e
+		}
+	}
+
+	for _, group := range groups {
+		canaries = append(canaries, canaryByGroup[group])
+	}
+	for _, e := range entries {
+		if canaryByGroup[e.leasableMachine.Machine().ProcessGroup()] != e {
+			rest = append(rest, e)
+		}
+	}
+	return canaries, rest
+}
+
+// updateCanaryMachines updates the canary machines one at a time and waits for each of them to start and
+// pass its health checks. A failing canary is put back on its previous config and the deployment is aborted.
+func (md *machineDeployment) updateCanaryMachines(ctx context.Context, canaries []*machineUpdateEntry, journal *rollbackJournal) error {
+	if len(canaries) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "  Updating %d canary machine(s), one per process group\n", len(canaries))
+	for i, e := range canaries {
+		indexStr := formatIndex(i, len(canaries))
+
+		entry, err := journal.record(e.leasableMachine, e.launchInput)
+		if err != nil {
+			return fmt.Errorf("failed to save rollback journal: %w", err)
+		}
+
+		lm, err := md.updateMachine(ctx, e, indexStr)
+		if err == nil && entry.replaced() {
+			err = journal.setCurrentID(entry, lm.Machine().ID)
+		}
+		if err == nil {
+			err = md.waitForCanaryMachine(ctx, lm, indexStr)
+		}
+		if err == nil {
+			continue
+		}
+
+		fmt.Fprintf(md.io.ErrOut, "  %s Canary machine %s failed: %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()), md.colorize.Red(err.Error()))
+		fmt.Fprintf(md.io.ErrOut, "  %s Restoring previous configuration of %s\n", indexStr, md.colorize.Bold(e.leasableMachine.FormattedMachineId()))
+		if restoreErr := md.restoreMachine(ctx, journal, entry, e.leasableMachine, indexStr); restoreErr != nil {
+			return fmt.Errorf("canary machine %s failed: %w; also failed to restore its previous configuration: %v", lm.Machine().ID, err, restoreErr)
+		}
+		return fmt.Errorf("canary machine %s failed, aborting deployment: %w", lm.Machine().ID, err)
+	}
+	fmt.Fprintf(md.io.ErrOut, "  Canary machines are healthy, continuing with the rest\n")
+	return nil
+}
+
+// waitForCanaryMachine always waits for the canary to start and pass its health checks,
+// as that is what decides whether the deployment can go on.
+func (md *machineDeployment) waitForCanaryMachine(ctx context.Context, lm machine.LeasableMachine, indexStr string) error {
+	if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
+		return err
+	}
+	if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, indexStr); err != nil {
+		return err
+	}
+	md.logClearLinesAbove(ctx, 1)
+	fmt.Fprintf(md.io.ErrOut, "  %s Canary machine %s update finished: %s\n",
+		indexStr,
+		md.colorize.Bold(lm.FormattedMachineId()),
+		md.colorize.Green("success"),
+	)
+	return nil
+}
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_splitCanaryEntries(t *testing.T) {
+	web1 := stubUpdateEntry("web1", "web", "", nil)
+	web2 := stubUpdateEntry("web2", "web", "web2", nil)
+	worker1 := stubUpdateEntry("worker1", "worker", "worker1", nil)
+	worker2 := stubUpdateEntry("worker2", "worker", "worker2", nil)
+	standby := stubUpdateEntry("standby", "cron", "standby", []string{"cron1"})
+
+	canaries, rest := splitCanaryEntries([]*machineUpdateEntry{web1, web2, worker1, worker2, standby})
+	assert.Equal(t, []*machineUpdateEntry{web2, worker1}, canaries)
+	assert.Equal(t, []*machineUpdateEntry{web1, worker2, standby}, rest)
+}
+
+func Test_splitCanaryEntries_Empty(t *testing.T) {
+	canaries, rest := splitCanaryEntries(nil)
+	assert.Empty(t, canaries)
+	assert.Empty(t, rest)
+}
+
+func Test_updateCanaryMachines(t *testing.T) {
+	cases := []struct {
+		name string
+		// replace updates the machines by replacing them rather than in place
+		replace bool
+		setup   func(f *fakeFlaps)
+		wantErr string
+		// restored is the machine expected on the previous config once the canary failed
+		restored      string
+		wantDestroyed []string
+		wantLaunches  int
+	}{
+		{
+			name: "healthy",
+		},
+		{
+			name:     "fails to start",
+			setup:    func(f *fakeFlaps) { f.failWait["web1"] = 1 },
+			wantErr:  "canary machine web1 failed, aborting deployment",
+			restored: "web1",
+		},
+		{
+			name: "fails its health checks",
+			setup: func(f *fakeFlaps) {
+				f.machines["web1"].Checks = []*api.MachineCheckStatus{{Name: "alive", Status: "critical"}}
+			},
+			wantErr:  "canary machine web1 failed, aborting deployment",
+			restored: "web1",
+		},
+		{
+			name:          "replacement fails to start",
+			replace:       true,
+			setup:         func(f *fakeFlaps) { f.failWait["new1"] = 1 },
+			wantErr:       "canary machine new1 failed, aborting deployment",
+			restored:      "new2",
+			wantDestroyed: []string{"web1", "new1"},
+			wantLaunches:  2,
+		},
+		{
+			// the original was never destroyed, so it is kept rather than launched again
+			name:     "original fails to be replaced",
+			replace:  true,
+			setup:    func(f *fakeFlaps) { f.failDestroy["web1"] = true },
+			wantErr:  "canary machine web1 failed, aborting deployment",
+			restored: "web1",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			md, flaps := stubFlapsDeployment(t,
+				&api.Machine{ID: "web1", Region: "ams", State: api.MachineStateStarted, Config: versionConfig("1")},
+				&api.Machine{ID: "web2", Region: "ams", State: api.MachineStateStarted, Config: versionConfig("1")},
+			)
+			md.strategy = "canary"
+			md.waitTimeout = time.Second
+			if tc.setup != nil {
+				tc.setup(flaps)
+			}
+
+			ctx := context.Background()
+			require.NoError(t, md.machineSet.AcquireLeases(ctx, time.Minute))
+			entries := updateEntries(md)
+			for _, e := range entries {
+				e.launchInput.Config = versionConfig("2")
+				// health checks are polled at half their shortest interval
+				e.launchInput.Config.Checks = map[string]api.MachineCheck{
+					"alive": {Interval: &api.Duration{Duration: 100 * time.Millisecond}},
+				}
+				if tc.replace {
+					e.launchInput.ID = ""
+				}
+			}
+
+			err := md.updateMachineGroup(ctx, entries, md.newRollbackJournal())
+			assert.Equal(t, tc.wantDestroyed, flaps.destroyedIDs())
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, "2", flaps.get("web1").Config.Env["VERSION"])
+				assert.Equal(t, "2", flaps.get("web2").Config.Env["VERSION"])
+				return
+			}
+
+			require.ErrorContains(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantLaunches, flaps.launched())
+			restored := flaps.get(tc.restored)
+			require.NotNil(t, restored, tc.restored)
+			assert.Equal(t, "1", restored.Config.Env["VERSION"])
+			// the rest of the machines are left alone
+			assert.Equal(t, "1", flaps.get("web2").Config.Env["VERSION"])
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/superfly/flyctl/terminal"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
 )
 
 type ProcessGroupsDiff struct {
@@ -78,14 +79,20 @@ func (md *machineDeployment) restartMachinesApp(ctx context.Context) error {
 
 // deployMachinesApp executes the following flow:
 //   - Run release command
+//   - Run pre_deploy hooks
 //   - Remove spare machines from removed groups
 //   - Launch new machines on new groups
 //   - Update existing machines
+//   - Run post_deploy hooks
 func (md *machineDeployment) deployMachinesApp(ctx context.Context) error {
 	if err := md.runReleaseCommand(ctx); err != nil {
 		return fmt.Errorf("release command failed - aborting deployment. %w", err)
 	}
 
+	if err := md.runPreDeployHooks(ctx); err != nil {
+		return fmt.Errorf("pre_deploy hook failed - aborting deployment. %w", err)
+	}
+
 	if err := md.machineSet.AcquireLeases(ctx, md.leaseTimeout); err != nil {
 		return err
 	}
@@ -178,6 +185,9 @@ func (md *machineDeployment) deployMachinesApp(ctx context.Context) error {
 type machineUpdateEntry struct {
 	leasableMachine machine.LeasableMachine
 	launchInput     *api.LaunchMachineInput
+	// updatedMachine is the machine running launchInput once updated,
+	// it differs from leasableMachine when the machine is replaced
+	updatedMachine machine.LeasableMachine
 }
 
 func formatIndex(n, total int) string {
@@ -189,80 +199,194 @@ func formatIndex(n, total int) string {
 }
 
 func (md *machineDeployment) updateExistingMachines(ctx context.Context, updateEntries []*machineUpdateEntry) error {
-	// FIXME: handle deploy strategy: rolling, immediate, canary, bluegreen
 	fmt.Fprintf(md.io.Out, "Updating existing machines in '%s' with %s strategy\n", md.colorize.Bold(md.app.Name), md.strategy)
-	for i, e := range updateEntries {
-		lm := e.leasableMachine
-		launchInput := e.launchInput
-		indexStr := formatIndex(i, len(updateEntries))
-
-		if launchInput.ID != lm.Machine().ID {
-			// If IDs don't match, destroy the original machine and launch a new one
-			// This can be the case for machines that changes its volumes or any other immutable config
-			fmt.Fprintf(md.io.ErrOut, "  %s Replacing %s by new machine\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
-			if err := lm.Destroy(ctx, true); err != nil {
-				if md.strategy != "immediate" {
-					return err
-				}
-				fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
-			}
-
-			newMachineRaw, err := md.flapsClient.Launch(ctx, *launchInput)
-			if err != nil {
-				if md.strategy != "immediate" {
-					return err
-				}
-				fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
-				continue
-			}
 
-			lm = machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
-			fmt.Fprintf(md.io.ErrOut, "  %s Created machine %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+	// Restarts keep the machines in place, there is nothing to cut over to
+	if md.strategy == "bluegreen" && !md.restartOnly {
+		if len(md.regionOrder) > 0 {
+			terminal.Warnf("region order is ignored by the bluegreen strategy, all regions are cut over at once\n")
+		}
+		if err := md.updateMachinesBlueGreen(ctx, updateEntries); err != nil {
+			return err
+		}
+		// Blue machines are gone by now, there is nothing to roll back to
+		return md.runPostDeployHooks(ctx)
+	}
 
-		} else {
-			fmt.Fprintf(md.io.ErrOut, "  %s Updating %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
-			if err := lm.Update(ctx, *launchInput); err != nil {
-				if md.strategy != "immediate" {
-					return err
-				}
-				fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
-			}
+	journal := md.newRollbackJournal()
+	err := md.updateMachinesInOrder(ctx, updateEntries, journal)
+	if err == nil {
+		fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+		if !md.restartOnly {
+			err = md.runPostDeployHooks(ctx)
+		}
+	}
+	switch {
+	case err == nil:
+		return journal.remove()
+	case !md.rollbackOnFailure || errors.Is(err, context.Canceled):
+		if removeErr := journal.remove(); removeErr != nil {
+			terminal.Warnf("failed to remove rollback journal: %v\n", removeErr)
 		}
+		return err
+	}
 
-		// Don't wait for Standby machines, they are updated but not started
-		if len(launchInput.Config.Standbys) > 0 {
-			md.logClearLinesAbove(1)
-			fmt.Fprintf(md.io.ErrOut, "  %s Machine %s update finished: %s\n",
-				indexStr,
-				md.colorize.Bold(lm.FormattedMachineId()),
-				md.colorize.Green("success"),
-			)
-			continue
+	fmt.Fprintf(md.io.ErrOut, "Deployment failed: %s\n", md.colorize.Red(err.Error()))
+	if rollbackErr := md.rollbackMachines(ctx, journal, md.machineSet); rollbackErr != nil {
+		return fmt.Errorf("%w; rollback failed: %v", err, rollbackErr)
+	}
+	return fmt.Errorf("%w; machines were rolled back to their previous configuration", err)
+}
+
+// updateMachinesInOrder updates machines region by region when there is a region order,
+// or all at once otherwise. The previous config of every machine is recorded in journal.
+func (md *machineDeployment) updateMachinesInOrder(ctx context.Context, updateEntries []*machineUpdateEntry, journal *rollbackJournal) error {
+	if len(md.regionOrder) > 0 {
+		return md.updateMachinesByRegion(ctx, updateEntries, journal)
+	}
+	return md.updateMachineGroup(ctx, updateEntries, journal)
+}
+
+// updateMachineGroup updates machines starting with the canaries when using the canary strategy.
+// The rest are updated one at a time, or up to max_unavailable at once.
+func (md *machineDeployment) updateMachineGroup(ctx context.Context, updateEntries []*machineUpdateEntry, journal *rollbackJournal) error {
+	if md.strategy == "canary" {
+		canaries, rest := splitCanaryEntries(updateEntries)
+		if err := md.updateCanaryMachines(ctx, canaries, journal); err != nil {
+			return err
 		}
+		updateEntries = rest
+	}
+
+	if md.maxUnavailable > 1 && md.strategy != "immediate" {
+		return md.updateMachinesConcurrently(ctx, updateEntries, journal)
+	}
 
-		if md.strategy == "immediate" {
-			continue
+	for i, e := range updateEntries {
+		if err := md.updateAndWaitForMachine(ctx, e, journal, formatIndex(i, len(updateEntries))); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// updateMachinesConcurrently updates up to maxUnavailable machines at once. The first failure
+// stops new updates from starting and is returned once the ones in flight are done.
+func (md *machineDeployment) updateMachinesConcurrently(ctx context.Context, updateEntries []*machineUpdateEntry, journal *rollbackJournal) error {
+	fmt.Fprintf(md.io.ErrOut, "  Updating up to %d machines at a time\n", md.maxUnavailable)
+
+	// machines updated at once can't redraw their progress lines in place
+	g, gctx := errgroup.WithContext(machine.WithoutRedraw(ctx))
+	g.SetLimit(md.maxUnavailable)
+	for i, e := range updateEntries {
+		i, e := i, e
+		g.Go(func() error {
+			if gctx.Err() != nil {
+				return nil
+			}
+			return md.updateAndWaitForMachine(gctx, e, journal, formatIndex(i, len(updateEntries)))
+		})
+	}
+	return g.Wait()
+}
 
-		if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
+// updateAndWaitForMachine records the machine's config in journal, updates it and waits for it to be ready.
+func (md *machineDeployment) updateAndWaitForMachine(ctx context.Context, e *machineUpdateEntry, journal *rollbackJournal, indexStr string) error {
+	entry, err := journal.record(e.leasableMachine, e.launchInput)
+	if err != nil {
+		return fmt.Errorf("failed to save rollback journal: %w", err)
+	}
+
+	lm, err := md.updateMachine(ctx, e, indexStr)
+	if err != nil {
+		if md.strategy != "immediate" {
 			return err
 		}
+		fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
+		return nil
+	}
+	if entry.replaced() {
+		if err := journal.setCurrentID(entry, lm.Machine().ID); err != nil {
+			return fmt.Errorf("failed to save rollback journal: %w", err)
+		}
+	}
 
-		if !md.skipHealthChecks {
-			if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, indexStr); err != nil {
-				return err
+	return md.waitForUpdatedMachine(ctx, lm, e.launchInput, indexStr)
+}
+
+// updateMachine applies the entry's launch input to its machine, replacing the machine when its ID changes.
+// It returns the machine that now runs the new config, which is a new one in case of replacement.
+func (md *machineDeployment) updateMachine(ctx context.Context, e *machineUpdateEntry, indexStr string) (machine.LeasableMachine, error) {
+	lm := e.leasableMachine
+	launchInput := e.launchInput
+
+	if launchInput.ID != lm.Machine().ID {
+		// If IDs don't match, destroy the original machine and launch a new one
+		// This can be the case for machines that changes its volumes or any other immutable config
+		fmt.Fprintf(md.io.ErrOut, "  %s Replacing %s by new machine\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+		if err := lm.Destroy(ctx, true); err != nil {
+			if md.strategy != "immediate" {
+				return lm, err
 			}
-			// FIXME: combine this wait with the wait for start as one update line (or two per in noninteractive case)
-			md.logClearLinesAbove(1)
-			fmt.Fprintf(md.io.ErrOut, "  %s Machine %s update finished: %s\n",
-				indexStr,
-				md.colorize.Bold(lm.FormattedMachineId()),
-				md.colorize.Green("success"),
-			)
+			fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
+		}
+
+		newMachineRaw, err := md.flapsClient.Launch(ctx, *launchInput)
+		if err != nil {
+			return lm, err
+		}
+
+		lm = machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
+		e.updatedMachine = lm
+		fmt.Fprintf(md.io.ErrOut, "  %s Created machine %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+		return lm, nil
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "  %s Updating %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+	if err := lm.Update(ctx, *launchInput); err != nil {
+		if md.strategy != "immediate" {
+			return lm, err
 		}
+		fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
+	}
+	e.updatedMachine = lm
+	return lm, nil
+}
+
+// waitForUpdatedMachine waits for an updated machine to start and pass its health checks
+// unless the deployment strategy or the machine's standby role says otherwise.
+func (md *machineDeployment) waitForUpdatedMachine(ctx context.Context, lm machine.LeasableMachine, launchInput *api.LaunchMachineInput, indexStr string) error {
+	// Don't wait for Standby machines, they are updated but not started
+	if len(launchInput.Config.Standbys) > 0 {
+		md.logClearLinesAbove(ctx, 1)
+		fmt.Fprintf(md.io.ErrOut, "  %s Machine %s update finished: %s\n",
+			indexStr,
+			md.colorize.Bold(lm.FormattedMachineId()),
+			md.colorize.Green("success"),
+		)
+		return nil
+	}
+
+	if md.strategy == "immediate" {
+		return nil
+	}
+
+	if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
+		return err
 	}
 
-	fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+	if !md.skipHealthChecks {
+		if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, indexStr); err != nil {
+			return err
+		}
+		// FIXME: combine this wait with the wait for start as one update line (or two per in noninteractive case)
+		md.logClearLinesAbove(ctx, 1)
+		fmt.Fprintf(md.io.ErrOut, "  %s Machine %s update finished: %s\n",
+			indexStr,
+			md.colorize.Bold(lm.FormattedMachineId()),
+			md.colorize.Green("success"),
+		)
+	}
 	return nil
 }
 
@@ -306,7 +430,7 @@ func (md *machineDeployment) spawnMachineInGroup(ctx context.Context, groupName
 			return "", err
 		}
 
-		md.logClearLinesAbove(1)
+		md.logClearLinesAbove(ctx, 1)
 		fmt.Fprintf(md.io.ErrOut, "  Machine %s update finished: %s\n",
 			md.colorize.Bold(lm.FormattedMachineId()),
 			md.colorize.Green("success"),
@@ -0,0 +1,113 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+// lockedBuffer is a bytes.Buffer that machines updated at once can write to.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func stubConcurrentDeployment(t *testing.T, count int) (*machineDeployment, *fakeFlaps, *lockedBuffer) {
+	var machines []*api.Machine
+	for i := 1; i <= count; i++ {
+		machines = append(machines, &api.Machine{
+			ID:     fmt.Sprintf("web%d", i),
+			Config: &api.MachineConfig{Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "web"}},
+		})
+	}
+	md, flaps := stubFlapsDeployment(t, machines...)
+	md.strategy = "rolling"
+	md.maxUnavailable = 2
+
+	out := &lockedBuffer{}
+	md.io.ErrOut = out
+	md.io.SetStdinTTY(true)
+	md.io.SetStdoutTTY(true)
+
+	require.NoError(t, md.machineSet.AcquireLeases(context.Background(), time.Minute))
+	return md, flaps, out
+}
+
+func Test_updateMachinesConcurrently(t *testing.T) {
+	md, flaps, out := stubConcurrentDeployment(t, 5)
+
+	var (
+		mu                sync.Mutex
+		inFlight, maxSeen int
+		waited            []string
+	)
+	flaps.hook = func(method, id, action string) {
+		if action != "wait" {
+			return
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		waited = append(waited, id)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}
+
+	require.NoError(t, md.updateMachinesConcurrently(context.Background(), updateEntries(md), &rollbackJournal{}))
+	assert.Equal(t, 2, maxSeen, "machines updated at once must never exceed max_unavailable")
+	assert.ElementsMatch(t, []string{"web1", "web2", "web3", "web4", "web5"}, waited)
+	// Progress lines can't be redrawn in place when several machines report at once
+	assert.NotContains(t, out.String(), "\x1b[1A")
+	assert.Contains(t, out.String(), "update finished")
+}
+
+func Test_updateMachinesConcurrently_Error(t *testing.T) {
+	md, flaps, _ := stubConcurrentDeployment(t, 5)
+	flaps.failWait["web1"] = 1
+
+	var (
+		mu      sync.Mutex
+		updated []string
+	)
+	flaps.hook = func(method, id, action string) {
+		if action == "" && method == "POST" {
+			mu.Lock()
+			updated = append(updated, id)
+			mu.Unlock()
+		}
+		if action == "wait" && id != "web1" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	err := md.updateMachinesConcurrently(context.Background(), updateEntries(md), &rollbackJournal{})
+	require.ErrorContains(t, err, "web1")
+	// The failure stops machines that weren't being updated yet from starting
+	assert.ElementsMatch(t, []string{"web1", "web2"}, updated)
+}
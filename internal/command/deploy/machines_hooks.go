@@ -0,0 +1,199 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/logs"
+	"github.com/superfly/flyctl/terminal"
+)
+
+func (md *machineDeployment) runPreDeployHooks(ctx context.Context) error {
+	if md.appConfig.Deploy == nil {
+		return nil
+	}
+	return md.runDeployHooks(ctx, "pre_deploy", md.appConfig.Deploy.PreDeploy)
+}
+
+func (md *machineDeployment) runPostDeployHooks(ctx context.Context) error {
+	if md.appConfig.Deploy == nil {
+		return nil
+	}
+	return md.runDeployHooks(ctx, "post_deploy", md.appConfig.Deploy.PostDeploy)
+}
+
+// runDeployHooks runs hooks one after another and stops at the first one that fails.
+// Hooks without a timeout get the deployment wait timeout.
+func (md *machineDeployment) runDeployHooks(ctx context.Context, stage string, hooks []appconfig.DeployHook) error {
+	for i, hook := range hooks {
+		indexStr := formatIndex(i, len(hooks))
+		timeout := md.waitTimeout
+		if hook.Timeout != nil {
+			timeout = hook.Timeout.Duration
+		}
+
+		fmt.Fprintf(md.io.ErrOut, "Running %s %s hook %s: %s\n", md.colorize.Bold(md.app.Name), stage, indexStr, hook.Command)
+		var err error
+		if hook.RunsOnMachine() {
+			err = md.execDeployHook(ctx, hook, timeout, indexStr)
+		} else {
+			err = md.runDeployHookMachine(ctx, hook, timeout, indexStr)
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %w", stage, hook.Command, err)
+		}
+		fmt.Fprintf(md.io.ErrOut, "  %s %s hook completed successfully\n", indexStr, stage)
+	}
+	return nil
+}
+
+// execDeployHook runs the hook on one of the started app machines and prints its output.
+func (md *machineDeployment) execDeployHook(ctx context.Context, hook appconfig.DeployHook, timeout time.Duration, indexStr string) error {
+	machines, _, err := md.flapsClient.ListFlyAppsMachines(ctx)
+	if err != nil {
+		return err
+	}
+	m := pickDeployHookMachine(machines, md.appConfig.DefaultProcessName())
+	if m == nil {
+		return fmt.Errorf("no started machine to run the hook on")
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "  %s Running on machine %s\n", indexStr, md.colorize.Bold(m.ID))
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out, err := md.flapsClient.Exec(hookCtx, m.ID, &api.MachineExecRequest{
+		Cmd:     hook.Command,
+		Timeout: int(timeout.Seconds()),
+	})
+	switch {
+	case errors.Is(hookCtx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("timed out after %s", timeout)
+	case err != nil:
+		return err
+	}
+
+	for _, output := range []string{out.StdOut, out.StdErr} {
+		for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+			if line != "" {
+				fmt.Fprintf(md.io.ErrOut, "    %s\n", line)
+			}
+		}
+	}
+	if out.ExitCode != 0 {
+		return fmt.Errorf("exited with non-zero status of %d on machine %s", out.ExitCode, m.ID)
+	}
+	return nil
+}
+
+// pickDeployHookMachine returns the first started machine that isn't a standby, preferring
+// the ones in the default process group. It returns nil if there is none.
+func pickDeployHookMachine(machines []*api.Machine, defaultGroup string) *api.Machine {
+	var picked *api.Machine
+	for _, m := range machines {
+		if m.State != api.MachineStateStarted || m.Config == nil || len(m.Config.Standbys) > 0 {
+			continue
+		}
+		if m.ProcessGroup() == defaultGroup {
+			return m
+		}
+		if picked == nil {
+			picked = m
+		}
+	}
+	return picked
+}
+
+// runDeployHookMachine runs the hook in its own ephemeral machine, the same way the release command runs,
+// and streams the machine logs until it exits. The machine is destroyed if the hook times out.
+func (md *machineDeployment) runDeployHookMachine(ctx context.Context, hook appconfig.DeployHook, timeout time.Duration, indexStr string) error {
+	mConfig, err := md.appConfig.ToDeployHookMachineConfig(hook)
+	if err != nil {
+		return err
+	}
+	mConfig.Guest = md.inferReleaseCommandGuest()
+	mConfig.Image = md.img
+	md.setMachineReleaseData(mConfig)
+
+	hookMachineRaw, err := md.flapsClient.Launch(ctx, api.LaunchMachineInput{
+		AppID:   md.app.Name,
+		OrgSlug: md.app.Organization.ID,
+		Config:  mConfig,
+		Region:  md.appConfig.PrimaryRegion,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating a hook machine: %w", err)
+	}
+	hookMachine := machine.NewLeasableMachine(md.flapsClient, md.io, hookMachineRaw)
+	fmt.Fprintf(md.io.ErrOut, "  %s Created hook machine %s\n", indexStr, md.colorize.Bold(hookMachineRaw.ID))
+
+	logsCtx, stopLogs := context.WithCancel(ctx)
+	defer stopLogs()
+	go md.streamDeployHookLogs(logsCtx, hookMachineRaw.ID)
+
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := md.waitForDeployHookMachine(hookCtx, hookMachine, timeout); err != nil {
+		if !errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+			return err
+		}
+		if destroyErr := hookMachine.Destroy(ctx, true); destroyErr != nil {
+			terminal.Warnf("failed to destroy hook machine %s: %v\n", hookMachineRaw.ID, destroyErr)
+		}
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	exitEvent, err := hookMachine.WaitForEventTypeAfterType(ctx, "exit", "start", md.waitTimeout)
+	if err != nil {
+		return fmt.Errorf("error finding the hook machine %s exit event: %w", hookMachineRaw.ID, err)
+	}
+	exitCode, err := exitEvent.Request.GetExitCode()
+	if err != nil {
+		return fmt.Errorf("error getting hook machine %s exit code: %w", hookMachineRaw.ID, err)
+	}
+
+	time.Sleep(2 * time.Second) // Wait 2 secs to be sure the last logs got streamed
+	if exitCode != 0 {
+		return fmt.Errorf("hook machine %s exited with non-zero status of %d", hookMachineRaw.ID, exitCode)
+	}
+	return nil
+}
+
+func (md *machineDeployment) waitForDeployHookMachine(ctx context.Context, hookMachine machine.LeasableMachine, timeout time.Duration) error {
+	err := hookMachine.WaitForState(ctx, api.MachineStateStarted, timeout, "")
+	if err != nil {
+		var flapsErr *flaps.FlapsError
+		if errors.As(err, &flapsErr) && flapsErr.ResponseStatusCode == http.StatusNotFound {
+			// The machine exited and was destroyed quickly.
+			return nil
+		}
+		return fmt.Errorf("error waiting for hook machine %s to start: %w", hookMachine.Machine().ID, err)
+	}
+	err = hookMachine.WaitForState(ctx, api.MachineStateDestroyed, timeout, "")
+	if err != nil {
+		return fmt.Errorf("error waiting for hook machine %s to finish running: %w", hookMachine.Machine().ID, err)
+	}
+	return nil
+}
+
+func (md *machineDeployment) streamDeployHookLogs(ctx context.Context, machineID string) {
+	opts := &logs.LogOptions{
+		AppName: md.app.Name,
+		VMID:    machineID,
+	}
+	stream, err := logs.NewPollingStream(md.apiClient, opts)
+	if err != nil {
+		terminal.Debugf("failed to stream hook machine %s logs: %v\n", machineID, err)
+		return
+	}
+	for entry := range stream.Stream(ctx, opts) {
+		fmt.Fprintf(md.io.ErrOut, "    %s\n", entry.Message)
+	}
+}
@@ -0,0 +1,29 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_pickDeployHookMachine(t *testing.T) {
+	newMachine := func(id, state, group string, standbys []string) *api.Machine {
+		return &api.Machine{
+			ID:    id,
+			State: state,
+			Config: &api.MachineConfig{
+				Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: group},
+				Standbys: standbys,
+			},
+		}
+	}
+	stoppedApp := newMachine("app1", api.MachineStateStopped, "app", nil)
+	standbyApp := newMachine("app2", api.MachineStateStarted, "app", []string{"app1"})
+	worker := newMachine("worker1", api.MachineStateStarted, "worker", nil)
+	app := newMachine("app3", api.MachineStateStarted, "app", nil)
+
+	assert.Equal(t, app, pickDeployHookMachine([]*api.Machine{stoppedApp, standbyApp, worker, app}, "app"))
+	assert.Equal(t, worker, pickDeployHookMachine([]*api.Machine{stoppedApp, standbyApp, worker}, "app"))
+	assert.Nil(t, pickDeployHookMachine([]*api.Machine{stoppedApp, standbyApp}, "app"))
+}
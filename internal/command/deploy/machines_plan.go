@@ -0,0 +1,322 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	planActionUpdate  = "update"
+	planActionReplace = "replace"
+	planActionCreate  = "create"
+	planActionDestroy = "destroy"
+)
+
+// DeploymentPlan describes what a machines deployment would do without doing it.
+// Steps lists what the deployment does in order, release commands and hooks included.
+type DeploymentPlan struct {
+	App      string           `json:"app"`
+	Image    string           `json:"image"`
+	Strategy string           `json:"strategy"`
+	Steps    []string         `json:"steps"`
+	Summary  map[string]int   `json:"summary"`
+	Machines []*PlannedChange `json:"machines"`
+}
+
+// PlannedChange is the change planned for a single machine. ID is empty for machines to be created,
+// and Diff is only set for machines updated or replaced.
+type PlannedChange struct {
+	Action       string `json:"action"`
+	ID           string `json:"id,omitempty"`
+	ProcessGroup string `json:"process_group"`
+	Region       string `json:"region"`
+	Standby      bool   `json:"standby,omitempty"`
+	Canary       bool   `json:"canary,omitempty"`
+	Diff         string `json:"diff,omitempty"`
+}
+
+func (p *DeploymentPlan) add(change *PlannedChange) {
+	p.Machines = append(p.Machines, change)
+	p.Summary[change.Action]++
+}
+
+// PlanMachinesApp resolves the changes DeployMachinesApp would apply. It only reads from
+// the API, so it can run on a deployment created with DryRun.
+func (md *machineDeployment) PlanMachinesApp(ctx context.Context) (*DeploymentPlan, error) {
+	plan := &DeploymentPlan{
+		App:      md.app.Name,
+		Image:    md.img,
+		Strategy: md.strategy,
+		Summary: map[string]int{
+			planActionUpdate:  0,
+			planActionReplace: 0,
+			planActionCreate:  0,
+			planActionDestroy: 0,
+		},
+	}
+
+	processGroupMachineDiff := md.resolveProcessGroupChanges()
+	removed := map[string]bool{}
+	for _, lm := range processGroupMachineDiff.machinesToRemove {
+		m := lm.Machine()
+		removed[m.ID] = true
+		plan.add(&PlannedChange{
+			Action:       planActionDestroy,
+			ID:           m.ID,
+			ProcessGroup: m.ProcessGroup(),
+			Region:       m.Region,
+		})
+	}
+
+	groupNames := maps.Keys(processGroupMachineDiff.groupsNeedingMachines)
+	slices.Sort(groupNames)
+	for _, name := range groupNames {
+		groupConfig, err := md.appConfig.Flatten(name)
+		if err != nil {
+			return nil, err
+		}
+		plan.add(&PlannedChange{Action: planActionCreate, ProcessGroup: name, Region: md.appConfig.PrimaryRegion})
+
+		// Same as deployMachinesApp, groups with mounts get a single machine,
+		// groups with services a second one and the rest a standby
+		switch {
+		case len(groupConfig.Mounts) > 0:
+		case len(groupConfig.AllServices()) > 0:
+			plan.add(&PlannedChange{Action: planActionCreate, ProcessGroup: name, Region: md.appConfig.PrimaryRegion})
+		default:
+			plan.add(&PlannedChange{Action: planActionCreate, ProcessGroup: name, Region: md.appConfig.PrimaryRegion, Standby: true})
+		}
+	}
+
+	var (
+		entries []*machineUpdateEntry
+		changes = map[*machineUpdateEntry]*PlannedChange{}
+	)
+	for _, lm := range md.machineSet.GetMachines() {
+		m := lm.Machine()
+		if removed[m.ID] {
+			continue
+		}
+		li, err := md.launchInputForUpdate(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update machine configuration for %s: %w", lm.FormattedMachineId(), err)
+		}
+
+		// Blue-green deployments replace every machine with a green clone
+		action := planActionUpdate
+		if li.ID != m.ID || md.strategy == "bluegreen" {
+			action = planActionReplace
+		}
+		e := &machineUpdateEntry{leasableMachine: lm, launchInput: li}
+		entries = append(entries, e)
+		changes[e] = &PlannedChange{
+			Action:       action,
+			ID:           m.ID,
+			ProcessGroup: m.ProcessGroup(),
+			Region:       m.Region,
+			Standby:      len(li.Config.Standbys) > 0,
+			Diff:         machine.ConfigCompare(ctx, *m.Config, *configWithReleaseDataOf(li.Config, m.Config)),
+		}
+		plan.add(changes[e])
+	}
+
+	updateSteps, err := md.planUpdateSteps(entries, changes)
+	if err != nil {
+		return nil, err
+	}
+	plan.Steps = md.planSteps(plan, updateSteps)
+
+	return plan, nil
+}
+
+// planSteps lists the steps of the deployment in the order deployMachinesApp runs them.
+func (md *machineDeployment) planSteps(plan *DeploymentPlan, updateSteps []string) []string {
+	var steps []string
+	for _, step := range md.appConfig.Deploy.ReleaseCommandSteps() {
+		steps = append(steps, fmt.Sprintf("Run release command: %s", step.Command))
+	}
+
+	var preDeploy, postDeploy []appconfig.DeployHook
+	if md.appConfig.Deploy != nil {
+		preDeploy, postDeploy = md.appConfig.Deploy.PreDeploy, md.appConfig.Deploy.PostDeploy
+	}
+	steps = append(steps, planHookSteps("pre_deploy", preDeploy)...)
+
+	if n := plan.Summary[planActionDestroy]; n > 0 {
+		steps = append(steps, fmt.Sprintf("Destroy %d machine(s) of removed process groups", n))
+	}
+	if n := plan.Summary[planActionCreate]; n > 0 {
+		steps = append(steps, fmt.Sprintf("Create %d machine(s) for new process groups", n))
+	}
+	steps = append(steps, updateSteps...)
+
+	if md.rollbackOnFailure && md.strategy != "bluegreen" && len(updateSteps) > 0 {
+		steps = append(steps, "Roll back the updated machines if the deployment fails")
+	}
+	return append(steps, planHookSteps("post_deploy", postDeploy)...)
+}
+
+func planHookSteps(stage string, hooks []appconfig.DeployHook) []string {
+	steps := make([]string, 0, len(hooks))
+	for _, hook := range hooks {
+		where := "in a new machine"
+		if hook.RunsOnMachine() {
+			where = "on a running machine"
+		}
+		steps = append(steps, fmt.Sprintf("Run %s hook %s: %s", stage, where, hook.Command))
+	}
+	return steps
+}
+
+// planUpdateSteps describes how the existing machines are updated with the deployment strategy,
+// marking the canaries among changes. It fails like the deployment would for strategies the
+// machines don't support.
+func (md *machineDeployment) planUpdateSteps(entries []*machineUpdateEntry, changes map[*machineUpdateEntry]*PlannedChange) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	switch md.strategy {
+	case "bluegreen":
+		for _, e := range entries {
+			if len(e.launchInput.Config.Mounts) > 0 {
+				return nil, fmt.Errorf(
+					"machine %s has a volume attached and can't be cloned; bluegreen strategy doesn't support machines with volumes, use rolling or canary instead",
+					e.leasableMachine.FormattedMachineId(),
+				)
+			}
+		}
+		return []string{fmt.Sprintf("Create a green clone of %d machine(s), wait for all of them to be healthy, then destroy the blue ones", len(entries))}, nil
+	case "immediate":
+		return []string{fmt.Sprintf("Update %d machine(s) at once without waiting for health checks", len(entries))}, nil
+	}
+
+	if len(md.regionOrder) == 0 {
+		return md.planGroupUpdateSteps(entries, changes), nil
+	}
+
+	var steps []string
+	regions, groups := groupEntriesByRegion(entries, md.regionOrder)
+	for i, region := range regions {
+		steps = append(steps, fmt.Sprintf("Deploy region %s", region))
+		steps = append(steps, md.planGroupUpdateSteps(groups[region], changes)...)
+		if i < len(regions)-1 && md.regionBakeTime > 0 {
+			steps = append(steps, fmt.Sprintf("Bake region %s for %s", region, md.regionBakeTime))
+		}
+	}
+	return steps, nil
+}
+
+// planGroupUpdateSteps mirrors updateMachineGroup.
+func (md *machineDeployment) planGroupUpdateSteps(entries []*machineUpdateEntry, changes map[*machineUpdateEntry]*PlannedChange) []string {
+	var steps []string
+	if md.strategy == "canary" {
+		canaries, rest := splitCanaryEntries(entries)
+		if len(canaries) > 0 {
+			ids := make([]string, 0, len(canaries))
+			for _, e := range canaries {
+				changes[e].Canary = true
+				ids = append(ids, e.leasableMachine.Machine().ID)
+			}
+			steps = append(steps, fmt.Sprintf("Update canary machine(s) %s one at a time and abort if any is unhealthy", strings.Join(ids, ", ")))
+		}
+		entries = rest
+	}
+
+	switch {
+	case len(entries) == 0:
+	case md.maxUnavailable > 1 && len(entries) > 1:
+		steps = append(steps, fmt.Sprintf("Update %d machine(s), up to %d at a time", len(entries), md.maxUnavailable))
+	default:
+		steps = append(steps, fmt.Sprintf("Update %d machine(s) one at a time", len(entries)))
+	}
+	return steps
+}
+
+// configWithReleaseDataOf returns a copy of target with the release metadata of orig, so the
+// release that doesn't exist yet on a dry run doesn't show up in every diff.
+func configWithReleaseDataOf(target, orig *api.MachineConfig) *api.MachineConfig {
+	cfg := machine.CloneConfig(target)
+	for _, key := range []string{api.MachineConfigMetadataKeyFlyReleaseId, api.MachineConfigMetadataKeyFlyReleaseVersion} {
+		if v, ok := orig.Metadata[key]; ok {
+			cfg.Metadata[key] = v
+		} else {
+			delete(cfg.Metadata, key)
+		}
+	}
+	return cfg
+}
+
+// printDeploymentPlan prints the plan of md as a table followed by the config diff of every
+// machine, or as JSON with --json. Diffs are never colorized in JSON.
+func printDeploymentPlan(ctx context.Context, md MachineDeployment) error {
+	io := iostreams.FromContext(ctx)
+	jsonOutput := config.FromContext(ctx).JSONOutput
+	if jsonOutput {
+		noColor := *io
+		noColor.SetColorEnabled(false)
+		ctx = iostreams.NewContext(ctx, &noColor)
+	}
+
+	plan, err := md.PlanMachinesApp(ctx)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return render.JSON(io.Out, plan)
+	}
+
+	colorize := io.ColorScheme()
+	rows := make([][]string, 0, len(plan.Machines))
+	for _, c := range plan.Machines {
+		id := c.ID
+		if id == "" {
+			id = "(new)"
+		}
+		if c.Standby {
+			id += " (standby)"
+		}
+		if c.Canary {
+			id += " (canary)"
+		}
+		rows = append(rows, []string{c.Action, id, c.ProcessGroup, c.Region})
+	}
+	title := fmt.Sprintf("Deployment plan for '%s' with %s strategy", plan.App, plan.Strategy)
+	if err := render.Table(io.Out, title, rows, "Action", "Machine", "Process Group", "Region"); err != nil {
+		return err
+	}
+
+	for _, c := range plan.Machines {
+		switch {
+		case c.Action != planActionUpdate && c.Action != planActionReplace:
+		case c.Diff == "":
+			fmt.Fprintf(io.Out, "Machine %s: no configuration changes besides the new release\n\n", colorize.Bold(c.ID))
+		default:
+			fmt.Fprintf(io.Out, "Configuration changes to be applied to machine %s:\n\n%s\n\n", colorize.Bold(c.ID), c.Diff)
+		}
+	}
+
+	fmt.Fprintln(io.Out, "Steps:")
+	for i, step := range plan.Steps {
+		fmt.Fprintf(io.Out, "  %d. %s\n", i+1, step)
+	}
+	fmt.Fprintln(io.Out)
+
+	fmt.Fprintf(io.Out, "Plan: %d to update, %d to replace, %d to create, %d to destroy\n",
+		plan.Summary[planActionUpdate],
+		plan.Summary[planActionReplace],
+		plan.Summary[planActionCreate],
+		plan.Summary[planActionDestroy],
+	)
+	return nil
+}
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func Test_PlanMachinesApp(t *testing.T) {
+	ios, _, _, _ := iostreams.Test()
+	ctx := iostreams.NewContext(context.Background(), ios)
+
+	cfg := &appconfig.Config{
+		AppName:       "my-cool-app",
+		PrimaryRegion: "scl",
+		Processes: map[string]string{
+			"app":    "run app",
+			"worker": "run worker",
+		},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	md.strategy = "rolling"
+	md.machineSet = machine.NewMachineSet(nil, ios, []*api.Machine{
+		{
+			ID:     "app1",
+			Region: "scl",
+			Config: &api.MachineConfig{
+				Image: "super/balloon:old",
+				Metadata: map[string]string{
+					api.MachineConfigMetadataKeyFlyProcessGroup:   "app",
+					api.MachineConfigMetadataKeyFlyReleaseVersion: "3",
+				},
+			},
+		},
+		{
+			ID:     "cron1",
+			Region: "ams",
+			Config: &api.MachineConfig{
+				Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "cron"},
+			},
+		},
+	})
+
+	plan, err := md.PlanMachinesApp(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"update": 1, "replace": 0, "create": 2, "destroy": 1}, plan.Summary)
+	require.Len(t, plan.Machines, 4)
+
+	assert.Equal(t, &PlannedChange{Action: "destroy", ID: "cron1", ProcessGroup: "cron", Region: "ams"}, plan.Machines[0])
+	assert.Equal(t, &PlannedChange{Action: "create", ProcessGroup: "worker", Region: "scl"}, plan.Machines[1])
+	assert.Equal(t, &PlannedChange{Action: "create", ProcessGroup: "worker", Region: "scl", Standby: true}, plan.Machines[2])
+
+	update := plan.Machines[3]
+	assert.Equal(t, "update", update.Action)
+	assert.Equal(t, "app1", update.ID)
+	assert.Contains(t, update.Diff, "super/balloon:old")
+	assert.NotRegexp(t, `(?m)^[-+].*`+api.MachineConfigMetadataKeyFlyReleaseVersion, update.Diff)
+}
+
+func Test_PlanMachinesApp_Steps(t *testing.T) {
+	ios, _, _, _ := iostreams.Test()
+	ctx := iostreams.NewContext(context.Background(), ios)
+
+	md, err := stabMachineDeployment(&appconfig.Config{
+		AppName:       "my-cool-app",
+		PrimaryRegion: "scl",
+		Deploy: &appconfig.Deploy{
+			ReleaseCommand: "migrate",
+			PreDeploy:      []appconfig.DeployHook{{Command: "notify start"}},
+			PostDeploy:     []appconfig.DeployHook{{Command: "warm cache", RunOn: appconfig.DeployHookRunOnMachine}},
+		},
+	})
+	require.NoError(t, err)
+	md.strategy = "canary"
+	md.maxUnavailable = 2
+	md.machineSet = machine.NewMachineSet(nil, ios, []*api.Machine{
+		{ID: "app1", Region: "scl", Config: &api.MachineConfig{Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "app"}}},
+		{ID: "app2", Region: "scl", Config: &api.MachineConfig{Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "app"}}},
+		{ID: "app3", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "app"}}},
+	})
+
+	plan, err := md.PlanMachinesApp(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Run release command: migrate",
+		"Run pre_deploy hook in a new machine: notify start",
+		"Update canary machine(s) app1 one at a time and abort if any is unhealthy",
+		"Update 2 machine(s), up to 2 at a time",
+		"Run post_deploy hook on a running machine: warm cache",
+	}, plan.Steps)
+	assert.True(t, plan.Machines[0].Canary)
+	assert.False(t, plan.Machines[1].Canary)
+
+	// canaries are picked in every region when deploying region by region
+	md.regionOrder = []string{"ams", "scl"}
+	plan, err = md.PlanMachinesApp(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Run release command: migrate",
+		"Run pre_deploy hook in a new machine: notify start",
+		"Deploy region ams",
+		"Update canary machine(s) app3 one at a time and abort if any is unhealthy",
+		"Deploy region scl",
+		"Update canary machine(s) app1 one at a time and abort if any is unhealthy",
+		"Update 1 machine(s) one at a time",
+		"Run post_deploy hook on a running machine: warm cache",
+	}, plan.Steps)
+
+	md.regionOrder = nil
+	md.strategy = "bluegreen"
+	plan, err = md.PlanMachinesApp(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"update": 0, "replace": 3, "create": 0, "destroy": 0}, plan.Summary)
+	assert.Contains(t, plan.Steps, "Create a green clone of 3 machine(s), wait for all of them to be healthy, then destroy the blue ones")
+}
@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/superfly/flyctl/internal/machine"
+	"golang.org/x/exp/slices"
+)
+
+// groupEntriesByRegion groups entries by the region of their machine. Regions are returned following
+// regionOrder, and regions not listed there come last in alphabetical order.
+func groupEntriesByRegion(entries []*machineUpdateEntry, regionOrder []string) ([]string, map[string][]*machineUpdateEntry) {
+	groups := map[string][]*machineUpdateEntry{}
+	var unlisted []string
+	for _, e := range entries {
+		region := e.leasableMachine.Machine().Region
+		if _, ok := groups[region]; !ok && !slices.Contains(regionOrder, region) {
+			unlisted = append(unlisted, region)
+		}
+		groups[region] = append(groups[region], e)
+	}
+	slices.Sort(unlisted)
+
+	var regions []string
+	for _, region := range regionOrder {
+		if _, ok := groups[region]; ok && !slices.Contains(regions, region) {
+			regions = append(regions, region)
+		}
+	}
+	return append(regions, unlisted...), groups
+}
+
+// updateMachinesByRegion deploys one region after another. Once a region is done, it waits for the
+// bake time and checks every machine in the region is healthy before moving to the next one.
+func (md *machineDeployment) updateMachinesByRegion(ctx context.Context, updateEntries []*machineUpdateEntry, journal *rollbackJournal) error {
+	regions, groups := groupEntriesByRegion(updateEntries, md.regionOrder)
+	for i, region := range regions {
+		fmt.Fprintf(md.io.ErrOut, "  %s Deploying region %s\n", formatIndex(i, len(regions)), md.colorize.Bold(region))
+		if err := md.updateMachineGroup(ctx, groups[region], journal); err != nil {
+			return fmt.Errorf("failed to deploy region %s: %w", region, err)
+		}
+
+		if i == len(regions)-1 {
+			break
+		}
+		if err := md.bakeRegion(ctx, region, groups[region]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bakeRegion waits for the bake time and then for all the updated machines in a region to pass their health checks.
+func (md *machineDeployment) bakeRegion(ctx context.Context, region string, entries []*machineUpdateEntry) error {
+	if md.regionBakeTime > 0 {
+		fmt.Fprintf(md.io.ErrOut, "  Baking region %s for %s\n", md.colorize.Bold(region), md.regionBakeTime)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(md.regionBakeTime):
+		}
+	}
+
+	if md.strategy == "immediate" || md.skipHealthChecks {
+		return nil
+	}
+
+	var machines []machine.LeasableMachine
+	for _, e := range entries {
+		// Standby machines are not started so they have nothing to check
+		if e.updatedMachine != nil && len(e.launchInput.Config.Standbys) == 0 {
+			machines = append(machines, e.updatedMachine)
+		}
+	}
+	fmt.Fprintf(md.io.ErrOut, "  Checking machines in region %s are healthy\n", md.colorize.Bold(region))
+	for i, lm := range machines {
+		if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, formatIndex(i, len(machines))); err != nil {
+			return fmt.Errorf("machine %s in region %s is not healthy: %w", lm.FormattedMachineId(), region, err)
+		}
+	}
+	md.logClearLinesAbove(ctx, 1)
+	fmt.Fprintf(md.io.ErrOut, "  Region %s is healthy\n", md.colorize.Bold(region))
+	return nil
+}
@@ -0,0 +1,160 @@
+package deploy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_groupEntriesByRegion(t *testing.T) {
+	entry := func(id, region string) *machineUpdateEntry {
+		e := stubUpdateEntry(id, "app", id, nil)
+		e.leasableMachine.Machine().Region = region
+		return e
+	}
+	ams1 := entry("ams1", "ams")
+	ams2 := entry("ams2", "ams")
+	ord1 := entry("ord1", "ord")
+	syd1 := entry("syd1", "syd")
+	cdg1 := entry("cdg1", "cdg")
+
+	regions, groups := groupEntriesByRegion(
+		[]*machineUpdateEntry{ams1, syd1, ord1, cdg1, ams2},
+		[]string{"ord", "mad", "ams", "ord"},
+	)
+	assert.Equal(t, []string{"ord", "ams", "cdg", "syd"}, regions)
+	assert.Equal(t, map[string][]*machineUpdateEntry{
+		"ams": {ams1, ams2},
+		"ord": {ord1},
+		"syd": {syd1},
+		"cdg": {cdg1},
+	}, groups)
+}
+
+func Test_updateMachinesByRegion(t *testing.T) {
+	cases := []struct {
+		name    string
+		setup   func(f *fakeFlaps)
+		wantErr string
+		// wantRequests are the updates and health checks of the machines, in order
+		wantRequests []string
+	}{
+		{
+			name: "healthy",
+			wantRequests: []string{
+				"update syd1", "check syd1", "check syd1",
+				"update ord1", "check ord1", "check ord1",
+				"update ams1", "check ams1",
+			},
+		},
+		{
+			name:    "fails to deploy",
+			setup:   func(f *fakeFlaps) { f.failWait["ord1"] = 1 },
+			wantErr: "failed to deploy region ord",
+			wantRequests: []string{
+				"update syd1", "check syd1", "check syd1",
+				"update ord1",
+			},
+		},
+		{
+			name: "fails its health checks once baked",
+			setup: func(f *fakeFlaps) {
+				checks := 0
+				f.hook = func(method, id, action string) {
+					if id != "ord1" || action != "" || method != "GET" {
+						return
+					}
+					// the machine turns unhealthy after its update finished
+					if checks++; checks == 2 {
+						f.mu.Lock()
+						f.machines["ord1"].Checks = []*api.MachineCheckStatus{{Name: "alive", Status: "critical"}}
+						f.mu.Unlock()
+					}
+				}
+			},
+			wantErr: "machine ord1 [web] in region ord is not healthy",
+			wantRequests: []string{
+				"update syd1", "check syd1", "check syd1",
+				"update ord1", "check ord1", "check ord1",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var machines []*api.Machine
+			for _, id := range []string{"ams1", "ord1", "syd1"} {
+				machines = append(machines, &api.Machine{
+					ID:     id,
+					Region: id[:3],
+					State:  api.MachineStateStarted,
+					Config: versionConfig("1"),
+					Checks: []*api.MachineCheckStatus{{Name: "alive", Status: "passing"}},
+				})
+			}
+			md, flaps := stubFlapsDeployment(t, machines...)
+			md.strategy = "rolling"
+			md.waitTimeout = time.Second
+			md.regionOrder = []string{"syd", "ord"}
+			md.regionBakeTime = 10 * time.Millisecond
+			if tc.setup != nil {
+				tc.setup(flaps)
+			}
+
+			var (
+				mu       sync.Mutex
+				requests []string
+			)
+			hook := flaps.hook
+			flaps.hook = func(method, id, action string) {
+				if hook != nil {
+					hook(method, id, action)
+				}
+				if id == "" || action != "" {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				switch method {
+				case "POST":
+					requests = append(requests, "update "+id)
+				case "GET":
+					requests = append(requests, "check "+id)
+				}
+			}
+
+			ctx := context.Background()
+			require.NoError(t, md.machineSet.AcquireLeases(ctx, time.Minute))
+			entries := updateEntries(md)
+			for _, e := range entries {
+				e.launchInput.Config = versionConfig("2")
+				// health checks are polled at half their shortest interval
+				e.launchInput.Config.Checks = map[string]api.MachineCheck{
+					"alive": {Interval: &api.Duration{Duration: 100 * time.Millisecond}},
+				}
+			}
+
+			err := md.updateMachinesByRegion(ctx, entries, md.newRollbackJournal())
+			mu.Lock()
+			defer mu.Unlock()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantRequests, requests)
+				return
+			}
+
+			require.ErrorContains(t, err, tc.wantErr)
+			// an unhealthy machine is checked until the wait times out
+			require.GreaterOrEqual(t, len(requests), len(tc.wantRequests))
+			assert.Equal(t, tc.wantRequests, requests[:len(tc.wantRequests)])
+			// the regions after the failing one are left alone
+			assert.NotContains(t, requests, "update ams1")
+			assert.Equal(t, "1", flaps.get("ams1").Config.Env["VERSION"])
+		})
+	}
+}
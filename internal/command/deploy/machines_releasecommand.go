@@ -12,27 +12,61 @@ import (
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/helpers"
+	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/terminal"
 )
 
+// runReleaseCommand runs every release command step in order, each in its own
+// release_command machine, and stops at the first one that fails.
 func (md *machineDeployment) runReleaseCommand(ctx context.Context) error {
-	if md.appConfig.Deploy == nil || md.appConfig.Deploy.ReleaseCommand == "" {
-		return nil
+	steps := md.appConfig.Deploy.ReleaseCommandSteps()
+	for i, step := range steps {
+		indexStr := ""
+		if len(steps) > 1 {
+			indexStr = " " + formatIndex(i, len(steps))
+		}
+		// Release command machines are destroyed once they exit, so only the first step can reuse one
+		if i > 0 {
+			md.releaseCommandMachine = machine.NewMachineSet(md.flapsClient, md.io, nil)
+		}
+		if err := md.runReleaseCommandStep(ctx, step, indexStr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (md *machineDeployment) runReleaseCommandStep(ctx context.Context, step appconfig.ReleaseCommand, indexStr string) error {
+	timeout := md.waitTimeout
+	if step.Timeout != nil {
+		timeout = step.Timeout.Duration
 	}
 
-	fmt.Fprintf(md.io.ErrOut, "Running %s release_command: %s\n",
+	fmt.Fprintf(md.io.ErrOut, "Running %s release_command%s: %s\n",
 		md.colorize.Bold(md.app.Name),
-		md.appConfig.Deploy.ReleaseCommand,
+		indexStr,
+		step.Command,
 	)
-	err := md.createOrUpdateReleaseCmdMachine(ctx)
+	err := md.createOrUpdateReleaseCmdMachine(ctx, step)
 	if err != nil {
 		return fmt.Errorf("error running release_command machine: %w", err)
 	}
 	releaseCmdMachine := md.releaseCommandMachine.GetMachines()[0]
+
 	// FIXME: consolidate this wait stuff with deploy waits? Especially once we improve the outpu
-	err = md.waitForReleaseCommandToFinish(ctx, releaseCmdMachine)
+	stepCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = md.waitForReleaseCommandToFinish(stepCtx, releaseCmdMachine, timeout)
 	if err != nil {
-		return err
+		if !errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+			return err
+		}
+		// Same as deploy hooks, don't leave the command running past its timeout
+		if destroyErr := releaseCmdMachine.Destroy(ctx, true); destroyErr != nil {
+			terminal.Warnf("failed to destroy release_command machine %s: %v\n", releaseCmdMachine.Machine().ID, destroyErr)
+		}
+		return fmt.Errorf("error release_command%s machine %s timed out after %s", indexStr, releaseCmdMachine.Machine().ID, timeout)
 	}
 	lastExitEvent, err := releaseCmdMachine.WaitForEventTypeAfterType(ctx, "exit", "start", md.waitTimeout)
 	if err != nil {
@@ -44,8 +78,8 @@ func (md *machineDeployment) runReleaseCommand(ctx context.Context) error {
 	}
 	if exitCode != 0 {
 		time.Sleep(2 * time.Second) // Wait 2 secs to be sure logs have reached OpenSearch
-		fmt.Fprintf(md.io.ErrOut, "Error release_command failed running on machine %s with exit code %s.\n",
-			md.colorize.Bold(releaseCmdMachine.Machine().ID), md.colorize.Red(strconv.Itoa(exitCode)))
+		fmt.Fprintf(md.io.ErrOut, "Error release_command%s failed running on machine %s with exit code %s.\n",
+			indexStr, md.colorize.Bold(releaseCmdMachine.Machine().ID), md.colorize.Red(strconv.Itoa(exitCode)))
 		fmt.Fprintf(md.io.ErrOut, "Check its logs: here's the last 100 lines below, or run 'fly logs -i %s':\n",
 			releaseCmdMachine.Machine().ID)
 		releaseCmdLogs, _, err := md.apiClient.GetAppLogs(ctx, md.app.Name, "", md.appConfig.PrimaryRegion, releaseCmdMachine.Machine().ID)
@@ -57,20 +91,20 @@ func (md *machineDeployment) runReleaseCommand(ctx context.Context) error {
 		}
 		return fmt.Errorf("error release_command machine %s exited with non-zero status of %d", releaseCmdMachine.Machine().ID, exitCode)
 	}
-	md.logClearLinesAbove(1)
-	fmt.Fprintf(md.io.ErrOut, "  release_command %s completed successfully\n", md.colorize.Bold(releaseCmdMachine.Machine().ID))
+	md.logClearLinesAbove(ctx, 1)
+	fmt.Fprintf(md.io.ErrOut, "  release_command%s %s completed successfully\n", indexStr, md.colorize.Bold(releaseCmdMachine.Machine().ID))
 	return nil
 }
 
-func (md *machineDeployment) createOrUpdateReleaseCmdMachine(ctx context.Context) error {
+func (md *machineDeployment) createOrUpdateReleaseCmdMachine(ctx context.Context, step appconfig.ReleaseCommand) error {
 	if md.releaseCommandMachine.IsEmpty() {
-		return md.createReleaseCommandMachine(ctx)
+		return md.createReleaseCommandMachine(ctx, step)
 	}
-	return md.updateReleaseCommandMachine(ctx)
+	return md.updateReleaseCommandMachine(ctx, step)
 }
 
-func (md *machineDeployment) createReleaseCommandMachine(ctx context.Context) error {
-	launchInput := md.launchInputForReleaseCommand(nil)
+func (md *machineDeployment) createReleaseCommandMachine(ctx context.Context, step appconfig.ReleaseCommand) error {
+	launchInput := md.launchInputForReleaseCommand(nil, step)
 	releaseCmdMachine, err := md.flapsClient.Launch(ctx, *launchInput)
 	if err != nil {
 		return fmt.Errorf("error creating a release_command machine: %w", err)
@@ -81,7 +115,7 @@ func (md *machineDeployment) createReleaseCommandMachine(ctx context.Context) er
 	return nil
 }
 
-func (md *machineDeployment) updateReleaseCommandMachine(ctx context.Context) error {
+func (md *machineDeployment) updateReleaseCommandMachine(ctx context.Context, step appconfig.ReleaseCommand) error {
 	releaseCmdMachine := md.releaseCommandMachine.GetMachines()[0]
 	fmt.Fprintf(md.io.ErrOut, "  Updating release_command machine %s\n", md.colorize.Bold(releaseCmdMachine.Machine().ID))
 
@@ -95,7 +129,7 @@ func (md *machineDeployment) updateReleaseCommandMachine(ctx context.Context) er
 	defer md.releaseCommandMachine.ReleaseLeases(ctx) // skipcq: GO-S2307
 	md.releaseCommandMachine.StartBackgroundLeaseRefresh(ctx, md.leaseTimeout, md.leaseDelayBetween)
 
-	launchInput := md.launchInputForReleaseCommand(releaseCmdMachine.Machine())
+	launchInput := md.launchInputForReleaseCommand(releaseCmdMachine.Machine(), step)
 	if err := releaseCmdMachine.Update(ctx, *launchInput); err != nil {
 		return fmt.Errorf("error updating release_command machine: %w", err)
 	}
@@ -103,16 +137,20 @@ func (md *machineDeployment) updateReleaseCommandMachine(ctx context.Context) er
 	return nil
 }
 
-func (md *machineDeployment) launchInputForReleaseCommand(origMachineRaw *api.Machine) *api.LaunchMachineInput {
+func (md *machineDeployment) launchInputForReleaseCommand(origMachineRaw *api.Machine, step appconfig.ReleaseCommand) *api.LaunchMachineInput {
 	if origMachineRaw == nil {
 		origMachineRaw = &api.Machine{
 			Region: md.appConfig.PrimaryRegion,
 		}
 	}
-	// We can ignore the error because ToReleaseMachineConfig fails only
-	// if it can't split the command and we test that at initialization
-	mConfig, _ := md.appConfig.ToReleaseMachineConfig()
+	// We can ignore the errors because ToReleaseMachineConfig fails only if it can't
+	// split the command and VM sizes can't be wrong, we test both at initialization
+	mConfig, _ := md.appConfig.ToReleaseMachineConfig(step)
 	mConfig.Guest = md.inferReleaseCommandGuest()
+	if step.VMSize != "" {
+		mConfig.Guest = &api.MachineGuest{}
+		_ = mConfig.Guest.SetSize(step.VMSize)
+	}
 	mConfig.Image = md.img
 	md.setMachineReleaseData(mConfig)
 
@@ -150,7 +188,7 @@ func (md *machineDeployment) inferReleaseCommandGuest() *api.MachineGuest {
 	return helpers.Clone(desiredGuest)
 }
 
-func (md *machineDeployment) waitForReleaseCommandToFinish(ctx context.Context, releaseCmdMachine machine.LeasableMachine) error {
+func (md *machineDeployment) waitForReleaseCommandToFinish(ctx context.Context, releaseCmdMachine machine.LeasableMachine, timeout time.Duration) error {
 	err := releaseCmdMachine.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, "")
 	if err != nil {
 		var flapsErr *flaps.FlapsError
@@ -160,7 +198,7 @@ func (md *machineDeployment) waitForReleaseCommandToFinish(ctx context.Context,
 		}
 		return fmt.Errorf("error waiting for release_command machine %s to start: %w", releaseCmdMachine.Machine().ID, err)
 	}
-	err = releaseCmdMachine.WaitForState(ctx, api.MachineStateDestroyed, md.waitTimeout, "")
+	err = releaseCmdMachine.WaitForState(ctx, api.MachineStateDestroyed, timeout, "")
 	if err != nil {
 		return fmt.Errorf("error waiting for release_command machine %s to finish running: %w", releaseCmdMachine.Machine().ID, err)
 	}
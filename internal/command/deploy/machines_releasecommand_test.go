@@ -0,0 +1,26 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+func Test_runReleaseCommandStep_Timeout(t *testing.T) {
+	md, flaps := stubFlapsDeployment(t)
+	md.appConfig = &appconfig.Config{AppName: "my-cool-app", PrimaryRegion: "scl"}
+	md.releaseCommandMachine = machine.NewMachineSet(md.flapsClient, md.io, nil)
+	flaps.stuck["new1"] = api.MachineStateDestroyed
+
+	step := appconfig.ReleaseCommand{Command: "migrate", Timeout: &api.Duration{Duration: 200 * time.Millisecond}}
+	err := md.runReleaseCommandStep(context.Background(), step, "")
+	require.ErrorContains(t, err, "timed out after 200ms")
+	// The release command must not keep running once its step timed out
+	assert.Equal(t, []string{"new1"}, flaps.destroyedIDs())
+}
@@ -0,0 +1,308 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/internal/state"
+	"github.com/superfly/flyctl/terminal"
+)
+
+// rollbackJournal keeps the config every machine had before the deployment touched it.
+// When it has a path, it is saved after every change so an interrupted rollback can be resumed.
+type rollbackJournal struct {
+	mu             sync.Mutex
+	path           string
+	AppName        string                  `json:"app_name"`
+	ReleaseVersion int                     `json:"release_version"`
+	RollingBack    bool                    `json:"rolling_back"`
+	Machines       []*rollbackJournalEntry `json:"machines"`
+}
+
+type rollbackJournalEntry struct {
+	// ID of the machine before it was updated
+	ID string `json:"id"`
+	// CurrentID is the machine running the new config. It differs from ID when the
+	// machine is replaced and is empty until the replacement is launched.
+	CurrentID string             `json:"current_id,omitempty"`
+	Region    string             `json:"region"`
+	Config    *api.MachineConfig `json:"config"`
+	Restored  bool               `json:"restored,omitempty"`
+}
+
+func (e *rollbackJournalEntry) replaced() bool {
+	return e.CurrentID != e.ID
+}
+
+func rollbackJournalPath(ctx context.Context, appName string) string {
+	return filepath.Join(state.ConfigDirectory(ctx), "deploys", appName+".rollback.json")
+}
+
+func loadRollbackJournal(path string) (*rollbackJournal, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	j := &rollbackJournal{path: path}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to decode rollback journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+func (md *machineDeployment) newRollbackJournal() *rollbackJournal {
+	j := &rollbackJournal{
+		AppName:        md.app.Name,
+		ReleaseVersion: md.releaseVersion,
+	}
+	if md.rollbackOnFailure {
+		j.path = md.rollbackJournalPath
+	}
+	return j
+}
+
+func (j *rollbackJournal) save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.write()
+}
+
+func (j *rollbackJournal) write() error {
+	if j.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := j.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, j.path)
+}
+
+func (j *rollbackJournal) remove() error {
+	if j.path == "" {
+		return nil
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// record saves the config lm has before it gets updated with launchInput.
+func (j *rollbackJournal) record(lm machine.LeasableMachine, launchInput *api.LaunchMachineInput) (*rollbackJournalEntry, error) {
+	m := lm.Machine()
+	entry := &rollbackJournalEntry{
+		ID:     m.ID,
+		Region: m.Region,
+		Config: machine.CloneConfig(m.Config),
+	}
+	if launchInput.ID == m.ID {
+		entry.CurrentID = m.ID
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Machines = append(j.Machines, entry)
+	return entry, j.write()
+}
+
+func (j *rollbackJournal) setCurrentID(entry *rollbackJournalEntry, id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry.CurrentID = id
+	return j.write()
+}
+
+func (j *rollbackJournal) markRestored(entry *rollbackJournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry.Restored = true
+	return j.write()
+}
+
+func (j *rollbackJournal) pending() []*rollbackJournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return lo.Filter(j.Machines, func(e *rollbackJournalEntry, _ int) bool {
+		return !e.Restored
+	})
+}
+
+// rollbackMachines restores every machine in the journal that wasn't restored yet and prints a summary.
+// Machines updated in place must be leased in leasedSet.
+func (md *machineDeployment) rollbackMachines(ctx context.Context, journal *rollbackJournal, leasedSet machine.MachineSet) error {
+	pending := journal.pending()
+	if len(pending) == 0 {
+		return journal.remove()
+	}
+
+	journal.RollingBack = true
+	if err := journal.save(); err != nil {
+		return fmt.Errorf("failed to save rollback journal: %w", err)
+	}
+
+	leased := lo.KeyBy(leasedSet.GetMachines(), func(lm machine.LeasableMachine) string {
+		return lm.Machine().ID
+	})
+
+	fmt.Fprintf(md.io.ErrOut, "Rolling back %d machine(s) to their previous configuration\n", len(pending))
+	rows := make([][]string, 0, len(pending))
+	failed := 0
+	for i, entry := range pending {
+		indexStr := formatIndex(i, len(pending))
+		machineID := entry.ID
+		if entry.replaced() && entry.CurrentID != "" {
+			machineID = fmt.Sprintf("%s (replaced by %s)", entry.ID, entry.CurrentID)
+		}
+
+		result := md.colorize.Green("restored")
+		if err := md.restoreMachine(ctx, journal, entry, leased[entry.ID], indexStr); err != nil {
+			failed++
+			result = md.colorize.Red(fmt.Sprintf("failed: %v", err))
+		}
+		rows = append(rows, []string{machineID, result})
+	}
+
+	if err := render.Table(md.io.Out, "Rollback summary", rows, "Machine", "Result"); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to restore %d of %d machines; run `fly deploy` again to resume the rollback", failed, len(pending))
+	}
+	return journal.remove()
+}
+
+// restoreMachine puts the machine recorded by entry back on its previous config. Machines updated
+// in place get their previous config applied again through lm, while replaced machines have their
+// replacement destroyed and are launched again with the previous config, unless the original
+// machine is still there.
+func (md *machineDeployment) restoreMachine(ctx context.Context, journal *rollbackJournal, entry *rollbackJournalEntry, lm machine.LeasableMachine, indexStr string) error {
+	input := api.LaunchMachineInput{
+		ID:         entry.ID,
+		AppID:      md.app.Name,
+		OrgSlug:    md.app.Organization.ID,
+		Region:     entry.Region,
+		Config:     entry.Config,
+		SkipLaunch: len(entry.Config.Standbys) > 0,
+	}
+
+	if !entry.replaced() {
+		if lm == nil {
+			return fmt.Errorf("no current lease for machine %s", entry.ID)
+		}
+		if err := lm.Update(ctx, input); err != nil {
+			return err
+		}
+	} else {
+		if entry.CurrentID != "" {
+			err := md.flapsClient.Destroy(ctx, api.RemoveMachineInput{ID: entry.CurrentID, Kill: true}, "")
+			var flapsErr *flaps.FlapsError
+			if err != nil && !(errors.As(err, &flapsErr) && flapsErr.ResponseStatusCode == http.StatusNotFound) {
+				return fmt.Errorf("failed to destroy replacement machine %s: %w", entry.CurrentID, err)
+			}
+			if err := journal.setCurrentID(entry, ""); err != nil {
+				return err
+			}
+		}
+
+		// The replacement may have failed before the original machine was destroyed
+		if orig, err := md.flapsClient.Get(ctx, entry.ID); err == nil && orig.IsActive() {
+			lm = machine.NewLeasableMachine(md.flapsClient, md.io, orig)
+		} else {
+			input.ID = ""
+			newMachineRaw, err := md.flapsClient.Launch(ctx, input)
+			if err != nil {
+				return err
+			}
+			lm = machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
+			fmt.Fprintf(md.io.ErrOut, "  %s Created machine %s with the previous configuration\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+		}
+	}
+
+	if err := journal.markRestored(entry); err != nil {
+		return err
+	}
+
+	if len(entry.Config.Standbys) == 0 {
+		if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
+			return err
+		}
+	}
+	md.logClearLinesAbove(ctx, 1)
+	fmt.Fprintf(md.io.ErrOut, "  %s Machine %s restored: %s\n",
+		indexStr,
+		md.colorize.Bold(lm.FormattedMachineId()),
+		md.colorize.Green("success"),
+	)
+	return nil
+}
+
+// resumeInterruptedRollback finishes a rollback that a previous deployment of this app left halfway.
+// Journals of deployments that were interrupted before rolling back are discarded. Unless resume is
+// set, it only warns about the interrupted rollback and keeps this deployment from overwriting its journal.
+func (md *machineDeployment) resumeInterruptedRollback(ctx context.Context, resume bool) error {
+	if md.rollbackJournalPath == "" {
+		return nil
+	}
+	journal, err := loadRollbackJournal(md.rollbackJournalPath)
+	if err != nil || journal == nil {
+		return err
+	}
+	if !resume {
+		if journal.RollingBack {
+			terminal.Warnf("The rollback of release v%d was interrupted, run `fly deploy` to finish restoring its machines\n", journal.ReleaseVersion)
+			md.rollbackJournalPath = ""
+		}
+		return nil
+	}
+	if !journal.RollingBack {
+		return journal.remove()
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "Resuming interrupted rollback of release v%d\n", journal.ReleaseVersion)
+	var machines []*api.Machine
+	for _, entry := range journal.pending() {
+		if entry.replaced() {
+			continue
+		}
+		m, err := md.flapsClient.Get(ctx, entry.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get machine %s to resume rollback: %w", entry.ID, err)
+		}
+		machines = append(machines, m)
+	}
+
+	leasedSet := machine.NewMachineSet(md.flapsClient, md.io, machines)
+	if err := leasedSet.AcquireLeases(ctx, md.leaseTimeout); err != nil {
+		return err
+	}
+	defer leasedSet.ReleaseLeases(ctx) // skipcq: GO-S2307
+	leasedSet.StartBackgroundLeaseRefresh(ctx, md.leaseTimeout, md.leaseDelayBetween)
+
+	if err := md.rollbackMachines(ctx, journal, leasedSet); err != nil {
+		return fmt.Errorf("failed to resume interrupted rollback: %w", err)
+	}
+	return nil
+}
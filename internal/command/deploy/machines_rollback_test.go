@@ -0,0 +1,202 @@
+package deploy
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+func Test_rollbackJournal_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploys", "my-cool-app.rollback.json")
+	journal := &rollbackJournal{path: path, AppName: "my-cool-app", ReleaseVersion: 3}
+
+	inplace := stubUpdateEntry("web1", "web", "web1", nil)
+	replaced := stubUpdateEntry("web2", "web", "", nil)
+
+	entry, err := journal.record(inplace.leasableMachine, inplace.launchInput)
+	require.NoError(t, err)
+	assert.False(t, entry.replaced())
+
+	entry, err = journal.record(replaced.leasableMachine, replaced.launchInput)
+	require.NoError(t, err)
+	assert.True(t, entry.replaced())
+	require.NoError(t, journal.setCurrentID(entry, "web3"))
+
+	journal.Machines[0].Restored = true
+	journal.RollingBack = true
+	require.NoError(t, journal.save())
+
+	loaded, err := loadRollbackJournal(path)
+	require.NoError(t, err)
+	assert.Equal(t, "my-cool-app", loaded.AppName)
+	assert.Equal(t, 3, loaded.ReleaseVersion)
+	assert.True(t, loaded.RollingBack)
+	assert.Equal(t, []*rollbackJournalEntry{{
+		ID:        "web2",
+		CurrentID: "web3",
+		Config: &api.MachineConfig{
+			Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "web"},
+		},
+	}}, loaded.pending())
+
+	require.NoError(t, loaded.remove())
+	loaded, err = loadRollbackJournal(path)
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+func Test_rollbackJournal_InMemory(t *testing.T) {
+	journal := &rollbackJournal{}
+	e := stubUpdateEntry("web1", "web", "web1", nil)
+	_, err := journal.record(e.leasableMachine, e.launchInput)
+	require.NoError(t, err)
+	assert.Len(t, journal.pending(), 1)
+	assert.NoError(t, journal.remove())
+}
+
+func Test_resumeInterruptedRollback_WithoutResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploys", "my-cool-app.rollback.json")
+	journal := &rollbackJournal{path: path, AppName: "my-cool-app", ReleaseVersion: 3, RollingBack: true}
+	require.NoError(t, journal.save())
+
+	md, err := stabMachineDeployment(nil)
+	require.NoError(t, err)
+	md.rollbackJournalPath = path
+	md.rollbackOnFailure = true
+
+	// Other commands only warn, and don't overwrite the journal with their own
+	require.NoError(t, md.resumeInterruptedRollback(context.Background(), false))
+	assert.FileExists(t, path)
+	assert.Empty(t, md.newRollbackJournal().path)
+
+	// Journals of deployments that never started rolling back are discarded by fly deploy
+	journal.RollingBack = false
+	require.NoError(t, journal.save())
+	md.rollbackJournalPath = path
+	require.NoError(t, md.resumeInterruptedRollback(context.Background(), false))
+	assert.FileExists(t, path)
+	require.NoError(t, md.resumeInterruptedRollback(context.Background(), true))
+	assert.NoFileExists(t, path)
+}
+
+// versionConfig returns the config of a web machine at version.
+func versionConfig(version string) *api.MachineConfig {
+	return &api.MachineConfig{
+		Env:      map[string]string{"VERSION": version},
+		Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: "web"},
+	}
+}
+
+func Test_rollbackMachines(t *testing.T) {
+	started := func(id, version string) *api.Machine {
+		return &api.Machine{ID: id, Region: "ams", State: api.MachineStateStarted, Config: versionConfig(version)}
+	}
+
+	cases := []struct {
+		name     string
+		machines []*api.Machine
+		entry    rollbackJournalEntry
+		// leased has the machines leased by the deployment
+		leased []string
+		// restored is the machine expected on the previous config once rolled back
+		restored      string
+		wantDestroyed []string
+		wantLaunches  int
+		wantErr       string
+	}{
+		{
+			name:     "updated in place",
+			machines: []*api.Machine{started("web1", "2")},
+			entry:    rollbackJournalEntry{ID: "web1", CurrentID: "web1", Region: "ams", Config: versionConfig("1")},
+			leased:   []string{"web1"},
+			restored: "web1",
+		},
+		{
+			name:     "updated in place without a lease",
+			machines: []*api.Machine{started("web1", "2")},
+			entry:    rollbackJournalEntry{ID: "web1", CurrentID: "web1", Region: "ams", Config: versionConfig("1")},
+			wantErr:  "failed to restore 1 of 1 machines",
+		},
+		{
+			name:          "replaced after destroying the original",
+			machines:      []*api.Machine{started("web9", "2")},
+			entry:         rollbackJournalEntry{ID: "web1", CurrentID: "web9", Region: "ams", Config: versionConfig("1")},
+			restored:      "new1",
+			wantDestroyed: []string{"web9"},
+			wantLaunches:  1,
+		},
+		{
+			name:          "replaced before destroying the original",
+			machines:      []*api.Machine{started("web1", "1"), started("web9", "2")},
+			entry:         rollbackJournalEntry{ID: "web1", CurrentID: "web9", Region: "ams", Config: versionConfig("1")},
+			restored:      "web1",
+			wantDestroyed: []string{"web9"},
+		},
+		{
+			name:         "replacement never launched",
+			entry:        rollbackJournalEntry{ID: "web1", Region: "ams", Config: versionConfig("1")},
+			restored:     "new1",
+			wantLaunches: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			md, flaps := stubFlapsDeployment(t, tc.machines...)
+			ctx := context.Background()
+
+			var leased []*api.Machine
+			for _, id := range tc.leased {
+				leased = append(leased, flaps.get(id))
+			}
+			leasedSet := machine.NewMachineSet(md.flapsClient, md.io, leased)
+			require.NoError(t, leasedSet.AcquireLeases(ctx, time.Minute))
+
+			path := filepath.Join(t.TempDir(), "my-cool-app.rollback.json")
+			entry := tc.entry
+			journal := &rollbackJournal{path: path, Machines: []*rollbackJournalEntry{&entry}}
+
+			err := md.rollbackMachines(ctx, journal, leasedSet)
+			assert.Equal(t, tc.wantDestroyed, flaps.destroyedIDs())
+			assert.Equal(t, tc.wantLaunches, flaps.launched())
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				// the journal is kept for the rollback to be resumed
+				assert.FileExists(t, path)
+				assert.False(t, entry.Restored)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoFileExists(t, path)
+			assert.True(t, entry.Restored)
+			restored := flaps.get(tc.restored)
+			require.NotNil(t, restored, tc.restored)
+			assert.Equal(t, "1", restored.Config.Env["VERSION"])
+		})
+	}
+}
+
+func Test_resumeInterruptedRollback(t *testing.T) {
+	md, flaps := stubFlapsDeployment(t,
+		&api.Machine{ID: "web1", Region: "ams", State: api.MachineStateStarted, Config: versionConfig("2")},
+	)
+	md.rollbackJournalPath = filepath.Join(t.TempDir(), "my-cool-app.rollback.json")
+	journal := &rollbackJournal{path: md.rollbackJournalPath, ReleaseVersion: 3, RollingBack: true, Machines: []*rollbackJournalEntry{
+		{ID: "web1", CurrentID: "web1", Region: "ams", Config: versionConfig("1")},
+		{ID: "web2", CurrentID: "web2", Region: "ams", Config: versionConfig("1"), Restored: true},
+	}}
+	require.NoError(t, journal.save())
+
+	// Only the machines that weren't restored yet are leased and restored
+	require.NoError(t, md.resumeInterruptedRollback(context.Background(), true))
+	assert.Equal(t, "1", flaps.get("web1").Config.Env["VERSION"])
+	assert.Zero(t, flaps.launched())
+	assert.NoFileExists(t, md.rollbackJournalPath)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/iostreams"
 )
 
 func stabMachineDeployment(appConfig *appconfig.Config) (*machineDeployment, error) {
@@ -25,6 +26,24 @@ func stabMachineDeployment(appConfig *appconfig.Config) (*machineDeployment, err
 	return md, nil
 }
 
+// stubUpdateEntry returns an update entry for machine id of group, launched with launchID and standbys.
+func stubUpdateEntry(id, group, launchID string, standbys []string) *machineUpdateEntry {
+	ios, _, _, _ := iostreams.Test()
+	m := &api.Machine{
+		ID: id,
+		Config: &api.MachineConfig{
+			Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: group},
+		},
+	}
+	return &machineUpdateEntry{
+		leasableMachine: machine.NewLeasableMachine(nil, ios, m),
+		launchInput: &api.LaunchMachineInput{
+			ID:     launchID,
+			Config: &api.MachineConfig{Standbys: standbys},
+		},
+	}
+}
+
 func Test_resolveUpdatedMachineConfig_Basic(t *testing.T) {
 	md, err := stabMachineDeployment(&appconfig.Config{
 		AppName: "my-cool-app",
@@ -169,7 +188,7 @@ func Test_resolveUpdatedMachineConfig_ReleaseCommand(t *testing.T) {
 			},
 			Guest: api.MachinePresets["shared-cpu-2x"],
 		},
-	}, md.launchInputForReleaseCommand(nil))
+	}, md.launchInputForReleaseCommand(nil, md.appConfig.Deploy.ReleaseCommandSteps()[0]))
 
 	// Update existing release command machine
 	origMachine := &api.Machine{
@@ -214,7 +233,41 @@ func Test_resolveUpdatedMachineConfig_ReleaseCommand(t *testing.T) {
 			},
 			Guest: api.MachinePresets["shared-cpu-2x"],
 		},
-	}, md.launchInputForReleaseCommand(origMachine))
+	}, md.launchInputForReleaseCommand(origMachine, md.appConfig.Deploy.ReleaseCommandSteps()[0]))
+}
+
+// Test release command steps overriding the machine size and environment
+func Test_resolveUpdatedMachineConfig_ReleaseCommandSteps(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		AppName: "my-cool-app",
+		Env: map[string]string{
+			"PRIMARY_REGION": "scl",
+			"OTHER":          "value",
+		},
+		Deploy: &appconfig.Deploy{
+			ReleaseCommand: "touch sky",
+			ReleaseCommands: []appconfig.ReleaseCommand{{
+				Command: "warm cache",
+				VMSize:  "performance-1x",
+				Env:     map[string]string{"OTHER": "override"},
+			}},
+		},
+	})
+	require.NoError(t, err)
+
+	steps := md.appConfig.Deploy.ReleaseCommandSteps()
+	require.Len(t, steps, 2)
+
+	li := md.launchInputForReleaseCommand(nil, steps[0])
+	assert.Equal(t, []string{"touch", "sky"}, li.Config.Init.Cmd)
+	assert.Equal(t, api.MachinePresets["shared-cpu-2x"], li.Config.Guest)
+	assert.Equal(t, "value", li.Config.Env["OTHER"])
+
+	li = md.launchInputForReleaseCommand(nil, steps[1])
+	assert.Equal(t, []string{"warm", "cache"}, li.Config.Init.Cmd)
+	assert.Equal(t, api.MachinePresets["performance-1x"], li.Config.Guest)
+	assert.Equal(t, "override", li.Config.Env["OTHER"])
+	assert.Equal(t, "1", li.Config.Env["RELEASE_COMMAND"])
 }
 
 // Test Mounts
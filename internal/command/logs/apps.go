@@ -0,0 +1,127 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+)
+
+var appsFlags = flag.Set{
+	flag.StringSlice{
+		Name:        flag.AppName,
+		Shorthand:   "a",
+		Description: "Application name or glob over the names of your apps, like 'web-*'. Can be specified multiple times.",
+	},
+	flag.Org(),
+}
+
+type appNamesKey struct{}
+
+// requireAppNames is a Preparer which resolves the apps of the repeated --app flag,
+// expanding globs against the apps of the user, or else the app of RequireAppName.
+func requireAppNames(ctx context.Context) (context.Context, error) {
+	patterns := flag.GetStringSlice(ctx, flag.AppName)
+	if len(patterns) == 0 {
+		return command.RequireAppName(ctx)
+	}
+
+	names := patterns
+	if hasAppGlob(patterns) {
+		apiClient := client.FromContext(ctx).API()
+		apps, err := apiClient.GetApps(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing your apps: %w", err)
+		}
+
+		org := flag.GetOrg(ctx)
+		available := make([]string, 0, len(apps))
+		for _, app := range apps {
+			if org == "" || app.Organization.Slug == org {
+				available = append(available, app.Name)
+			}
+		}
+		if names, err = matchAppNames(patterns, available); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx = appconfig.WithName(ctx, names[0])
+	return context.WithValue(ctx, appNamesKey{}, names), nil
+}
+
+// appNamesFromContext returns the names of the apps to show the logs of.
+func appNamesFromContext(ctx context.Context) []string {
+	if names, ok := ctx.Value(appNamesKey{}).([]string); ok {
+		return names
+	}
+	return []string{appconfig.NameFromContext(ctx)}
+}
+
+func isAppGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func hasAppGlob(patterns []string) bool {
+	for _, pattern := range patterns {
+		if isAppGlob(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAppNames returns the names of available matched by patterns, sorted and without
+// duplicates. Names which aren't globs are kept as they are. It fails when a glob is
+// invalid or matches no app.
+func matchAppNames(patterns, available []string) ([]string, error) {
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !isAppGlob(pattern) {
+			add(pattern)
+			continue
+		}
+
+		matched := false
+		for _, name := range available {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid app glob %q: %w", pattern, err)
+			}
+			if ok {
+				matched = true
+				add(name)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no app matches %q", pattern)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// appNameWidth returns the width of the longest of names.
+func appNameWidth(names []string) (width int) {
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	return
+}
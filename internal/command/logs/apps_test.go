@@ -0,0 +1,27 @@
+package logs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchAppNames(t *testing.T) {
+	available := []string{"web-eu", "web-us", "worker", "db"}
+
+	names, err := matchAppNames([]string{"web-*", "db", "web-us"}, available)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db", "web-eu", "web-us"}, names)
+
+	names, err = matchAppNames([]string{"w?rker", "other"}, available)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "worker"}, names)
+
+	_, err = matchAppNames([]string{"api-*"}, available)
+	assert.Error(t, err)
+	_, err = matchAppNames([]string{"web-["}, available)
+	assert.Error(t, err)
+
+	assert.Equal(t, 6, appNameWidth(names))
+}
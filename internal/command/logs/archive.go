@@ -0,0 +1,476 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/logs"
+	"github.com/superfly/flyctl/terminal"
+)
+
+var archiveFlags = flag.Set{
+	flag.String{
+		Name:        "output-dir",
+		Description: "Archive the logs as NDJSON files in this directory instead of printing them, resuming after the last archived entry",
+	},
+	flag.String{
+		Name:        "partition-by",
+		Description: "Partition the archived logs in directories by date, instance or region",
+		Default:     "date",
+	},
+	flag.Int{
+		Name:        "rotate-size",
+		Description: "Rotate archived log files once they reach this size in MB, 0 to disable",
+		Default:     100,
+	},
+	flag.Duration{
+		Name:        "rotate-interval",
+		Description: "Rotate archived log files after this duration, 0 to disable",
+	},
+	flag.Bool{
+		Name:        "compress",
+		Description: "Compress rotated log files with gzip",
+	},
+}
+
+const (
+	// archiveStateFile records what was archived, relative to the output directory
+	archiveStateFile = ".flyctl-logs-state.json"
+	// archiveCheckpointInterval is how often files are flushed, rotated and the state saved
+	archiveCheckpointInterval = time.Second
+	// archiveResumeWindow is how much earlier than the last archived entry a resumed archive
+	// starts, for the entries that came in late and out of order
+	archiveResumeWindow = time.Minute
+)
+
+// archiveState is the progress of an archive. Files has the size of the active log files
+// at the last checkpoint, data written past it is dropped and fetched again on resume.
+// RecentKeys identifies the entries archived within archiveResumeWindow of the last one.
+type archiveState struct {
+	LastTimestamp string           `json:"last_timestamp"`
+	RecentKeys    []archivedKey    `json:"recent_keys"`
+	Files         map[string]int64 `json:"files"`
+}
+
+type archivedKey struct {
+	Time time.Time `json:"time"`
+	Key  string    `json:"key"`
+}
+
+type archiveFile struct {
+	partition string
+	path      string
+	file      *os.File
+	w         *bufio.Writer
+	size      int64
+	openedAt  time.Time
+}
+
+// logArchive writes log entries to NDJSON files partitioned in directories of dir. Files are
+// flushed and rotated at checkpoints, which also save the state used to resume.
+type logArchive struct {
+	dir            string
+	app            string
+	partitionBy    string
+	rotateSize     int64
+	rotateInterval time.Duration
+	compress       bool
+
+	stop context.CancelFunc
+
+	mu    sync.Mutex
+	files map[string]*archiveFile
+	// sizes has the checkpointed size of every active file, by path relative to dir
+	sizes map[string]int64
+
+	// entries up to resumeFrom were archived by a previous run, the ones of resumeKeys
+	// among those within archiveResumeWindow of it
+	resumeFrom time.Time
+	resumeKeys map[string]bool
+
+	lastTime      time.Time
+	lastTimestamp string
+	recent        []archivedKey
+}
+
+func newLogArchive(ctx context.Context, app string) (*logArchive, error) {
+	a := &logArchive{
+		dir:            flag.GetString(ctx, "output-dir"),
+		app:            app,
+		partitionBy:    flag.GetString(ctx, "partition-by"),
+		rotateSize:     int64(flag.GetInt(ctx, "rotate-size")) << 20,
+		rotateInterval: flag.GetDuration(ctx, "rotate-interval"),
+		compress:       flag.GetBool(ctx, "compress"),
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open validates the settings of a, creates its directory and restores the state of a previous run.
+func (a *logArchive) open() error {
+	a.files = map[string]*archiveFile{}
+	a.sizes = map[string]int64{}
+	a.resumeKeys = map[string]bool{}
+
+	switch a.partitionBy {
+	case "date", "instance", "region":
+	default:
+		return fmt.Errorf("invalid --partition-by %q, expected date, instance or region", a.partitionBy)
+	}
+	if a.rotateSize < 0 || a.rotateInterval < 0 {
+		return errors.New("--rotate-size and --rotate-interval can't be negative")
+	}
+
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return fmt.Errorf("failed creating output directory: %w", err)
+	}
+	return a.restore()
+}
+
+func (a *logArchive) activeName() string {
+	return a.app + ".ndjson"
+}
+
+// restore loads the state of a previous run, and truncates the active files it lists to
+// their size at the last checkpoint.
+func (a *logArchive) restore() error {
+	var state archiveState
+	data, err := os.ReadFile(filepath.Join(a.dir, archiveStateFile))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed reading archive state: %w", err)
+	default:
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("failed parsing archive state %s: %w", archiveStateFile, err)
+		}
+	}
+
+	err = filepath.WalkDir(a.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != a.activeName() {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// files the state doesn't list, like ones archived before a first checkpoint
+		// or without the state file, are kept whole
+		size, ok := state.Files[rel]
+		if !ok || info.Size() <= size {
+			a.sizes[rel] = info.Size()
+			return nil
+		}
+		a.sizes[rel] = size
+		return os.Truncate(path, size)
+	})
+	if err != nil {
+		return fmt.Errorf("failed restoring archived log files: %w", err)
+	}
+
+	if state.LastTimestamp != "" {
+		if a.resumeFrom, err = time.Parse(time.RFC3339Nano, state.LastTimestamp); err != nil {
+			return fmt.Errorf("invalid last timestamp in archive state: %w", err)
+		}
+		for _, k := range state.RecentKeys {
+			a.resumeKeys[k.Key] = true
+		}
+		a.lastTime, a.lastTimestamp, a.recent = a.resumeFrom, state.LastTimestamp, state.RecentKeys
+	}
+	return nil
+}
+
+// ResumeFrom returns the timestamp of the last entry archived by a previous run.
+func (a *logArchive) ResumeFrom() time.Time {
+	return a.resumeFrom
+}
+
+func (a *logArchive) archived(entry logs.LogEntry, ts time.Time) bool {
+	if a.resumeFrom.IsZero() || ts.IsZero() {
+		return false
+	}
+	return ts.Before(a.resumeFrom.Add(-archiveResumeWindow)) || a.resumeKeys[entryKey(entry)]
+}
+
+func (a *logArchive) partition(entry logs.LogEntry, ts time.Time) string {
+	var value string
+	switch a.partitionBy {
+	case "date":
+		if !ts.IsZero() {
+			value = ts.UTC().Format("2006-01-02")
+		}
+	case "instance":
+		value = entry.Instance
+	case "region":
+		value = entry.Region
+	}
+	if value == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(value)
+}
+
+// Write archives entry unless a previous run already did.
+func (a *logArchive) Write(entry logs.LogEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ts := entryTime(entry)
+	if a.archived(entry, ts) {
+		return nil
+	}
+
+	af, err := a.file(a.partition(entry, ts))
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	n, err := af.w.Write(append(line, '\n'))
+	af.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed writing to %s: %w", af.path, err)
+	}
+
+	if !ts.IsZero() {
+		a.recent = append(a.recent, archivedKey{Time: ts, Key: entryKey(entry)})
+	}
+	if ts.After(a.lastTime) {
+		a.lastTime, a.lastTimestamp = ts, entry.Timestamp
+	}
+	return nil
+}
+
+func (a *logArchive) file(partition string) (*archiveFile, error) {
+	if af, ok := a.files[partition]; ok {
+		return af, nil
+	}
+
+	dir := filepath.Join(a.dir, partition)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed creating archive directory: %w", err)
+	}
+	path := filepath.Join(dir, a.activeName())
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening archive file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	af := &archiveFile{
+		partition: partition,
+		path:      path,
+		file:      f,
+		w:         bufio.NewWriter(f),
+		size:      info.Size(),
+		openedAt:  time.Now(),
+	}
+	a.files[partition] = af
+	return af, nil
+}
+
+// Start checkpoints the archive every archiveCheckpointInterval in the background
+// until ctx is done or the archive is closed.
+func (a *logArchive) Start(ctx context.Context) {
+	ctx, a.stop = context.WithCancel(ctx)
+	go a.checkpointUntilDone(ctx)
+}
+
+func (a *logArchive) checkpointUntilDone(ctx context.Context) {
+	ticker := time.NewTicker(archiveCheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.checkpoint(false); err != nil {
+				terminal.Warnf("failed checkpointing log archive: %v\n", err)
+			}
+		}
+	}
+}
+
+// Close checkpoints the archive a last time and closes its files.
+func (a *logArchive) Close() error {
+	if a.stop != nil {
+		a.stop()
+	}
+	return a.checkpoint(true)
+}
+
+// checkpoint flushes the files, rotates the ones that need it and saves the state.
+// Rotated files are compressed once the state no longer refers to them.
+func (a *logArchive) checkpoint(closing bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	latestDate := ""
+	for partition := range a.files {
+		if a.partitionBy == "date" && partition > latestDate && partition != "unknown" {
+			latestDate = partition
+		}
+	}
+
+	var rotated []string
+	for partition, af := range a.files {
+		if err := af.w.Flush(); err != nil {
+			return fmt.Errorf("failed writing to %s: %w", af.path, err)
+		}
+
+		rotate := (a.rotateSize > 0 && af.size >= a.rotateSize) ||
+			(a.rotateInterval > 0 && time.Since(af.openedAt) >= a.rotateInterval) ||
+			// past days won't get new entries
+			(a.partitionBy == "date" && partition != "unknown" && partition < latestDate)
+		rel, err := filepath.Rel(a.dir, af.path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !rotate {
+			a.sizes[rel] = af.size
+			if !closing {
+				continue
+			}
+		}
+
+		if err := af.file.Close(); err != nil {
+			return err
+		}
+		delete(a.files, partition)
+		if !rotate {
+			continue
+		}
+
+		path, err := a.rotate(af)
+		if err != nil {
+			return err
+		}
+		delete(a.sizes, rel)
+		rotated = append(rotated, path)
+	}
+
+	if err := a.saveState(); err != nil {
+		return err
+	}
+
+	if a.compress {
+		for _, path := range rotated {
+			if err := compressFile(path); err != nil {
+				terminal.Warnf("failed compressing %s: %v\n", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rotate renames the active file of af after the time it was rotated.
+func (a *logArchive) rotate(af *archiveFile) (string, error) {
+	base := filepath.Join(filepath.Dir(af.path), fmt.Sprintf("%s-%s", a.app, time.Now().UTC().Format("20060102T150405Z")))
+	path := base + ".ndjson"
+	for i := 1; fileExists(path) || fileExists(path+".gz"); i++ {
+		path = fmt.Sprintf("%s-%d.ndjson", base, i)
+	}
+	if err := os.Rename(af.path, path); err != nil {
+		return "", fmt.Errorf("failed rotating %s: %w", af.path, err)
+	}
+	return path, nil
+}
+
+func (a *logArchive) saveState() error {
+	// entries logged earlier than the window aren't fetched again on resume
+	cutoff := a.lastTime.Add(-archiveResumeWindow)
+	recent := a.recent[:0]
+	for _, k := range a.recent {
+		if !k.Time.Before(cutoff) {
+			recent = append(recent, k)
+		}
+	}
+	a.recent = recent
+
+	state := archiveState{
+		LastTimestamp: a.lastTimestamp,
+		RecentKeys:    a.recent,
+		Files:         a.sizes,
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.dir, archiveStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed saving archive state: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressFile gzips path into path.gz and removes path.
+func compressFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,121 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/logs"
+)
+
+func readArchivedMessages(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var messages []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry logs.LogEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		messages = append(messages, entry.Message)
+	}
+	require.NoError(t, scanner.Err())
+	return messages
+}
+
+func TestLogArchiveResume(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *logArchive {
+		a := &logArchive{dir: dir, app: "my-app", partitionBy: "date"}
+		require.NoError(t, a.open())
+		return a
+	}
+	active := filepath.Join(dir, "2023-06-01", "my-app.ndjson")
+
+	a := open()
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "a"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b"}))
+	require.NoError(t, a.checkpoint(false))
+
+	// written but never checkpointed, as if flyctl was killed
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:02Z", Message: "c"}))
+	require.NoError(t, a.files["2023-06-01"].w.Flush())
+	assert.Equal(t, []string{"a", "b", "c"}, readArchivedMessages(t, active))
+
+	a = open()
+	assert.Equal(t, []string{"a", "b"}, readArchivedMessages(t, active))
+	assert.Equal(t, "2023-06-01T12:00:01Z", a.ResumeFrom().Format("2006-01-02T15:04:05Z07:00"))
+
+	// the logs fetched again from a little before the resume point, with a late entry
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T11:50:00Z", Message: "before the window"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "a"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:00.5Z", Message: "late"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b2"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:02Z", Message: "c"}))
+	require.NoError(t, a.Close())
+	assert.Equal(t, []string{"a", "b", "late", "b2", "c"}, readArchivedMessages(t, active))
+}
+
+func TestLogArchiveKeepsFilesMissingFromState(t *testing.T) {
+	dir := t.TempDir()
+	active := filepath.Join(dir, "2023-06-01", "my-app.ndjson")
+	require.NoError(t, os.MkdirAll(filepath.Dir(active), 0o755))
+	line, err := json.Marshal(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "a"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(active, append(line, '\n'), 0o644))
+
+	a := &logArchive{dir: dir, app: "my-app", partitionBy: "date"}
+	require.NoError(t, a.open())
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b"}))
+	require.NoError(t, a.Close())
+	assert.Equal(t, []string{"a", "b"}, readArchivedMessages(t, active))
+}
+
+func TestLogArchiveForgetsKeysPastTheWindow(t *testing.T) {
+	a := &logArchive{dir: t.TempDir(), app: "my-app", partitionBy: "date"}
+	require.NoError(t, a.open())
+
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "old"}))
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:05:00Z", Message: "new"}))
+	require.NoError(t, a.Close())
+
+	require.Len(t, a.recent, 1)
+	assert.Equal(t, entryKey(logs.LogEntry{Timestamp: "2023-06-01T12:05:00Z", Message: "new"}), a.recent[0].Key)
+}
+
+func TestLogArchiveRotation(t *testing.T) {
+	dir := t.TempDir()
+	a := &logArchive{dir: dir, app: "my-app", partitionBy: "instance", rotateSize: 10, compress: true}
+	require.NoError(t, a.open())
+
+	require.NoError(t, a.Write(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Instance: "m1", Message: "hello"}))
+	require.NoError(t, a.checkpoint(false))
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "m1", "my-app-*.ndjson.gz"))
+	require.NoError(t, err)
+	require.Len(t, rotated, 1)
+	assert.NoFileExists(t, filepath.Join(dir, "m1", "my-app.ndjson"))
+
+	f, err := os.Open(rotated[0])
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	var entry logs.LogEntry
+	require.NoError(t, json.NewDecoder(zr).Decode(&entry))
+	assert.Equal(t, "hello", entry.Message)
+}
+
+func TestLogArchiveOptionsValidation(t *testing.T) {
+	a := &logArchive{dir: t.TempDir(), app: "my-app", partitionBy: "host"}
+	assert.Error(t, a.open())
+}
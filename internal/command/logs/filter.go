@@ -0,0 +1,127 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/logs"
+)
+
+var filterFlags = flag.Set{
+	flag.String{
+		Name:        "level",
+		Description: "Only show logs at or above this level (trace, debug, info, warn, error or fatal)",
+	},
+	flag.String{
+		Name:        "grep",
+		Description: "Only show logs whose message matches this regular expression",
+	},
+	flag.String{
+		Name:        "exclude",
+		Description: "Hide logs whose message matches this regular expression",
+	},
+	flag.String{
+		Name:        "process-group",
+		Description: "Only show logs of the machines in this process group",
+	},
+	flag.StringSlice{
+		Name:        "http-status",
+		Description: "Only show requests with these HTTP response statuses, as codes (404), classes (5xx) or ranges (500-503). Can be specified multiple times.",
+	},
+}
+
+// newFilter builds the client side filter of the logs from the command line flags.
+func newFilter(ctx context.Context, appName string) (*logs.Filter, error) {
+	filter := &logs.Filter{
+		MinLevel: flag.GetString(ctx, "level"),
+	}
+
+	if filter.MinLevel != "" {
+		if err := logs.ValidateLevel(filter.MinLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if expr := flag.GetString(ctx, "grep"); expr != "" {
+		if filter.Grep, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid --grep expression: %w", err)
+		}
+	}
+	if expr := flag.GetString(ctx, "exclude"); expr != "" {
+		if filter.Exclude, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid --exclude expression: %w", err)
+		}
+	}
+
+	for _, status := range flag.GetStringSlice(ctx, "http-status") {
+		r, err := logs.ParseStatusRange(status)
+		if err != nil {
+			return nil, err
+		}
+		filter.HTTPStatus = append(filter.HTTPStatus, r)
+	}
+
+	if group := flag.GetString(ctx, "process-group"); group != "" {
+		flapsClient, err := flaps.NewFromAppName(ctx, appName)
+		if err != nil {
+			return nil, fmt.Errorf("could not create flaps client: %w", err)
+		}
+		matcher := &processGroupMatcher{flapsClient: flapsClient, group: group}
+		if err := matcher.refresh(ctx); err != nil {
+			return nil, err
+		}
+		filter.Instance = func(instance string) bool {
+			return matcher.match(ctx, instance)
+		}
+	}
+
+	return filter, nil
+}
+
+// processGroupRefreshInterval is how often, at most, machines are listed again
+// when logs come from a machine that wasn't seen before.
+const processGroupRefreshInterval = 10 * time.Second
+
+// processGroupMatcher tells whether log entries come from a machine in group.
+type processGroupMatcher struct {
+	flapsClient *flaps.Client
+	group       string
+
+	mu          sync.Mutex
+	groups      map[string]string
+	refreshedAt time.Time
+}
+
+func (m *processGroupMatcher) refresh(ctx context.Context) error {
+	m.refreshedAt = time.Now()
+	machines, err := m.flapsClient.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("could not list machines to filter by process group: %w", err)
+	}
+
+	m.groups = make(map[string]string, len(machines))
+	for _, machine := range machines {
+		m.groups[machine.ID] = machine.ProcessGroup()
+	}
+	return nil
+}
+
+func (m *processGroupMatcher) match(ctx context.Context, instance string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[instance]
+	if !ok && time.Since(m.refreshedAt) > processGroupRefreshInterval {
+		// a machine created after we started, or logs not coming from a machine
+		if err := m.refresh(ctx); err == nil {
+			group = m.groups[instance]
+		}
+	}
+	return group == m.group
+}
@@ -4,7 +4,8 @@ package logs
 import (
 	"context"
 	"errors"
-	"io"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/azazeal/pause"
@@ -16,7 +17,6 @@ import (
 	"github.com/superfly/flyctl/logs"
 
 	"github.com/superfly/flyctl/client"
-	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flag"
@@ -31,19 +31,45 @@ the Fly platform.
 
 Logs can be filtered to a specific instance using the --instance/-i flag or
 to all instances running in a specific region using the --region/-r flag.
+
+Logs can also be filtered on the client side by minimum level with --level,
+by message with the --grep and --exclude regular expressions, by process group
+with --process-group and by HTTP response status with --http-status. Filters
+apply to the --json output as well.
+
+Past logs can be shown with --since, either as a duration like 2h or as a time
+like 2023-06-01T15:04:05Z, and --until, as far back as the logs API keeps them.
+The API can't page back past its oldest logs, so a warning tells when part of
+the range is no longer available.
+Logs stop at the end of the range or, with --no-tail, once they caught up with
+the most recent ones. Otherwise new logs keep being shown as they come in.
+
+The output format is set with --format: text (the default), json, ndjson with
+one compact JSON object per line, logfmt, raw for the messages only, or a Go
+template executed with every entry, like '{{.Timestamp}} {{.Message}}'. The
+fields shown in the text, ndjson and logfmt formats can be chosen with --fields.
+
+With --output-dir, logs are archived as NDJSON files partitioned in directories
+by date, instance or region instead of being printed. Files are rotated by size
+or age, optionally compressed, and a later run resumes after the last entry
+archived.
+
+Logs of several apps are shown together, sorted by time and prefixed with the
+app name, by repeating --app or with a glob over the names of your apps, like
+--app 'web-*', optionally restricted to an organization with --org.
 `
 		short = "View app logs"
 	)
 
 	cmd = command.New("logs", short, long, run,
 		command.RequireSession,
-		command.RequireAppName,
+		requireAppNames,
 	)
 
 	cmd.Args = cobra.NoArgs
 
 	flag.Add(cmd,
-		flag.App(),
+		appsFlags,
 		flag.AppConfig(),
 		flag.Region(),
 		flag.JSONOutput(),
@@ -52,34 +78,213 @@ to all instances running in a specific region using the --region/-r flag.
 			Shorthand:   "i",
 			Description: "Filter by instance ID",
 		},
+		filterFlags,
+		flag.String{
+			Name:        "since",
+			Description: "Show logs since this duration ago (2h) or time (2006-01-02T15:04:05Z)",
+		},
+		flag.String{
+			Name:        "until",
+			Description: "Show logs until this duration ago (30m) or time (2006-01-02T15:04:05Z), implies --no-tail",
+		},
+		flag.Bool{
+			Name:        "no-tail",
+			Description: "Exit once the most recent logs were shown instead of waiting for new ones",
+		},
+		flag.String{
+			Name:        "format",
+			Description: "Output format: text, json, ndjson, logfmt, raw or a Go template like '{{.Timestamp}} {{.Message}}'",
+		},
+		flag.StringSlice{
+			Name:        "fields",
+			Description: "Fields to show: " + strings.Join(render.LogFields, ", ") + ". Can be specified multiple times.",
+		},
+		archiveFlags,
 	)
 	cmd.AddCommand(newShip(), newUnship(), newDashboard())
 	return
 }
 
-func run(ctx context.Context) error {
+// entryWriter outputs a log entry.
+type entryWriter func(entry logs.LogEntry) error
+
+func run(ctx context.Context) (err error) {
 	client := client.FromContext(ctx).API()
+	appNames := appNamesFromContext(ctx)
+
+	if len(appNames) > 1 {
+		switch {
+		case flag.GetString(ctx, "process-group") != "":
+			return errors.New("--process-group is only supported with a single app")
+		case flag.GetString(ctx, "output-dir") != "":
+			return errors.New("--output-dir is only supported with a single app")
+		}
+	}
+
+	now := time.Now()
+	appOpts := make([]*logs.LogOptions, 0, len(appNames))
+	for _, appName := range appNames {
+		opts := &logs.LogOptions{
+			AppName:    appName,
+			RegionCode: config.FromContext(ctx).Region,
+			VMID:       flag.GetString(ctx, "instance"),
+		}
+		if err := setTimeRange(ctx, opts, now); err != nil {
+			return err
+		}
+		appOpts = append(appOpts, opts)
+	}
+
+	filter, err := newFilter(ctx, appNames[0])
+	if err != nil {
+		return err
+	}
+
+	formatter, err := newFormatter(ctx, appNames)
+	if err != nil {
+		return err
+	}
+	out := iostreams.FromContext(ctx).Out
+	write := func(entry logs.LogEntry) error {
+		return formatter(out, entry)
+	}
+
+	if flag.GetString(ctx, "output-dir") != "" {
+		archive, err := startArchive(ctx, appOpts[0])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := archive.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		write = archive.Write
+	}
 
-	opts := &logs.LogOptions{
-		AppName:    appconfig.NameFromContext(ctx),
-		RegionCode: config.FromContext(ctx).Region,
-		VMID:       flag.GetString(ctx, "instance"),
+	if appOpts[0].NoTail {
+		return printHistory(ctx, client, appOpts, filter, write)
 	}
 
 	var eg *errgroup.Group
 	eg, ctx = errgroup.WithContext(ctx)
 
-	pollingCtx, cancelPolling := context.WithCancel(ctx)
-	pollEntries := poll(pollingCtx, eg, client, opts)
-	liveEntries := nats(ctx, eg, client, opts, cancelPolling)
+	var streams []<-chan logs.LogEntry
+	for _, opts := range appOpts {
+		streams = append(streams, tail(ctx, eg, client, opts))
+	}
+	entries := mergeStreams(ctx, eg, streams...)
 
 	eg.Go(func() error {
-		return printStreams(ctx, pollEntries, liveEntries)
+		return printStreams(ctx, filter, write, entries)
 	})
 
 	return eg.Wait()
 }
 
+// tail returns the logs of opts: its history since its start time, if any, and then the most
+// recent logs, which come from polling until the live stream takes over. Polling can't start
+// where the history ends, it starts over from the oldest logs the API keeps, so the ones the
+// history already returned are skipped.
+func tail(ctx context.Context, eg *errgroup.Group, client *api.Client, opts *logs.LogOptions) <-chan logs.LogEntry {
+	tailOpts := *opts
+	tailOpts.StartTime = time.Time{}
+
+	live := func() <-chan logs.LogEntry {
+		pollingCtx, cancelPolling := context.WithCancel(ctx)
+		polled := poll(pollingCtx, eg, client, &tailOpts)
+		streamed := nats(ctx, eg, client, &tailOpts, cancelPolling)
+		return handOff(ctx, eg, polled, func() <-chan logs.LogEntry { return streamed })
+	}
+	if opts.StartTime.IsZero() {
+		return live()
+	}
+
+	historyOpts := *opts
+	historyOpts.NoTail = true
+	return handOff(ctx, eg, poll(ctx, eg, client, &historyOpts), live)
+}
+
+// startArchive opens the archive of --output-dir and checkpoints it in the background.
+// Logs start after the last archived entry unless --since is set.
+func startArchive(ctx context.Context, opts *logs.LogOptions) (*logArchive, error) {
+	if flag.GetString(ctx, "format") != "" || config.FromContext(ctx).JSONOutput {
+		return nil, errors.New("--format and --json are not supported with --output-dir, logs are archived as NDJSON")
+	}
+
+	archive, err := newLogArchive(ctx, opts.AppName)
+	if err != nil {
+		return nil, err
+	}
+
+	errOut := iostreams.FromContext(ctx).ErrOut
+	fmt.Fprintf(errOut, "Archiving logs of %s to %s\n", opts.AppName, archive.dir)
+	if resumeFrom := archive.ResumeFrom(); !resumeFrom.IsZero() && opts.StartTime.IsZero() {
+		fmt.Fprintf(errOut, "Resuming after %s\n", resumeFrom.Format(time.RFC3339Nano))
+		// entries logged a little earlier may still be coming in
+		opts.StartTime = resumeFrom.Add(-archiveResumeWindow)
+	}
+
+	archive.Start(ctx)
+	return archive, nil
+}
+
+// setTimeRange sets the time range of opts from --since, --until and --no-tail.
+func setTimeRange(ctx context.Context, opts *logs.LogOptions, now time.Time) (err error) {
+	if since := flag.GetString(ctx, "since"); since != "" {
+		if opts.StartTime, err = parseLogTime(since, now); err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if until := flag.GetString(ctx, "until"); until != "" {
+		if opts.EndTime, err = parseLogTime(until, now); err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+		if opts.EndTime.Before(opts.StartTime) {
+			return errors.New("--until must be after --since")
+		}
+	}
+	opts.NoTail = flag.GetBool(ctx, "no-tail") || !opts.EndTime.IsZero()
+	return nil
+}
+
+// printHistory polls the logs of every app of appOpts until the end of its time range or
+// the most recent logs, and prints them sorted by timestamp.
+func printHistory(ctx context.Context, client *api.Client, appOpts []*logs.LogOptions, filter *logs.Filter, write entryWriter) error {
+	var eg *errgroup.Group
+	eg, ctx = errgroup.WithContext(ctx)
+
+	streams := make([]<-chan logs.LogEntry, 0, len(appOpts))
+	for _, opts := range appOpts {
+		streams = append(streams, poll(ctx, eg, client, opts))
+	}
+	stream := mergeStreams(ctx, eg, streams...)
+
+	var entries []logs.LogEntry
+	eg.Go(func() error {
+		for entry := range stream {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	entries = dedupeEntries(entries)
+	sortEntries(entries)
+
+	for _, entry := range entries {
+		if !filter.Match(entry) {
+			continue
+		}
+		if err := write(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func poll(ctx context.Context, eg *errgroup.Group, client *api.Client, opts *logs.LogOptions) <-chan logs.LogEntry {
 	c := make(chan logs.LogEntry)
 
@@ -129,25 +334,22 @@ func nats(ctx context.Context, eg *errgroup.Group, client *api.Client, opts *log
 	return c
 }
 
-func printStreams(ctx context.Context, streams ...<-chan logs.LogEntry) error {
+func printStreams(ctx context.Context, filter *logs.Filter, write entryWriter, streams ...<-chan logs.LogEntry) error {
 	var eg *errgroup.Group
 	eg, ctx = errgroup.WithContext(ctx)
 
-	out := iostreams.FromContext(ctx).Out
-	json := config.FromContext(ctx).JSONOutput
-
 	for _, stream := range streams {
 		stream := stream
 
 		eg.Go(func() error {
-			return printStream(ctx, out, stream, json)
+			return printStream(ctx, stream, filter, write)
 		})
 	}
 
 	return eg.Wait()
 }
 
-func printStream(ctx context.Context, w io.Writer, stream <-chan logs.LogEntry, json bool) error {
+func printStream(ctx context.Context, stream <-chan logs.LogEntry, filter *logs.Filter, write entryWriter) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -156,21 +358,36 @@ func printStream(ctx context.Context, w io.Writer, stream <-chan logs.LogEntry,
 			if !ok {
 				return nil
 			}
-
-			var err error
-			if json {
-				err = render.JSON(w, entry)
-			} else {
-				err = render.LogEntry(w, entry,
-					render.HideAllocID(),
-					render.RemoveNewlines(),
-					render.HideRegion(),
-				)
+			if !filter.Match(entry) {
+				continue
 			}
 
-			if err != nil {
+			if err := write(entry); err != nil {
 				return err
 			}
 		}
 	}
 }
+
+// newFormatter returns the formatter of --format and --fields, or of --json. The logs
+// of several apps are prefixed with the app name.
+func newFormatter(ctx context.Context, appNames []string) (render.LogFormatter, error) {
+	format := flag.GetString(ctx, "format")
+	if config.FromContext(ctx).JSONOutput {
+		if format != "" && format != "json" {
+			return nil, errors.New("--json and --format are not supported together")
+		}
+		format = "json"
+	}
+
+	opts := []render.LogOption{
+		render.HideAllocID(),
+		render.RemoveNewlines(),
+		render.HideRegion(),
+		render.Fields(flag.GetStringSlice(ctx, "fields")...),
+	}
+	if len(appNames) > 1 {
+		opts = append(opts, render.ShowApp(appNameWidth(appNames)))
+	}
+	return render.NewLogFormatter(format, opts...)
+}
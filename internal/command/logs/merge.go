@@ -0,0 +1,198 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/superfly/flyctl/logs"
+)
+
+// mergeInterval is how long entries are held to be sorted with the ones received right after
+const mergeInterval = 500 * time.Millisecond
+
+// parseLogTime parses value as a duration before now, like 2h, or as an RFC 3339 time.
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q, durations must be positive", value)
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected a duration like 2h or a time like 2006-01-02T15:04:05Z", value)
+	}
+	return t, nil
+}
+
+func entryTime(entry logs.LogEntry) time.Time {
+	ts, _ := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	return ts
+}
+
+// sortEntries sorts entries by timestamp, keeping the order of entries logged at the same time.
+func sortEntries(entries []logs.LogEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entryTime(entries[i]).Before(entryTime(entries[j]))
+	})
+}
+
+// entryKey identifies entry among the ones logged around the same time.
+func entryKey(entry logs.LogEntry) string {
+	return entry.Timestamp + "\x00" + entry.Instance + "\x00" + entry.Message
+}
+
+// dedupeEntries returns entries without the duplicates.
+func dedupeEntries(entries []logs.LogEntry) []logs.LogEntry {
+	seen := make(map[string]struct{}, len(entries))
+	deduped := entries[:0]
+	for _, entry := range entries {
+		key := entryKey(entry)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		deduped = append(deduped, entry)
+	}
+	return deduped
+}
+
+// boundary is the timestamp of the newest entry of a stream and the entries logged at that time.
+// The stream taking over from it only returns entries past the boundary.
+type boundary struct {
+	ts   time.Time
+	keys map[string]struct{}
+}
+
+func (b *boundary) add(entry logs.LogEntry) {
+	ts := entryTime(entry)
+	switch {
+	case ts.After(b.ts):
+		b.ts = ts
+		b.keys = map[string]struct{}{entryKey(entry): {}}
+	case ts.Equal(b.ts) && !ts.IsZero():
+		b.keys[entryKey(entry)] = struct{}{}
+	}
+}
+
+// passed reports whether entry is past b, entries without a valid timestamp always are.
+func (b *boundary) passed(entry logs.LogEntry) bool {
+	ts := entryTime(entry)
+	if ts.IsZero() || ts.After(b.ts) {
+		return true
+	}
+	if ts.Before(b.ts) {
+		return false
+	}
+	_, seen := b.keys[entryKey(entry)]
+	return !seen
+}
+
+// handOff returns the entries of from and then, once it's closed, the entries of the stream
+// returned by next that are past the newest entry of from. The streams of the logs of an app,
+// which overlap where one takes over from the other, are chained this way without duplicates.
+func handOff(ctx context.Context, eg *errgroup.Group, from <-chan logs.LogEntry, next func() <-chan logs.LogEntry) <-chan logs.LogEntry {
+	out := make(chan logs.LogEntry)
+
+	eg.Go(func() error {
+		defer close(out)
+
+		var b boundary
+		for entry := range from {
+			b.add(entry)
+			select {
+			case out <- entry:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		for entry := range next() {
+			if !b.passed(entry) {
+				continue
+			}
+			select {
+			case out <- entry:
+			case <-ctx.Done():
+			}
+		}
+		return nil
+	})
+
+	return out
+}
+
+// mergeStreams fans streams into a single one. Entries received within
+// mergeInterval of each other are sorted by timestamp.
+func mergeStreams(ctx context.Context, eg *errgroup.Group, streams ...<-chan logs.LogEntry) <-chan logs.LogEntry {
+	in := make(chan logs.LogEntry)
+
+	var wg sync.WaitGroup
+	for _, stream := range streams {
+		stream := stream
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// keep draining once ctx is done, so that the streams can close
+			for entry := range stream {
+				select {
+				case in <- entry:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+
+	out := make(chan logs.LogEntry)
+
+	eg.Go(func() error {
+		defer close(out)
+
+		var (
+			pending []logs.LogEntry
+			ticker  = time.NewTicker(mergeInterval)
+		)
+		defer ticker.Stop()
+
+		flush := func() error {
+			sortEntries(pending)
+			for _, entry := range pending {
+				select {
+				case out <- entry:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			pending = pending[:0]
+			return nil
+		}
+
+		for {
+			select {
+			case entry, ok := <-in:
+				if !ok {
+					return flush()
+				}
+				pending = append(pending, entry)
+			case <-ticker.C:
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return out
+}
@@ -0,0 +1,108 @@
+package logs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/superfly/flyctl/logs"
+)
+
+func TestParseLogTime(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := parseLogTime("2h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-2*time.Hour), got)
+
+	got, err = parseLogTime("2023-06-01T09:30:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 6, 1, 9, 30, 0, 0, time.UTC), got)
+
+	_, err = parseLogTime("-5m", now)
+	assert.Error(t, err)
+	_, err = parseLogTime("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestDedupeAndSortEntries(t *testing.T) {
+	entries := []logs.LogEntry{
+		{Timestamp: "2023-06-01T12:00:02Z", Instance: "m1", Message: "c"},
+		{Timestamp: "2023-06-01T12:00:00Z", Instance: "m1", Message: "a"},
+		{Timestamp: "2023-06-01T12:00:02Z", Instance: "m1", Message: "c"},
+		{Timestamp: "2023-06-01T12:00:01Z", Instance: "m2", Message: "b"},
+		{Timestamp: "2023-06-01T12:00:00Z", Instance: "m2", Message: "a"},
+	}
+
+	entries = dedupeEntries(entries)
+	sortEntries(entries)
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Instance+":"+e.Message)
+	}
+	assert.Equal(t, []string{"m1:a", "m2:a", "m2:b", "m1:c"}, got)
+}
+
+func TestBoundaryPassed(t *testing.T) {
+	var b boundary
+	b.add(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Instance: "m1", Message: "a"})
+	b.add(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Instance: "m1", Message: "b"})
+	b.add(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Instance: "m2", Message: "b"})
+
+	assert.False(t, b.passed(logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Instance: "m2", Message: "z"}))
+	assert.False(t, b.passed(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Instance: "m2", Message: "b"}))
+	assert.True(t, b.passed(logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Instance: "m3", Message: "b"}))
+	assert.True(t, b.passed(logs.LogEntry{Timestamp: "2023-06-01T12:00:02Z", Instance: "m1", Message: "a"}))
+	assert.True(t, b.passed(logs.LogEntry{Timestamp: "not a time", Message: "c"}))
+}
+
+func TestHandOff(t *testing.T) {
+	history := make(chan logs.LogEntry, 2)
+	history <- logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "a"}
+	history <- logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b"}
+	close(history)
+
+	// tailing starts over from older logs than the history and overlaps with all of it
+	next := func() <-chan logs.LogEntry {
+		polled := make(chan logs.LogEntry, 5)
+		polled <- logs.LogEntry{Timestamp: "2023-06-01T11:59:59Z", Message: "old"}
+		polled <- logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "a"}
+		polled <- logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b"}
+		polled <- logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b2"}
+		polled <- logs.LogEntry{Timestamp: "2023-06-01T12:00:02Z", Message: "c"}
+		close(polled)
+		return polled
+	}
+
+	eg, ctx := errgroup.WithContext(context.Background())
+	var got []string
+	for entry := range handOff(ctx, eg, history, next) {
+		got = append(got, entry.Message)
+	}
+	require.NoError(t, eg.Wait())
+	assert.Equal(t, []string{"a", "b", "b2", "c"}, got)
+}
+
+func TestMergeStreams(t *testing.T) {
+	polled := make(chan logs.LogEntry, 2)
+	polled <- logs.LogEntry{Timestamp: "2023-06-01T12:00:01Z", Message: "b"}
+	polled <- logs.LogEntry{Timestamp: "2023-06-01T12:00:00Z", Message: "a"}
+	close(polled)
+
+	live := make(chan logs.LogEntry, 1)
+	live <- logs.LogEntry{Timestamp: "2023-06-01T12:00:02Z", Message: "c"}
+	close(live)
+
+	eg, ctx := errgroup.WithContext(context.Background())
+	var got []string
+	for entry := range mergeStreams(ctx, eg, polled, live) {
+		got = append(got, entry.Message)
+	}
+	require.NoError(t, eg.Wait())
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+}
@@ -20,15 +20,24 @@ import (
 func newShip() (cmd *cobra.Command) {
 
 	const (
-		short = "Ship application logs to Logtail"
-		long  = short + "\n"
+		short = "Ship application logs to Logtail, Loki, Elasticsearch or an HTTP endpoint"
+		long  = short + `
+
+Logs are shipped by machines of the log shipper app of the organization, provisioned
+on first use. Choose where they go with --sink and its --<sink>-* settings. Logs
+sent to Loki, Elasticsearch or an HTTP endpoint are shipped by a Vector machine of
+their own, whose settings, credentials included, are stored as secrets of the log
+shipper app.
+`
 	)
 
 	cmd = command.New("ship", short, long, runSetup, command.RequireSession, command.RequireAppName)
 	flag.Add(cmd,
 		flag.App(),
 		flag.AppConfig(),
+		sinkFlags(),
 	)
+	cmd.AddCommand(newShipStatus())
 	return cmd
 }
 
@@ -38,6 +47,13 @@ func runSetup(ctx context.Context) (err error) {
 	appName := appconfig.NameFromContext(ctx)
 	var logtailToken string
 
+	sink, err := findLogSink(flag.GetString(ctx, "sink"))
+	if err != nil {
+		return err
+	}
+	// settings of other sinks are rejected for the default one as well, the Logtail
+	// add-on has none of its own
+	secrets, err := sinkSecrets(ctx, sink, appName)
 	if err != nil {
 		return err
 	}
@@ -56,6 +72,10 @@ func runSetup(ctx context.Context) (err error) {
 		return err
 	}
 
+	if sink.name != defaultLogSink {
+		return shipToSink(ctx, targetApp, sink, secrets)
+	}
+
 	// Fetch or create the Logtail integration for the app
 
 	var addOnName = appName + "-log-shipper"
@@ -81,10 +101,8 @@ func runSetup(ctx context.Context) (err error) {
 	} else {
 		logtailToken = getAddOnResponse.AddOn.Token
 	}
-	// Fetch a macaroon token whose access is limited to reading this app's logs
-	tokenResponse, err := gql.CreateLimitedAccessToken(ctx, client, appName+"-logs", targetOrg.Id, "read_organization_apps", &gql.LimitedAccessTokenOptions{
-		"app_ids": []string{targetApp.Name},
-	}, "")
+
+	token, err := createLogsToken(ctx, targetApp)
 
 	if err != nil {
 		return
@@ -96,7 +114,7 @@ func runSetup(ctx context.Context) (err error) {
 		return
 	}
 
-	cmd := []string{"/add-logger.sh", targetApp.Name, "logtail", "'" + tokenResponse.CreateLimitedAccessToken.LimitedAccessToken.TokenHeader + "'", logtailToken}
+	cmd := []string{"/add-logger.sh", targetApp.Name, "logtail", "'" + token + "'", logtailToken}
 
 	fmt.Fprintf(io.Out, "Add logger source to log shipper VM %s\n", machine.ID)
 	request := &api.MachineExecRequest{
@@ -113,42 +131,76 @@ func runSetup(ctx context.Context) (err error) {
 	return
 }
 
-func EnsureShipperMachine(ctx context.Context, targetOrg gql.AppDataOrganization) (flapsClient *flaps.Client, machine *api.Machine, err error) {
+// createLogsToken returns a macaroon token whose access is limited to reading the logs of targetApp.
+func createLogsToken(ctx context.Context, targetApp gql.AppData) (string, error) {
+	client := client.FromContext(ctx).API().GenqClient
+
+	tokenResponse, err := gql.CreateLimitedAccessToken(ctx, client, targetApp.Name+"-logs", targetApp.Organization.Id, "read_organization_apps", &gql.LimitedAccessTokenOptions{
+		"app_ids": []string{targetApp.Name},
+	}, "")
+
+	if err != nil {
+		return "", err
+	}
+	return tokenResponse.CreateLimitedAccessToken.LimitedAccessToken.TokenHeader, nil
+}
 
+// findShipperApp returns the log shipper app of targetOrg, or nil if there is none.
+func findShipperApp(ctx context.Context, targetOrg gql.AppDataOrganization) (*gql.AppData, error) {
 	client := client.FromContext(ctx).API().GenqClient
-	io := iostreams.FromContext(ctx)
 
 	appsResult, err := gql.GetAppsByRole(ctx, client, "log-shipper", targetOrg.Id)
 
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	var shipperApp gql.AppData
+	if len(appsResult.Apps.Nodes) == 0 {
+		return nil, nil
+	}
+	return &appsResult.Apps.Nodes[0].AppData, nil
+}
 
-	if len(appsResult.Apps.Nodes) > 0 {
-		shipperApp = appsResult.Apps.Nodes[0].AppData
-	} else {
-		input := gql.DefaultCreateAppInput()
-		input.Machines = true
-		input.OrganizationId = targetOrg.Id
-		input.AppRoleId = "log-shipper"
-		input.Name = targetOrg.RawSlug + "-log-shipper"
+// ensureShipperApp returns the log shipper app of targetOrg, creating it if there is none.
+func ensureShipperApp(ctx context.Context, targetOrg gql.AppDataOrganization) (*gql.AppData, error) {
+	client := client.FromContext(ctx).API().GenqClient
+	io := iostreams.FromContext(ctx)
 
-		createdAppResult, err := gql.CreateApp(ctx, client, input)
+	existingApp, err := findShipperApp(ctx, targetOrg)
 
-		if err != nil {
-			return nil, nil, err
-		}
+	if err != nil || existingApp != nil {
+		return existingApp, err
+	}
 
-		shipperApp = createdAppResult.CreateApp.App.AppData
-		fmt.Fprintf(io.ErrOut, "Provisioning a log shipper VM in the app named %s\n", shipperApp.Name)
+	input := gql.DefaultCreateAppInput()
+	input.Machines = true
+	input.OrganizationId = targetOrg.Id
+	input.AppRoleId = "log-shipper"
+	input.Name = targetOrg.RawSlug + "-log-shipper"
+
+	createdAppResult, err := gql.CreateApp(ctx, client, input)
+
+	if err != nil {
+		return nil, err
 	}
+
+	fmt.Fprintf(io.ErrOut, "Provisioning a log shipper VM in the app named %s\n", createdAppResult.CreateApp.App.AppData.Name)
+	return &createdAppResult.CreateApp.App.AppData, nil
+}
+
+// EnsureShipperMachine returns the Logtail log shipper machine of targetOrg, launching it if there is none.
+func EnsureShipperMachine(ctx context.Context, targetOrg gql.AppDataOrganization) (flapsClient *flaps.Client, machine *api.Machine, err error) {
+
+	client := client.FromContext(ctx).API().GenqClient
+	io := iostreams.FromContext(ctx)
+
+	shipperApp, err := ensureShipperApp(ctx, targetOrg)
+
 	if err != nil {
 		return nil, nil, err
 	}
 
-	flapsClient, err = flaps.New(ctx, gql.AppForFlaps(shipperApp))
+	flapsClient, err = flaps.New(ctx, gql.AppForFlaps(*shipperApp))
 
 	if err != nil {
 		return
@@ -160,10 +212,15 @@ func EnsureShipperMachine(ctx context.Context, targetOrg gql.AppDataOrganization
 		return nil, nil, err
 	}
 
-	if len(machines) > 0 {
-		machine = machines[0]
+	// Machines shipping logs to other sinks run Vector
+	for _, m := range machines {
+		if !isSinkMachine(m) {
+			machine = m
+			break
+		}
+	}
 
-	} else {
+	if machine == nil {
 
 		machineConf := &api.MachineConfig{
 			Guest: &api.MachineGuest{
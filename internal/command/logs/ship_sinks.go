@@ -0,0 +1,140 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/superfly/flyctl/internal/flag"
+)
+
+const defaultLogSink = "logtail"
+
+// sinkSetting is a setting of a log sink, given with the --<sink>-<name> flag and
+// stored as a secret of the log shipper app.
+type sinkSetting struct {
+	name        string
+	description string
+	required    bool
+}
+
+// logSink is a destination the log shipper can send the logs of an app to.
+type logSink struct {
+	name     string
+	settings []sinkSetting
+}
+
+var logSinks = []logSink{
+	{
+		// settings come from the Logtail add-on
+		name: "logtail",
+	},
+	{
+		name: "loki",
+		settings: []sinkSetting{
+			{name: "url", description: "Loki base URL, like https://logs-prod.grafana.net", required: true},
+			{name: "username", description: "Loki basic auth username"},
+			{name: "password", description: "Loki basic auth password or API key"},
+		},
+	},
+	{
+		name: "elasticsearch",
+		settings: []sinkSetting{
+			{name: "endpoint", description: "Elasticsearch endpoint, like https://my-cluster.es.io:9243", required: true},
+			{name: "index", description: "Elasticsearch index the logs are written to"},
+			{name: "username", description: "Elasticsearch basic auth username"},
+			{name: "password", description: "Elasticsearch basic auth password"},
+		},
+	},
+	{
+		name: "http",
+		settings: []sinkSetting{
+			{name: "url", description: "URL the logs are POSTed to as NDJSON", required: true},
+			{name: "token", description: "Bearer token sent with every request"},
+		},
+	},
+}
+
+func findLogSink(name string) (*logSink, error) {
+	names := make([]string, 0, len(logSinks))
+	for i := range logSinks {
+		if logSinks[i].name == name {
+			return &logSinks[i], nil
+		}
+		names = append(names, logSinks[i].name)
+	}
+	return nil, fmt.Errorf("unknown log sink %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+func (s sinkSetting) flagName(sink string) string {
+	return sink + "-" + s.name
+}
+
+// sinkFlags returns the --sink flag and the settings flags of every sink.
+func sinkFlags() flag.Set {
+	flags := flag.Set{
+		flag.String{
+			Name:        "sink",
+			Description: "Where to ship logs to: logtail, loki, elasticsearch or http",
+			Default:     defaultLogSink,
+		},
+	}
+	for _, sink := range logSinks {
+		for _, setting := range sink.settings {
+			flags = append(flags, flag.String{
+				Name:        setting.flagName(sink.name),
+				Description: setting.description,
+			})
+		}
+	}
+	return flags
+}
+
+var nonSecretNameChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// sinkSecretPrefix returns the prefix of the names of the secrets of the log shipper app
+// holding the settings of sink for appName, like MY_APP_LOKI.
+func sinkSecretPrefix(appName, sink string) string {
+	return nonSecretNameChars.ReplaceAllString(strings.ToUpper(appName+"_"+sink), "_")
+}
+
+func (s sinkSetting) secretName(appName, sink string) string {
+	return sinkSecretPrefix(appName, sink) + "_" + strings.ToUpper(s.name)
+}
+
+// sinkSecrets returns the secrets holding the settings of sink for appName from the command
+// line flags. It fails when a required setting is missing or a flag of another sink is set.
+func sinkSecrets(ctx context.Context, sink *logSink, appName string) (map[string]string, error) {
+	for _, other := range logSinks {
+		if other.name == sink.name {
+			continue
+		}
+		for _, setting := range other.settings {
+			if flag.GetString(ctx, setting.flagName(other.name)) != "" {
+				return nil, fmt.Errorf("--%s can only be used with --sink %s", setting.flagName(other.name), other.name)
+			}
+		}
+	}
+
+	secrets := map[string]string{}
+	for _, setting := range sink.settings {
+		value := flag.GetString(ctx, setting.flagName(sink.name))
+		switch {
+		case value != "":
+			secrets[setting.secretName(appName, sink.name)] = value
+		case setting.required:
+			return nil, fmt.Errorf("--%s is required to ship logs to %s", setting.flagName(sink.name), sink.name)
+		}
+	}
+	return secrets, nil
+}
+
+// sinkSecretNames returns the names of all the secrets sink may have for appName.
+func sinkSecretNames(sink *logSink, appName string) []string {
+	names := make([]string, 0, len(sink.settings)+1)
+	for _, setting := range sink.settings {
+		names = append(names, setting.secretName(appName, sink.name))
+	}
+	return append(names, accessTokenSetting.secretName(appName, sink.name))
+}
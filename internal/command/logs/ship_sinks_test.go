@@ -0,0 +1,137 @@
+package logs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func TestFindLogSink(t *testing.T) {
+	sink, err := findLogSink("loki")
+	require.NoError(t, err)
+	assert.Equal(t, "loki", sink.name)
+
+	_, err = findLogSink("splunk")
+	assert.ErrorContains(t, err, "logtail, loki, elasticsearch, http")
+}
+
+func TestSinkSecretNames(t *testing.T) {
+	assert.Equal(t, "MY_APP_2_LOKI", sinkSecretPrefix("my-app.2", "loki"))
+
+	sink, err := findLogSink("elasticsearch")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"MY_APP_ELASTICSEARCH_ENDPOINT",
+		"MY_APP_ELASTICSEARCH_INDEX",
+		"MY_APP_ELASTICSEARCH_USERNAME",
+		"MY_APP_ELASTICSEARCH_PASSWORD",
+		"MY_APP_ELASTICSEARCH_ACCESS_TOKEN",
+	}, sinkSecretNames(sink, "my-app"))
+}
+
+func TestRunSetupRejectsSettingsOfAnotherSink(t *testing.T) {
+	for args, want := range map[string]string{
+		"--loki-url https://logs.example.com":                                            "--loki-url can only be used with --sink loki",
+		"--sink loki --loki-url https://logs.example.com --http-url https://example.com": "--http-url can only be used with --sink http",
+		"--sink elasticsearch":                                                           "--elasticsearch-endpoint is required to ship logs to elasticsearch",
+	} {
+		cmd := newShip()
+		require.NoError(t, cmd.Flags().Parse(strings.Fields(args)))
+
+		ios, _, _, _ := iostreams.Test()
+		ctx := iostreams.NewContext(context.Background(), ios)
+		ctx = client.NewContext(ctx, client.FromToken("test-token"))
+		ctx = flag.NewContext(ctx, cmd.Flags())
+		ctx = appconfig.WithName(ctx, "my-app")
+
+		// the settings are checked before anything is looked up or set up
+		assert.EqualError(t, runSetup(ctx), want, args)
+	}
+}
+
+func TestVectorConfig(t *testing.T) {
+	sink, err := findLogSink("loki")
+	require.NoError(t, err)
+
+	config := vectorConfig("my-org", "my-app", sink, map[string]string{
+		"MY_APP_LOKI_URL":          "https://logs.example.com",
+		"MY_APP_LOKI_ACCESS_TOKEN": "token",
+	})
+	assert.Contains(t, config, `subject = "logs.my-app.>"`)
+	assert.Contains(t, config, `auth.user_password.user = "my-org"`)
+	assert.Contains(t, config, `auth.user_password.password = "${MY_APP_LOKI_ACCESS_TOKEN}"`)
+	assert.Contains(t, config, "[sinks.loki]\ninputs = [\"fly_logs\"]\ntype = \"loki\"\nendpoint = \"${MY_APP_LOKI_URL}\"\n")
+	// the status reads what the sink delivered from the internal metrics of Vector
+	assert.Contains(t, config, "inputs = [\"last_received\", \"vector_metrics\"]")
+	// no credentials were given
+	assert.NotContains(t, config, "auth.strategy = \"basic\"")
+}
+
+func TestParseShipperMetrics(t *testing.T) {
+	metrics := `# HELP fly_log_ship_last_received_timestamp_seconds received_at
+# TYPE fly_log_ship_last_received_timestamp_seconds gauge
+fly_log_ship_last_received_timestamp_seconds 1685620800 1685620801000
+# TYPE vector_component_sent_events_total counter
+vector_component_sent_events_total{component_id="fly_logs",component_kind="source",component_type="nats"} 120 1685620801000
+vector_component_sent_events_total{component_id="loki",component_kind="sink",component_type="loki",output="_default"} 100 1685620801000
+# TYPE vector_buffer_events gauge
+vector_buffer_events{component_id="loki",component_kind="sink",component_type="loki",stage="0"} 20 1685620801000
+`
+	m, err := parseShipperMetrics(metrics, "loki")
+	require.NoError(t, err)
+	require.NotNil(t, m.lastReceived)
+	assert.True(t, m.lastReceived.Equal(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, int64(100), m.sent)
+	assert.Equal(t, int64(20), m.pending)
+
+	m, err = parseShipperMetrics("", "loki")
+	require.NoError(t, err)
+	assert.Nil(t, m.lastReceived)
+	assert.Zero(t, m.sent)
+}
+
+func TestLastLogTime(t *testing.T) {
+	// the oldest logs come first, the newest once the next tokens are followed
+	pages := map[string]string{
+		"":  `{"data":[{"attributes":{"timestamp":"2023-06-01T10:00:00Z"}}],"meta":{"next_token":"a"}}`,
+		"a": `{"data":[{"attributes":{"timestamp":"2023-06-01T12:00:00Z"}},{"attributes":{"timestamp":"2023-06-01T11:00:00Z"}}],"meta":{"next_token":"b"}}`,
+		"b": `{"data":[],"meta":{"next_token":"b"}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/apps/my-app/logs", r.URL.Path)
+		page, ok := pages[r.URL.Query().Get("next_token")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+	api.SetBaseURL(server.URL)
+
+	last, err := lastLogTime(context.Background(), client.NewClient("test-token"), "my-app")
+	require.NoError(t, err)
+	require.NotNil(t, last)
+	assert.True(t, last.Equal(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)), last.String())
+}
+
+func TestShipLag(t *testing.T) {
+	received := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	logged := received.Add(90 * time.Second)
+
+	assert.Equal(t, "1m30s", shipLag(&logged, &received))
+	assert.Equal(t, "0s", shipLag(&received, &logged))
+	assert.Equal(t, "unknown", shipLag(nil, &received))
+}
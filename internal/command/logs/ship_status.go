@@ -0,0 +1,224 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/gql"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func newShipStatus() (cmd *cobra.Command) {
+	const (
+		short = "Show the health of the log shipper and how far behind it is"
+		long  = short + `
+
+Lag is the time between the most recent log of the app and the last one the
+log shipper received. Sent and Pending come from the sink itself: the logs it
+delivered since the log shipper started and the ones waiting in its buffer,
+which grow when the sink can't keep up. They are only known for the sinks
+shipped with Vector, the Logtail log shipper doesn't report them.
+`
+	)
+
+	cmd = command.New("status", short, long, runShipStatus, command.RequireSession, command.RequireAppName)
+	cmd.Args = cobra.NoArgs
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.JSONOutput(),
+	)
+	return cmd
+}
+
+// ShipStatus is the status of a log shipper machine shipping the logs of an app.
+type ShipStatus struct {
+	App            string     `json:"app"`
+	ShipperApp     string     `json:"shipper_app"`
+	MachineID      string     `json:"machine_id"`
+	Region         string     `json:"region"`
+	State          string     `json:"state"`
+	Checks         string     `json:"checks"`
+	Sink           string     `json:"sink"`
+	LastLoggedAt   *time.Time `json:"last_logged_at"`
+	LastReceivedAt *time.Time `json:"last_received_at"`
+	Lag            string     `json:"lag"`
+	SentEvents     *int64     `json:"sent_events"`
+	PendingEvents  *int64     `json:"pending_events"`
+	Error          string     `json:"error,omitempty"`
+}
+
+func runShipStatus(ctx context.Context) error {
+	var (
+		io        = iostreams.FromContext(ctx)
+		apiClient = client.FromContext(ctx).API()
+		appName   = appconfig.NameFromContext(ctx)
+	)
+
+	appResponse, err := gql.GetApp(ctx, apiClient.GenqClient, appName)
+	if err != nil {
+		return err
+	}
+
+	shipperApp, err := findShipperApp(ctx, appResponse.App.AppData.Organization)
+	if err != nil {
+		return err
+	}
+	if shipperApp == nil {
+		return fmt.Errorf("no log shipper found in the organization of %s, set one up with 'fly logs ship'", appName)
+	}
+
+	flapsClient, err := flaps.New(ctx, gql.AppForFlaps(*shipperApp))
+	if err != nil {
+		return err
+	}
+	machines, err := flapsClient.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	shippers := findSinkMachines(machines, appName, "")
+	if _, err := gql.GetAddOn(ctx, apiClient.GenqClient, appName+"-log-shipper"); err == nil {
+		for _, m := range machines {
+			if !isSinkMachine(m) {
+				shippers = append(shippers, m)
+				break
+			}
+		}
+	}
+	if len(shippers) == 0 {
+		return fmt.Errorf("logs of %s aren't shipped, set it up with 'fly logs ship'", appName)
+	}
+
+	lastLoggedAt, _ := lastLogTime(ctx, apiClient, appName)
+
+	statuses := make([]*ShipStatus, 0, len(shippers))
+	for _, machine := range shippers {
+		status := &ShipStatus{
+			App:          appName,
+			ShipperApp:   shipperApp.Name,
+			MachineID:    machine.ID,
+			Region:       machine.Region,
+			State:        machine.State,
+			Checks:       summarizeChecks(machine),
+			Sink:         defaultLogSink,
+			LastLoggedAt: lastLoggedAt,
+		}
+		// the Logtail log shipper doesn't report what it shipped
+		if isSinkMachine(machine) {
+			status.Sink = machine.Config.Metadata[metadataShipSink]
+			if machine.State == api.MachineStateStarted {
+				metrics, err := fetchShipperMetrics(ctx, flapsClient, machine.ID, status.Sink)
+				if err != nil {
+					status.Error = err.Error()
+				} else {
+					status.LastReceivedAt = metrics.lastReceived
+					status.SentEvents, status.PendingEvents = &metrics.sent, &metrics.pending
+				}
+			}
+		}
+		status.Lag = shipLag(status.LastLoggedAt, status.LastReceivedAt)
+		statuses = append(statuses, status)
+	}
+
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(io.Out, statuses)
+	}
+
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+	formatCount := func(n *int64) string {
+		if n == nil {
+			return "-"
+		}
+		return strconv.FormatInt(*n, 10)
+	}
+	rows := make([][]string, 0, len(statuses))
+	for _, status := range statuses {
+		rows = append(rows, []string{
+			status.Sink,
+			status.MachineID,
+			status.Region,
+			status.State,
+			status.Checks,
+			formatTime(status.LastLoggedAt),
+			formatTime(status.LastReceivedAt),
+			status.Lag,
+			formatCount(status.SentEvents),
+			formatCount(status.PendingEvents),
+			status.Error,
+		})
+	}
+	title := fmt.Sprintf("Log shipping for %s by %s", appName, shipperApp.Name)
+	return render.Table(io.Out, title, rows,
+		"Sink", "Machine", "Region", "State", "Checks", "Last Logged", "Last Received", "Lag", "Sent", "Pending", "Error")
+}
+
+// maxLastLogPages bounds how many pages of logs lastLogTime reads to reach the newest one
+const maxLastLogPages = 1000
+
+// lastLogTime returns the time of the most recent log of appName, or nil if it has none or
+// it's out of reach. The API returns the oldest logs it keeps first, so the pages are
+// followed to the newest one.
+func lastLogTime(ctx context.Context, apiClient *api.Client, appName string) (*time.Time, error) {
+	var (
+		last  *time.Time
+		token string
+	)
+	for page := 0; page < maxLastLogPages; page++ {
+		entries, nextToken, err := apiClient.GetAppLogs(ctx, appName, token, "", "")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+			if err == nil && (last == nil || t.After(*last)) {
+				last = &t
+			}
+		}
+		if len(entries) == 0 || nextToken == "" || nextToken == token {
+			return last, nil
+		}
+		token = nextToken
+	}
+	// an older log would make the lag look larger than it is
+	return nil, nil
+}
+
+// shipLag returns how far behind the logs the shipper received are, or "unknown" without both times.
+func shipLag(lastLogged, lastReceived *time.Time) string {
+	if lastLogged == nil || lastReceived == nil {
+		return "unknown"
+	}
+	lag := lastLogged.Sub(*lastReceived)
+	if lag < 0 {
+		lag = 0
+	}
+	return lag.Round(time.Second).String()
+}
+
+// summarizeChecks returns how many checks of machine pass, like "2/3 passing".
+func summarizeChecks(machine *api.Machine) string {
+	status := machine.HealthCheckStatus()
+	if status.Total == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d/%d passing", status.Passing, status.Total)
+}
@@ -0,0 +1,300 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/gql"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// Logs shipped to a sink other than Logtail go through a machine of the log shipper app running
+// the upstream Vector image with a config generated here. Vector subscribes to the logs of the
+// app on the NATS log stream of the organization, like `fly logs` does, and reads the sink
+// settings from the secrets of the log shipper app.
+const (
+	vectorImage      = "timberio/vector:0.34.1-alpine"
+	vectorConfigEnv  = "FLY_LOG_SHIP_CONFIG"
+	vectorConfigPath = "/etc/vector/fly-log-ship.toml"
+	vectorAPIPort    = 8686
+	vectorStatusPort = 9598
+
+	// lastReceivedMetric is the timestamp of the last log Vector received from NATS, exported on
+	// vectorStatusPort with the internal metrics of Vector, which tell what the sink delivered
+	lastReceivedMetric = "fly_log_ship_last_received_timestamp_seconds"
+	// sentEventsMetric and bufferEventsMetric are the events a component of Vector sent and the
+	// ones waiting in its buffer
+	sentEventsMetric   = "vector_component_sent_events_total"
+	bufferEventsMetric = "vector_buffer_events"
+
+	metadataShipApp  = "fly_log_ship_app"
+	metadataShipSink = "fly_log_ship_sink"
+)
+
+// accessTokenSetting is the token Vector reads the logs of the app with.
+var accessTokenSetting = sinkSetting{name: "access_token"}
+
+// vectorConfig returns the Vector config shipping the logs of appName to sink. Settings are
+// referenced by the name of their secret, the ones without a secret are left out.
+func vectorConfig(orgSlug, appName string, sink *logSink, secrets map[string]string) string {
+	setting := func(name string) string {
+		secret := sinkSetting{name: name}.secretName(appName, sink.name)
+		if _, ok := secrets[secret]; !ok {
+			return ""
+		}
+		return "${" + secret + "}"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `[api]
+enabled = true
+address = "0.0.0.0:%d"
+
+[sources.fly_logs]
+type = "nats"
+url = "nats://[fdaa::3]:4223"
+subject = "logs.%s.>"
+connection_name = "fly logs ship %s"
+decoding.codec = "json"
+auth.strategy = "user_password"
+auth.user_password.user = %q
+auth.user_password.password = %q
+
+[sources.vector_metrics]
+type = "internal_metrics"
+
+[transforms.received_at]
+type = "remap"
+inputs = ["fly_logs"]
+source = '''
+. = { "received_at": to_unix_timestamp(parse_timestamp!(.timestamp, "%%+")) }
+'''
+
+[transforms.last_received]
+type = "log_to_metric"
+inputs = ["received_at"]
+
+[[transforms.last_received.metrics]]
+type = "gauge"
+field = "received_at"
+name = %q
+
+[sinks.status]
+type = "prometheus_exporter"
+inputs = ["last_received", "vector_metrics"]
+address = "0.0.0.0:%d"
+
+[sinks.%s]
+inputs = ["fly_logs"]
+`, vectorAPIPort, appName, appName, orgSlug, setting(accessTokenSetting.name), lastReceivedMetric, vectorStatusPort, sink.name)
+
+	basicAuth := func(user, password string) {
+		if user != "" || password != "" {
+			fmt.Fprintf(&b, "auth.strategy = \"basic\"\nauth.user = %q\nauth.password = %q\n", user, password)
+		}
+	}
+	switch sink.name {
+	case "loki":
+		fmt.Fprintf(&b, "type = \"loki\"\nendpoint = %q\nencoding.codec = \"json\"\n", setting("url"))
+		b.WriteString("labels.app = \"{{ fly.app.name }}\"\nlabels.region = \"{{ fly.region }}\"\nlabels.instance = \"{{ fly.app.instance }}\"\n")
+		basicAuth(setting("username"), setting("password"))
+	case "elasticsearch":
+		fmt.Fprintf(&b, "type = \"elasticsearch\"\nendpoints = [%q]\n", setting("endpoint"))
+		if index := setting("index"); index != "" {
+			fmt.Fprintf(&b, "bulk.index = %q\n", index)
+		}
+		basicAuth(setting("username"), setting("password"))
+	case "http":
+		fmt.Fprintf(&b, "type = \"http\"\nuri = %q\nmethod = \"post\"\nencoding.codec = \"json\"\nframing.method = \"newline_delimited\"\n", setting("url"))
+		if token := setting("token"); token != "" {
+			fmt.Fprintf(&b, "auth.strategy = \"bearer\"\nauth.token = %q\n", token)
+		}
+	}
+	return b.String()
+}
+
+// vectorMachineConfig returns the config of the machine shipping the logs of appName to sink.
+// The Vector config is passed in the environment and written to a file on boot.
+func vectorMachineConfig(appName string, sink *logSink, vectorConfig string) *api.MachineConfig {
+	return &api.MachineConfig{
+		Guest: &api.MachineGuest{
+			CPUKind:  "shared",
+			CPUs:     1,
+			MemoryMB: 256,
+		},
+		Image: vectorImage,
+		Env:   map[string]string{vectorConfigEnv: vectorConfig},
+		Init: api.MachineInit{
+			Entrypoint: []string{"/bin/sh", "-c", fmt.Sprintf(`printf '%%s' "$%s" > %s && exec vector --config %s`, vectorConfigEnv, vectorConfigPath, vectorConfigPath)},
+		},
+		Metadata: map[string]string{
+			metadataShipApp:  appName,
+			metadataShipSink: sink.name,
+		},
+		Checks: map[string]api.MachineCheck{
+			"vector": {
+				Type:     api.Pointer("http"),
+				Port:     api.Pointer(vectorAPIPort),
+				HTTPPath: api.Pointer("/health"),
+				Interval: &api.Duration{Duration: 15 * time.Second},
+				Timeout:  &api.Duration{Duration: 5 * time.Second},
+			},
+		},
+	}
+}
+
+// isSinkMachine tells whether m ships logs with Vector rather than being the Logtail log shipper.
+func isSinkMachine(m *api.Machine) bool {
+	return m.Config != nil && m.Config.Metadata[metadataShipApp] != ""
+}
+
+// findSinkMachines returns the machines shipping the logs of appName, to sink or to any sink when empty.
+func findSinkMachines(machines []*api.Machine, appName, sink string) []*api.Machine {
+	var found []*api.Machine
+	for _, m := range machines {
+		if !isSinkMachine(m) || m.Config.Metadata[metadataShipApp] != appName {
+			continue
+		}
+		if sink == "" || m.Config.Metadata[metadataShipSink] == sink {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// shipToSink stores the settings of sink as secrets of the log shipper app, and launches or
+// updates the machine shipping the logs of targetApp to it.
+func shipToSink(ctx context.Context, targetApp gql.AppData, sink *logSink, secrets map[string]string) error {
+	var (
+		io        = iostreams.FromContext(ctx)
+		apiClient = client.FromContext(ctx).API()
+	)
+
+	token, err := createLogsToken(ctx, targetApp)
+	if err != nil {
+		return err
+	}
+	secrets[accessTokenSetting.secretName(targetApp.Name, sink.name)] = token
+
+	shipperApp, err := ensureShipperApp(ctx, targetApp.Organization)
+	if err != nil {
+		return err
+	}
+	if _, err := apiClient.SetSecrets(ctx, shipperApp.Name, secrets); err != nil {
+		return fmt.Errorf("failed storing sink settings as secrets of %s: %w", shipperApp.Name, err)
+	}
+	fmt.Fprintf(io.Out, "Stored %d sink setting(s) as secrets of the log shipper app %s\n", len(secrets), shipperApp.Name)
+
+	flapsClient, err := flaps.New(ctx, gql.AppForFlaps(*shipperApp))
+	if err != nil {
+		return err
+	}
+	machines, err := flapsClient.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	machineConf := vectorMachineConfig(targetApp.Name, sink, vectorConfig(targetApp.Organization.Slug, targetApp.Name, sink, secrets))
+	if existing := findSinkMachines(machines, targetApp.Name, sink.name); len(existing) > 0 {
+		machine, err := flapsClient.Update(ctx, api.LaunchMachineInput{
+			ID:     existing[0].ID,
+			Region: existing[0].Region,
+			Config: machineConf,
+		}, "")
+		if err != nil {
+			return fmt.Errorf("failed updating log shipper VM %s: %w", existing[0].ID, err)
+		}
+		fmt.Fprintf(io.Out, "Updated log shipper VM %s shipping %s logs to %s\n", machine.ID, targetApp.Name, sink.name)
+		return nil
+	}
+
+	regionResponse, err := gql.GetNearestRegion(ctx, apiClient.GenqClient)
+	if err != nil {
+		return err
+	}
+	machine, err := flapsClient.Launch(ctx, api.LaunchMachineInput{
+		AppID:  shipperApp.Name,
+		Name:   targetApp.Name + "-" + sink.name,
+		Region: regionResponse.NearestRegion.Code,
+		Config: machineConf,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(io.Out, "Launched log shipper VM %s in the %s region shipping %s logs to %s\n", machine.ID, machine.Region, targetApp.Name, sink.name)
+	return nil
+}
+
+// shipperMetrics is what a Vector machine received and what its sink delivered.
+type shipperMetrics struct {
+	// lastReceived is the timestamp of the last log received, nil if none was
+	lastReceived *time.Time
+	// sent is how many logs the sink delivered and pending how many wait in its buffer
+	sent, pending int64
+}
+
+// fetchShipperMetrics returns the metrics of the Vector machine shipping logs to sink.
+func fetchShipperMetrics(ctx context.Context, flapsClient *flaps.Client, machineID, sink string) (*shipperMetrics, error) {
+	response, err := flapsClient.Exec(ctx, machineID, &api.MachineExecRequest{
+		Cmd:     fmt.Sprintf("wget -q -O - http://127.0.0.1:%d/metrics", vectorStatusPort),
+		Timeout: 10,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the metrics of the log shipper: %w", err)
+	}
+	if response.ExitCode != 0 {
+		return nil, fmt.Errorf("failed getting the metrics of the log shipper: %s", strings.TrimSpace(response.StdErr))
+	}
+	return parseShipperMetrics(response.StdOut, sink)
+}
+
+// parseShipperMetrics reads lastReceivedMetric, and the sent and buffered events of the sink
+// component, from Prometheus metrics.
+func parseShipperMetrics(metrics, sink string) (*shipperMetrics, error) {
+	var (
+		m         shipperMetrics
+		component = fmt.Sprintf("component_id=%q", sink)
+	)
+	scanner := bufio.NewScanner(strings.NewReader(metrics))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		name, labels, _ := strings.Cut(fields[0], "{")
+		switch {
+		case name == lastReceivedMetric:
+		case name == sentEventsMetric || name == bufferEventsMetric:
+			if !strings.Contains(labels, component) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing %s: %w", name, err)
+		}
+		switch name {
+		case lastReceivedMetric:
+			t := time.Unix(int64(value), 0)
+			m.lastReceived = &t
+		case sentEventsMetric:
+			m.sent += int64(value)
+		case bufferEventsMetric:
+			m.pending += int64(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
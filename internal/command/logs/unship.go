@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/gql"
 	"github.com/superfly/flyctl/iostreams"
 
@@ -19,7 +20,7 @@ import (
 
 func newUnship() (cmd *cobra.Command) {
 	const (
-		short = "Stop shipping application logs to Logtail"
+		short = "Stop shipping application logs"
 		long  = short + "\n"
 	)
 
@@ -27,6 +28,11 @@ func newUnship() (cmd *cobra.Command) {
 	flag.Add(cmd,
 		flag.App(),
 		flag.AppConfig(),
+		flag.String{
+			Name:        "sink",
+			Description: "The sink logs are shipped to: logtail, loki, elasticsearch or http",
+			Default:     defaultLogSink,
+		},
 	)
 	return cmd
 }
@@ -40,6 +46,12 @@ func runUnship(ctx context.Context) (err error) {
 	)
 
 	appName := appconfig.NameFromContext(ctx)
+
+	sink, err := findLogSink(flag.GetString(ctx, "sink"))
+	if err != nil {
+		return err
+	}
+
 	appNameResponse, err := gql.GetApp(ctx, client, appName)
 
 	if err != nil {
@@ -49,6 +61,14 @@ func runUnship(ctx context.Context) (err error) {
 	targetApp := appNameResponse.App.AppData
 	targetOrg := targetApp.Organization
 
+	if sink.name != defaultLogSink {
+		if err := unshipFromSink(ctx, targetApp, sink); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Logs for %s are no longer being shipped, but older logs are still preserved in %s.\n", appName, sink.name)
+		return nil
+	}
+
 	_, err = gql.DeleteAddOn(ctx, client, appName+"-log-shipper")
 
 	if err != nil {
@@ -76,3 +96,43 @@ func runUnship(ctx context.Context) (err error) {
 	fmt.Fprintf(out, "Logs for %s are no longer being shipped, but older logs are still preserved in Logtail.\n", appName)
 	return
 }
+
+// unshipFromSink destroys the machine shipping the logs of targetApp to sink and removes its settings.
+func unshipFromSink(ctx context.Context, targetApp gql.AppData, sink *logSink) error {
+	var (
+		apiClient = client.FromContext(ctx).API()
+		io        = iostreams.FromContext(ctx)
+	)
+
+	shipperApp, err := findShipperApp(ctx, targetApp.Organization)
+	if err != nil {
+		return err
+	}
+	if shipperApp == nil {
+		return fmt.Errorf("no log shipper found in the organization of %s", targetApp.Name)
+	}
+
+	flapsClient, err := flaps.New(ctx, gql.AppForFlaps(*shipperApp))
+	if err != nil {
+		return err
+	}
+	machines, err := flapsClient.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	sinkMachines := findSinkMachines(machines, targetApp.Name, sink.name)
+	if len(sinkMachines) == 0 {
+		return fmt.Errorf("logs of %s aren't shipped to %s", targetApp.Name, sink.name)
+	}
+	for _, m := range sinkMachines {
+		if err := flapsClient.Destroy(ctx, api.RemoveMachineInput{AppID: shipperApp.Name, ID: m.ID, Kill: true}, ""); err != nil {
+			return fmt.Errorf("failed destroying log shipper VM %s: %w", m.ID, err)
+		}
+	}
+
+	// the settings were only needed by the machine we just destroyed
+	if _, err := apiClient.UnsetSecrets(ctx, shipperApp.Name, sinkSecretNames(sink, targetApp.Name)); err != nil {
+		fmt.Fprintf(io.ErrOut, "failed removing the %s settings from the secrets of %s: %v\n", sink.name, shipperApp.Name, err)
+	}
+	return nil
+}
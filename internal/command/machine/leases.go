@@ -1,17 +1,26 @@
 package machine
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/azazeal/pause"
+	"github.com/inancgumus/screen"
 	"github.com/spf13/cobra"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/prompt"
 	"github.com/superfly/flyctl/internal/render"
 	"github.com/superfly/flyctl/iostreams"
 )
@@ -32,6 +41,7 @@ func newLeases() *cobra.Command {
 	cmd.AddCommand(
 		newLeaseView(),
 		newLeaseClear(),
+		newLeaseAcquire(),
 	)
 
 	return cmd
@@ -40,8 +50,9 @@ func newLeases() *cobra.Command {
 func newLeaseView() *cobra.Command {
 	const (
 		short = "View machine leases"
-		long  = short + "\n"
-		usage = "view"
+		long  = short + "\n\n" +
+			"Without machine IDs or --select, the leases of every machine of the app are shown."
+		usage = "view [<id>...]"
 	)
 
 	cmd := command.New(usage, short, long, runLeaseView,
@@ -57,6 +68,15 @@ func newLeaseView() *cobra.Command {
 		flag.AppConfig(),
 		flag.JSONOutput(),
 		selectFlag,
+		flag.Bool{
+			Name:        "watch",
+			Description: "Refresh the leases until interrupted",
+		},
+		flag.Int{
+			Name:        "rate",
+			Description: "Refresh Rate for --watch",
+			Default:     2,
+		},
 	)
 
 	return cmd
@@ -65,8 +85,12 @@ func newLeaseView() *cobra.Command {
 func newLeaseClear() *cobra.Command {
 	const (
 		short = "Clear machine leases"
-		long  = short + "\n"
-		usage = "clear"
+		long  = short + "\n\n" +
+			"Without machine IDs or --select, the leases of every machine of the app are cleared.\n" +
+			"Leases held by a running deploy are refreshed every few seconds, so they always expire soon.\n" +
+			"Machines don't report when a lease was acquired, so leases are filtered on when they expire rather than on their age.\n" +
+			"Use --expires-after to only clear leases left behind with a long duration."
+		usage = "clear [<id>...]"
 	)
 
 	cmd := command.New(usage, short, long, runLeaseClear,
@@ -80,93 +104,301 @@ func newLeaseClear() *cobra.Command {
 		cmd,
 		flag.App(),
 		flag.AppConfig(),
+		flag.Yes(),
 		selectFlag,
+		flag.String{
+			Name:        "owner",
+			Description: "Only clear leases held by this owner",
+		},
+		flag.Duration{
+			Name:        "expires-after",
+			Description: "Only clear leases that expire more than this duration from now, like the ones acquired with a long TTL",
+		},
+		flag.Duration{
+			Name:        "expires-before",
+			Description: "Only clear leases that expire less than this duration from now, like the ones refreshed by a running command",
+		},
 	)
 
 	return cmd
 }
 
-func runLeaseView(ctx context.Context) (err error) {
-	var (
-		io   = iostreams.FromContext(ctx)
-		args = flag.Args(ctx)
-		cfg  = config.FromContext(ctx)
+func newLeaseAcquire() *cobra.Command {
+	const (
+		short = "Acquire and hold machine leases"
+		long  = short + "\n\n" +
+			"Leases are refreshed in the foreground until interrupted with Ctrl-C, and released then.\n" +
+			"This keeps deploys and other commands from changing the machines, for example during manual maintenance.\n" +
+			"Without machine IDs or --select, the leases of every machine of the app are acquired."
+		usage = "acquire [<id>...]"
+	)
+
+	cmd := command.New(usage, short, long, runLeaseAcquire,
+		command.RequireSession,
+		command.LoadAppNameIfPresent,
+	)
+
+	cmd.Args = cobra.ArbitraryArgs
+
+	flag.Add(
+		cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.WaitForLeases(),
+		selectFlag,
+		flag.Duration{
+			Name:        "ttl",
+			Description: "Duration of the leases, they are refreshed well before they expire",
+			Default:     30 * time.Second,
+		},
 	)
 
-	machines, ctx, err := selectManyMachines(ctx, args)
+	return cmd
+}
+
+// selectLeaseMachines selects the machines given as arguments or with --select, or every machine
+// of the app when there is neither. appWide is set in the last case.
+func selectLeaseMachines(ctx context.Context, args []string) (machines []*api.Machine, appWide bool, _ context.Context, err error) {
+	if len(args) > 0 || flag.GetBool(ctx, "select") || appconfig.NameFromContext(ctx) == "" {
+		machines, ctx, err = selectManyMachines(ctx, args)
+		return machines, false, ctx, err
+	}
+
+	ctx, err = buildContextFromAppNameOrMachineID(ctx)
 	if err != nil {
-		return err
+		return nil, false, nil, err
 	}
-	flapsClient := flaps.FromContext(ctx)
+	machines, err = flaps.FromContext(ctx).List(ctx, "")
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("could not get a list of machines: %w", err)
+	}
+	return machines, true, ctx, nil
+}
 
-	var leases = make(map[string]*api.MachineLease)
+// findLeases returns the leases of machineIDs, skipping the machines without one.
+func findLeases(ctx context.Context, machineIDs []string) (map[string]*api.MachineLease, error) {
+	flapsClient := flaps.FromContext(ctx)
+	leases := make(map[string]*api.MachineLease)
 
-	for _, machine := range machines {
-		lease, err := flapsClient.FindLease(ctx, machine.ID)
+	for _, machineID := range machineIDs {
+		lease, err := flapsClient.FindLease(ctx, machineID)
 		if err != nil {
 			if strings.Contains(err.Error(), " lease not found") {
 				continue
 			}
-			return err
+			return nil, err
 		}
-		if lease == nil {
+		if lease == nil || lease.Data == nil {
 			continue
 		}
 
-		leases[machine.ID] = lease
+		leases[machineID] = lease
+	}
+	return leases, nil
+}
+
+func machineIDsOf(machines []*api.Machine) []string {
+	ids := make([]string, 0, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func runLeaseView(ctx context.Context) (err error) {
+	var (
+		args  = flag.Args(ctx)
+		cfg   = config.FromContext(ctx)
+		watch = flag.GetBool(ctx, "watch")
+	)
+
+	if watch && cfg.JSONOutput {
+		return errors.New("--watch and --json are not supported together")
 	}
 
-	if cfg.JSONOutput {
-		return render.JSON(io.Out, leases)
+	machines, appWide, ctx, err := selectLeaseMachines(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if watch {
+		return watchLeases(ctx, machineIDsOf(machines), appWide)
+	}
+	return printLeases(ctx, iostreams.FromContext(ctx).Out, machineIDsOf(machines))
+}
+
+func printLeases(ctx context.Context, out io.Writer, machineIDs []string) error {
+	leases, err := findLeases(ctx, machineIDs)
+	if err != nil {
+		return err
+	}
+
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(out, leases)
 	}
 
 	if len(leases) == 0 {
-		fmt.Fprintln(io.Out, "No leases found")
+		fmt.Fprintln(out, "No leases found")
 		return nil
 	}
 
 	rows := [][]string{}
 
-	for machine, lease := range leases {
-		expires := time.Unix(lease.Data.ExpiresAt, 0).Format(time.RFC3339)
+	for _, machineID := range machineIDs {
+		lease, ok := leases[machineID]
+		if !ok {
+			continue
+		}
+		expires := time.Unix(lease.Data.ExpiresAt, 0)
 
 		rows = append(rows, []string{
-			machine,
+			machineID,
 			lease.Data.Nonce,
 			lease.Data.Owner,
 			lease.Status,
-			expires,
+			fmt.Sprintf("%s (in %s)", expires.Format(time.RFC3339), time.Until(expires).Round(time.Second)),
 		})
 	}
 
-	_ = render.Table(io.Out, "", rows, "Machine", "Nonce", "Status", "Owner", "Expires")
+	return render.Table(out, "", rows, "Machine", "Nonce", "Owner", "Status", "Expires")
+}
+
+// watchLeases prints the leases of machineIDs every --rate seconds until interrupted. When appWide
+// is set, the machines of the app are listed again on every refresh to catch new ones.
+func watchLeases(ctx context.Context, machineIDs []string, appWide bool) (err error) {
+	streams := iostreams.FromContext(ctx)
+	if !streams.IsInteractive() {
+		return errors.New("--watch is not supported for non-interactive sessions")
+	}
+	colorize := streams.ColorScheme()
+
+	sleep := flag.GetInt(ctx, "rate")
+	if sleep < 1 || sleep > 3600 {
+		return errors.New("--rate must be in the [1, 3600] range")
+	}
+
+	appName := appconfig.NameFromContext(ctx)
+
+	var buf bytes.Buffer
+
+	for err == nil {
+		buf.Reset()
+
+		if appWide {
+			var machines []*api.Machine
+			if machines, err = flaps.FromContext(ctx).List(ctx, ""); err != nil {
+				break
+			}
+			machineIDs = machineIDsOf(machines)
+			sort.Strings(machineIDs)
+		}
+
+		if err = printLeases(ctx, &buf, machineIDs); err != nil {
+			break
+		}
+
+		header := fmt.Sprintf("%s leases at: %s\n\n", colorize.Bold(appName), colorize.Bold(time.Now().UTC().Format("15:04:05")))
+
+		screen.Clear()
+		screen.MoveTopLeft()
+
+		io.Copy(streams.Out, io.MultiReader(
+			strings.NewReader(header),
+			&buf,
+		))
+
+		pause.For(ctx, time.Duration(sleep)*time.Second)
+	}
+
+	// Interrupted with Ctrl-C
+	if errors.Is(ctx.Err(), context.Canceled) {
+		err = nil
+	}
 
 	return
 }
 
+// leaseClearFilter selects the leases to clear by owner and by how long until they expire, since
+// leases don't report their age. Zero values don't filter anything.
+type leaseClearFilter struct {
+	owner         string
+	expiresAfter  time.Duration
+	expiresBefore time.Duration
+}
+
+func (f leaseClearFilter) matches(holder machine.LeaseHolder, now time.Time) bool {
+	switch {
+	case f.owner != "" && holder.Owner != f.owner:
+		return false
+	case f.expiresAfter > 0 && !holder.ExpiresAt.After(now.Add(f.expiresAfter)):
+		return false
+	case f.expiresBefore > 0 && !holder.ExpiresAt.Before(now.Add(f.expiresBefore)):
+		return false
+	default:
+		return true
+	}
+}
+
 func runLeaseClear(ctx context.Context) (err error) {
 	var (
 		io   = iostreams.FromContext(ctx)
 		args = flag.Args(ctx)
+
+		filter = leaseClearFilter{
+			owner:         flag.GetString(ctx, "owner"),
+			expiresAfter:  flag.GetDuration(ctx, "expires-after"),
+			expiresBefore: flag.GetDuration(ctx, "expires-before"),
+		}
 	)
 
-	machineIDs, ctx, err := selectManyMachineIDs(ctx, args)
+	machines, _, ctx, err := selectLeaseMachines(ctx, args)
 	if err != nil {
 		return err
 	}
 	flapsClient := flaps.FromContext(ctx)
 
+	machineIDs := machineIDsOf(machines)
+	leases, err := findLeases(ctx, machineIDs)
+	if err != nil {
+		return err
+	}
+
+	var (
+		now     = time.Now()
+		holders []machine.LeaseHolder
+	)
 	for _, machineID := range machineIDs {
-		lease, err := flapsClient.FindLease(ctx, machineID)
-		if err != nil {
-			if strings.Contains(err.Error(), " lease not found") {
-				continue
+		holder := machine.LeaseHolderFrom(machineID, leases[machineID])
+		if holder != nil && filter.matches(*holder, now) {
+			holders = append(holders, *holder)
+		}
+	}
+
+	if len(holders) == 0 {
+		fmt.Fprintln(io.Out, "No matching leases found")
+		return nil
+	}
+
+	if !flag.GetYes(ctx) {
+		if err := machine.PrintLeaseHolders(io.Out, "Leases to clear", holders); err != nil {
+			return err
+		}
+		confirmed, err := prompt.Confirmf(ctx, "Clear %d lease(s)? Commands holding them will fail to update their machines", len(holders))
+		switch {
+		case err != nil:
+			if prompt.IsNonInteractive(err) {
+				return prompt.NonInteractiveError("yes flag must be specified when not running interactively")
 			}
 			return err
+		case !confirmed:
+			return nil
 		}
-		fmt.Fprintf(io.Out, "clearing lease for machine %s\n", machineID)
+	}
 
-		if err := flapsClient.ReleaseLease(ctx, machineID, lease.Data.Nonce); err != nil {
+	for _, holder := range holders {
+		fmt.Fprintf(io.Out, "clearing lease for machine %s\n", holder.MachineID)
+
+		if err := flapsClient.ReleaseLease(ctx, holder.MachineID, holder.Nonce); err != nil {
 			return err
 		}
 	}
@@ -174,3 +406,46 @@ func runLeaseClear(ctx context.Context) (err error) {
 
 	return
 }
+
+func runLeaseAcquire(ctx context.Context) (err error) {
+	var (
+		io       = iostreams.FromContext(ctx)
+		colorize = io.ColorScheme()
+		args     = flag.Args(ctx)
+		ttl      = flag.GetDuration(ctx, "ttl")
+	)
+
+	if ttl < 5*time.Second {
+		return errors.New("--ttl must be at least 5s")
+	}
+
+	machines, _, ctx, err := selectLeaseMachines(ctx, args)
+	if err != nil {
+		return err
+	}
+	if len(machines) == 0 {
+		return errors.New("no machines to acquire leases on")
+	}
+	if flag.GetBool(ctx, "wait-for-leases") {
+		ctx = machine.WithWaitForLeases(ctx)
+	}
+
+	machineSet := machine.NewMachineSet(flaps.FromContext(ctx), io, machines)
+	if err := machineSet.AcquireLeases(ctx, ttl); err != nil {
+		return err
+	}
+	defer machineSet.ReleaseLeases(ctx) // skipcq: GO-S2307
+
+	// refresh at a third of the ttl, so a couple of failed refreshes don't lose the leases
+	machineSet.StartBackgroundLeaseRefresh(ctx, ttl, ttl/3)
+
+	for _, m := range machines {
+		fmt.Fprintf(io.Out, "Acquired lease on machine %s\n", colorize.Bold(m.ID))
+	}
+	fmt.Fprintf(io.Out, "Holding %d lease(s), press Ctrl-C to release them\n", len(machines))
+
+	<-ctx.Done()
+
+	fmt.Fprintln(io.Out, "Releasing lease(s)")
+	return nil
+}
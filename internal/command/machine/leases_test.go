@@ -0,0 +1,210 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/logger"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// fakeLeaseAPI serves the machines and leases of an app from memory.
+type fakeLeaseAPI struct {
+	mu       sync.Mutex
+	machines []string
+	leases   map[string]*api.MachineLeaseData
+	released []string
+	lists    int
+	// onList, when set, is called with the number of times the machines were listed so far
+	onList func(lists int)
+}
+
+func (f *fakeLeaseAPI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/apps/my-cool-app/machines")
+	id, action, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case id == "":
+		f.lists++
+		if f.onList != nil {
+			f.onList(f.lists)
+		}
+		machines := []*api.Machine{}
+		for _, id := range f.machines {
+			machines = append(machines, &api.Machine{ID: id})
+		}
+		json.NewEncoder(w).Encode(machines)
+	case action == "":
+		json.NewEncoder(w).Encode(&api.Machine{ID: id})
+	case r.Method == http.MethodGet:
+		lease, ok := f.leases[id]
+		if !ok {
+			http.Error(w, `{"error":"lease not found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(&api.MachineLease{Status: "success", Data: lease})
+	case r.Method == http.MethodPost:
+		if _, ok := f.leases[id]; ok && r.Header.Get("fly-machine-lease-nonce") == "" {
+			http.Error(w, `{"error":"lease currently held"}`, http.StatusConflict)
+			return
+		}
+		f.leases[id] = &api.MachineLeaseData{Nonce: "nonce-" + id, Owner: "me@example.com", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+		json.NewEncoder(w).Encode(&api.MachineLease{Status: "success", Data: f.leases[id]})
+	case r.Method == http.MethodDelete:
+		delete(f.leases, id)
+		f.released = append(f.released, id)
+	}
+}
+
+func (f *fakeLeaseAPI) releasedIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := append([]string(nil), f.released...)
+	sort.Strings(ids)
+	return ids
+}
+
+// newLeaseCommandContext parses args with the flags of cmd and returns a context to run it against f.
+func newLeaseCommandContext(t *testing.T, f *fakeLeaseAPI, cmd *cobra.Command, args ...string) (context.Context, *iostreams.IOStreams, *bytes.Buffer) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+
+	require.NoError(t, cmd.Flags().Parse(args))
+	ios, _, out, _ := iostreams.Test()
+
+	ctx := logger.NewContext(context.Background(), logger.FromEnv(io.Discard))
+	ctx = iostreams.NewContext(ctx, ios)
+	ctx = config.NewContext(ctx, config.New())
+	ctx = flag.NewContext(ctx, cmd.Flags())
+	ctx = appconfig.WithName(ctx, "my-cool-app")
+	return ctx, ios, out
+}
+
+func TestLeaseClearFilter(t *testing.T) {
+	now := time.Now()
+	holder := func(owner string, expiresIn time.Duration) machine.LeaseHolder {
+		return machine.LeaseHolder{MachineID: "m1", Owner: owner, Nonce: "abc", ExpiresAt: now.Add(expiresIn)}
+	}
+
+	cases := []struct {
+		filter leaseClearFilter
+		holder machine.LeaseHolder
+		want   bool
+	}{
+		{leaseClearFilter{}, holder("jane@example.com", time.Minute), true},
+		{leaseClearFilter{owner: "jane@example.com"}, holder("jane@example.com", time.Minute), true},
+		{leaseClearFilter{owner: "jane@example.com"}, holder("bob@example.com", time.Minute), false},
+		{leaseClearFilter{expiresAfter: time.Hour}, holder("", 2*time.Hour), true},
+		{leaseClearFilter{expiresAfter: time.Hour}, holder("", 30*time.Second), false},
+		{leaseClearFilter{expiresBefore: time.Minute}, holder("", 30*time.Second), true},
+		{leaseClearFilter{expiresBefore: time.Minute}, holder("", 2*time.Hour), false},
+		{leaseClearFilter{owner: "jane@example.com", expiresAfter: time.Hour}, holder("jane@example.com", 30*time.Second), false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.filter.matches(c.holder, now), "%+v on lease of %s expiring in %s", c.filter, c.holder.Owner, c.holder.ExpiresAt.Sub(now))
+	}
+}
+
+func TestRunLeaseClear(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	f := &fakeLeaseAPI{
+		machines: []string{"m1", "m2", "m3"},
+		leases: map[string]*api.MachineLeaseData{
+			"m1": {Nonce: "n1", Owner: "jane@example.com", ExpiresAt: expires},
+			"m2": {Nonce: "n2", Owner: "bob@example.com", ExpiresAt: expires},
+		},
+	}
+
+	// Clearing needs a confirmation that can't be asked for when not interactive
+	ctx, _, _ := newLeaseCommandContext(t, f, newLeaseClear())
+	assert.ErrorContains(t, runLeaseClear(ctx), "yes flag must be specified")
+	assert.Empty(t, f.releasedIDs())
+
+	ctx, _, out := newLeaseCommandContext(t, f, newLeaseClear(), "--yes", "--owner", "jane@example.com")
+	require.NoError(t, runLeaseClear(ctx))
+	assert.Equal(t, []string{"m1"}, f.releasedIDs())
+	assert.Contains(t, out.String(), "clearing lease for machine m1")
+
+	ctx, _, out = newLeaseCommandContext(t, f, newLeaseClear(), "--yes", "--expires-before", "1m")
+	require.NoError(t, runLeaseClear(ctx))
+	assert.Equal(t, []string{"m1"}, f.releasedIDs())
+	assert.Contains(t, out.String(), "No matching leases found")
+}
+
+func TestRunLeaseAcquire(t *testing.T) {
+	f := &fakeLeaseAPI{machines: []string{"m1", "m2"}, leases: map[string]*api.MachineLeaseData{}}
+	ctx, _, _ := newLeaseCommandContext(t, f, newLeaseAcquire(), "m1", "m2")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- runLeaseAcquire(ctx) }()
+
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.leases) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The leases are held until interrupted, and released then
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"m1", "m2"}, f.releasedIDs())
+}
+
+func TestRunLeaseViewWatch(t *testing.T) {
+	f := &fakeLeaseAPI{
+		machines: []string{"m1"},
+		leases: map[string]*api.MachineLeaseData{
+			"m1": {Nonce: "n1", Owner: "jane@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		},
+	}
+
+	ctx, _, _ := newLeaseCommandContext(t, f, newLeaseView(), "--watch")
+	assert.ErrorContains(t, runLeaseView(ctx), "non-interactive")
+
+	ctx, ios, out := newLeaseCommandContext(t, f, newLeaseView(), "--watch", "--rate", "1")
+	ios.SetStdinTTY(true)
+	ios.SetStdoutTTY(true)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Machines of the app are listed again on every refresh, so new ones show up
+	f.lists = 0
+	f.onList = func(lists int) {
+		switch lists {
+		case 3:
+			f.machines = append(f.machines, "m2")
+			f.leases["m2"] = &api.MachineLeaseData{Nonce: "n2", Owner: "bob@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+		case 4:
+			cancel()
+		}
+	}
+	require.NoError(t, runLeaseView(ctx))
+	assert.Contains(t, out.String(), "my-cool-app leases at:")
+	assert.Contains(t, out.String(), "jane@example.com")
+	assert.Contains(t, out.String(), "bob@example.com")
+}
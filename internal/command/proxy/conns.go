@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/proxy"
+)
+
+// connEvent is printed with --json when a connection opens or closes.
+type connEvent struct {
+	Event      proxy.ConnEvent `json:"event"`
+	Connection proxy.ConnStats `json:"connection"`
+}
+
+// newConnTable returns the table tracking the proxied connections, and a func that starts
+// printing it when Enter is pressed. With --json, connections are also printed when they
+// open and close.
+func newConnTable(ctx context.Context) (*proxy.ConnTable, func()) {
+	var (
+		io       = iostreams.FromContext(ctx)
+		jsonMode = config.FromContext(ctx).JSONOutput
+		mu       sync.Mutex
+		conns    = &proxy.ConnTable{}
+	)
+
+	if jsonMode {
+		enc := json.NewEncoder(io.Out)
+		conns.OnChange = func(event proxy.ConnEvent, conn proxy.ConnStats) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			_ = enc.Encode(connEvent{Event: event, Connection: conn})
+		}
+	}
+
+	showOnEnter := func() {
+		if !io.IsInteractive() {
+			return
+		}
+		if !jsonMode {
+			fmt.Fprintln(io.ErrOut, "Press Enter to show the open connections")
+		}
+
+		go func() {
+			scanner := bufio.NewScanner(io.In)
+			for scanner.Scan() {
+				mu.Lock()
+				_ = printConns(io.Out, conns.Conns(), jsonMode, time.Now())
+				mu.Unlock()
+			}
+		}()
+	}
+
+	return conns, showOnEnter
+}
+
+func printConns(w io.Writer, conns []proxy.ConnStats, jsonMode bool, now time.Time) error {
+	if jsonMode {
+		return render.JSON(w, conns)
+	}
+
+	rows := make([][]string, 0, len(conns))
+	for _, conn := range conns {
+		rows = append(rows, []string{
+			strconv.Itoa(conn.ID),
+			conn.Network,
+			conn.Local,
+			conn.Source,
+			conn.Instance,
+			now.Sub(conn.OpenedAt).Round(time.Second).String(),
+			humanize.Bytes(uint64(conn.BytesSent)),
+			humanize.Bytes(uint64(conn.BytesReceived)),
+		})
+	}
+
+	title := fmt.Sprintf("%d open connection(s)", len(conns))
+	return render.Table(w, title, rows, "ID", "Proto", "Local", "Source", "Instance", "Age", "Sent", "Received")
+}
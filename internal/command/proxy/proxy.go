@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -13,19 +15,34 @@ import (
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/flag"
 	"github.com/superfly/flyctl/internal/prompt"
+	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/proxy"
 )
 
 func New() *cobra.Command {
 	var (
-		long  = strings.Trim(`Proxies connections to a fly VM through a Wireguard tunnel The current application DNS is the default remote host`, "\n")
+		long = strings.Trim(`Proxies connections to a fly VM through a Wireguard tunnel The current application DNS is the default remote host
+
+Several ports can be forwarded at once, like 'fly proxy 5432 6379:6379 8080:80'.
+UDP ports are forwarded with a /udp suffix, like 53:53/udp.
+
+Press Enter to show the open connections, with the bytes sent each way and the
+instance they're connected to. With --json, connections are printed as JSON
+lines when they open and close.
+
+With --balance, connections are spread across all the instances of the app,
+resolved through the WireGuard agent, with round-robin, least-conn or random.
+Instances that fail to dial are left out for a while, and --sticky keeps
+sending the connections of a client address to the same instance. Local
+clients all share the loopback address, so --sticky needs ports bound to
+another address with --bind-addr, like 0.0.0.0 to proxy for other hosts.`, "\n")
 		short = `Proxies connections to a fly VM`
 	)
 
-	cmd := command.New("proxy <local:remote> [remote_host]", short, long, run,
+	cmd := command.New("proxy <local:remote>... [remote_host]", short, long, run,
 		command.RequireSession, command.LoadAppNameIfPresent)
 
-	cmd.Args = cobra.RangeArgs(1, 2)
+	cmd.Args = cobra.MinimumNArgs(1)
 
 	flag.Add(cmd,
 		flag.App(),
@@ -42,6 +59,28 @@ func New() *cobra.Command {
 			Shorthand:   "q",
 			Description: "Don't print progress indicators for WireGuard",
 		},
+		flag.JSONOutput(),
+		flag.String{
+			Name:        "balance",
+			Description: "Spread connections across the instances of the app: " + strings.Join(proxy.BalanceStrategies, ", "),
+		},
+		flag.Duration{
+			Name:        "idle-timeout",
+			Description: "Close connections without any traffic for this long, like 10m",
+		},
+		flag.Int{
+			Name:        "max-conns",
+			Description: "Maximum number of concurrent connections per port, further ones wait to be accepted",
+		},
+		flag.Bool{
+			Name:        "sticky",
+			Description: "With --balance, send the connections of a client address to the same instance",
+		},
+		flag.String{
+			Name:        "bind-addr",
+			Description: "Local address the ports are forwarded from",
+			Default:     "127.0.0.1",
+		},
 	)
 
 	return cmd
@@ -54,10 +93,43 @@ func run(ctx context.Context) (err error) {
 	args := flag.Args(ctx)
 	promptInstance := flag.GetBool(ctx, "select")
 
+	// the last argument is the remote host unless it's a port
+	var remoteHost string
+	if len(args) > 1 && !proxy.IsForward(args[len(args)-1]) {
+		remoteHost = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+
+	forwards, err := proxy.ParseForwards(args)
+	if err != nil {
+		return err
+	}
+
 	if promptInstance && appName == "" {
 		return errors.New("--app required when --select flag provided")
 	}
 
+	balance := flag.GetString(ctx, "balance")
+	switch {
+	case balance == "" && flag.GetBool(ctx, "sticky"):
+		return errors.New("--sticky can only be used with --balance")
+	case balance != "" && appName == "":
+		return errors.New("--app required when --balance flag provided")
+	case balance != "" && promptInstance:
+		return errors.New("--balance and --select are not supported together")
+	case flag.GetInt(ctx, "max-conns") < 0:
+		return errors.New("--max-conns must be positive")
+	case balance != "" && remoteHost != "":
+		return errors.New("--balance spreads connections across the instances of the app and doesn't support a remote host")
+	}
+
+	bindAddr := flag.GetString(ctx, "bind-addr")
+	if flag.GetBool(ctx, "sticky") {
+		if err := checkStickyListeners(bindAddr, forwards); err != nil {
+			return err
+		}
+	}
+
 	if orgSlug != "" {
 		_, err := client.GetOrganizationBySlug(ctx, orgSlug)
 		if err != nil {
@@ -98,21 +170,59 @@ func run(ctx context.Context) (err error) {
 		return err
 	}
 
-	ports := strings.Split(args[0], ":")
+	var balancer *proxy.Balancer
+	if balance != "" {
+		if balancer, err = proxy.NewBalancer(balance, proxy.InstanceResolver(agentclient, orgSlug, appName)); err != nil {
+			return err
+		}
+		balancer.Sticky = flag.GetBool(ctx, "sticky")
 
+		errOut := iostreams.FromContext(ctx).ErrOut
+		balancer.OnEject = func(instance string, err error) {
+			fmt.Fprintf(errOut, "Instance %s failed, leaving it out for a while: %v\n", instance, err)
+		}
+	}
+
+	conns, showConnsOnEnter := newConnTable(ctx)
 	params := &proxy.ConnectParams{
-		Ports:            ports,
+		Balancer:         balancer,
+		Forwards:         forwards,
 		AppName:          appName,
 		OrganizationSlug: orgSlug,
 		Dialer:           dialer,
 		PromptInstance:   promptInstance,
+		Conns:            conns,
+		IdleTimeout:      flag.GetDuration(ctx, "idle-timeout"),
+		MaxConns:         flag.GetInt(ctx, "max-conns"),
+		BindAddr:         bindAddr,
 	}
 
-	if len(args) > 1 {
-		params.RemoteHost = args[1]
+	if remoteHost != "" {
+		params.RemoteHost = remoteHost
 	} else {
 		params.RemoteHost = fmt.Sprintf("%s.internal", appName)
 	}
 
-	return proxy.Connect(ctx, params)
+	servers, err := proxy.NewServers(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	// Stdin is only read from now on, the instance prompt of --select needs it until here
+	showConnsOnEnter()
+	return proxy.Serve(ctx, servers)
+}
+
+// checkStickyListeners fails when a forward listens where every client has the same address,
+// so --sticky would send all connections to a single instance.
+func checkStickyListeners(bindAddr string, forwards []proxy.Forward) error {
+	if ip := net.ParseIP(bindAddr); ip == nil || ip.IsLoopback() {
+		return fmt.Errorf("--sticky tells clients apart by address, which is the same for every local client on %s; bind to another address with --bind-addr", bindAddr)
+	}
+	for _, f := range forwards {
+		if _, err := strconv.Atoi(f.Local); err != nil {
+			return fmt.Errorf("--sticky tells clients apart by address, which connections to the unix socket %s don't have", f.Local)
+		}
+	}
+	return nil
 }
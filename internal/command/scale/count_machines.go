@@ -54,6 +54,9 @@ func runMachinesScaleCount(ctx context.Context, appName string, expectedGroupCou
 		}
 	}
 
+	if flag.GetBool(ctx, "wait-for-leases") {
+		ctx = mach.WithWaitForLeases(ctx)
+	}
 	machines, releaseFunc, err := mach.AcquireLeases(ctx, machines)
 	defer releaseFunc(ctx, machines)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/flag"
 	mach "github.com/superfly/flyctl/internal/machine"
 )
 
@@ -42,6 +43,9 @@ func v2ScaleVM(ctx context.Context, appName, group, sizeName string, memoryMB in
 		return nil, fmt.Errorf("No active machines in process group '%s', check `fly status` output", group)
 	}
 
+	if flag.GetBool(ctx, "wait-for-leases") {
+		ctx = mach.WithWaitForLeases(ctx)
+	}
 	machines, releaseFunc, err := mach.AcquireLeases(ctx, machines)
 	defer releaseFunc(ctx, machines)
 	if err != nil {
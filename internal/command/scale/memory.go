@@ -22,6 +22,7 @@ func newScaleMemory() *cobra.Command {
 	flag.Add(cmd,
 		flag.App(),
 		flag.AppConfig(),
+		flag.WaitForLeases(),
 		flag.String{Name: "group", Description: "The process group to apply the VM size to"},
 	)
 	return cmd
@@ -35,6 +35,7 @@ For pricing, see https://fly.io/docs/about/pricing/`
 	flag.Add(cmd,
 		flag.App(),
 		flag.AppConfig(),
+		flag.WaitForLeases(),
 		flag.Int{Name: "memory", Description: "Memory in MB for the VM", Default: 0},
 		flag.String{Name: "group", Description: "The process group to apply the VM size to"},
 	)
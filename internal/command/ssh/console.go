@@ -102,8 +102,17 @@ func lookupAddress(ctx context.Context, cli *agent.Client, dialer agent.Dialer,
 
 func newConsole() *cobra.Command {
 	const (
-		long  = `Connect to a running instance of the current app.`
-		short = long
+		short = `Connect to a running instance of the current app.`
+		long  = short + `
+
+Ports can be forwarded through the connection like with OpenSSH: -L forwards a
+local port to a host and port reachable from the instance, -R forwards a port
+of the instance to a host and port reachable locally, and -D runs a local
+SOCKS5 proxy whose connections are opened from the instance. Use -N to only
+forward ports without running a shell.
+
+With --record, the session is recorded to an asciicast v2 file, which
+fly ssh replay and asciinema play back.`
 		usage = "console"
 	)
 
@@ -112,6 +121,36 @@ func newConsole() *cobra.Command {
 	cmd.Args = cobra.MaximumNArgs(1)
 
 	stdArgsSSH(cmd)
+	flag.Add(cmd,
+		flag.StringSlice{
+			Name:        "local-forward",
+			Shorthand:   "L",
+			Description: "Forward a local port to a host reachable from the instance, as [bind_address:]port:host:hostport. Can be specified multiple times.",
+		},
+		flag.StringSlice{
+			Name:        "remote-forward",
+			Shorthand:   "R",
+			Description: "Forward a port of the instance to a host reachable locally, as [bind_address:]port:host:hostport. Can be specified multiple times.",
+		},
+		flag.StringSlice{
+			Name:        "dynamic-forward",
+			Shorthand:   "D",
+			Description: "Run a local SOCKS5 proxy connecting from the instance, on [bind_address:]port. Can be specified multiple times.",
+		},
+		flag.Bool{
+			Name:        "no-shell",
+			Shorthand:   "N",
+			Description: "Don't run a shell or command, only forward ports",
+		},
+		flag.String{
+			Name:        "record",
+			Description: "Record the session to this asciicast v2 file, which must not exist",
+		},
+		flag.Bool{
+			Name:        "record-input",
+			Description: "Record what's typed too, passwords included, with --record",
+		},
+	)
 
 	return cmd
 }
@@ -172,6 +211,19 @@ func runConsole(ctx context.Context) error {
 		terminal.Debugf("Retrieving app info for %s\n", appName)
 	}
 
+	forwards, err := parseForwards(ctx)
+	if err != nil {
+		return err
+	}
+	noShell := flag.GetBool(ctx, "no-shell")
+	if noShell && forwards.empty() {
+		return errors.New("--no-shell requires a port to forward with -L, -R or -D")
+	}
+	record := flag.GetString(ctx, "record")
+	if record != "" && noShell {
+		return errors.New("--record and --no-shell are mutually exclusive")
+	}
+
 	app, err := client.GetAppCompact(ctx, appName)
 	if err != nil {
 		return fmt.Errorf("get app: %w", err)
@@ -220,6 +272,26 @@ func runConsole(ctx context.Context) error {
 		return err
 	}
 
+	stopForwards, err := forwards.start(ctx, sshc)
+	if err != nil {
+		return err
+	}
+	defer stopForwards()
+
+	if noShell {
+		// forward until interrupted or the connection drops
+		closed := make(chan error, 1)
+		go func() {
+			closed <- sshc.Client.Wait()
+		}()
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-closed:
+			return errors.Wrap(err, "ssh connection closed")
+		}
+	}
+
 	sessIO := &ssh.SessionIO{
 		Stdin:    params.Stdin,
 		Stdout:   params.Stdout,
@@ -228,6 +300,24 @@ func runConsole(ctx context.Context) error {
 		TermEnv:  determineTermEnv(),
 	}
 
+	if record != "" {
+		f, err := os.OpenFile(record, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("create recording: %w", err)
+		}
+		defer f.Close()
+
+		sessIO.Recorder = ssh.NewRecorder(f)
+		sessIO.Recorder.Title = fmt.Sprintf("%s@%s", params.Username, appName)
+		if params.Cmd != "" {
+			sessIO.Recorder.Title += " " + params.Cmd
+		}
+		sessIO.Recorder.Env = map[string]string{"TERM": sessIO.TermEnv}
+		sessIO.RecordInput = flag.GetBool(ctx, "record-input")
+
+		fmt.Fprintf(iostreams.FromContext(ctx).ErrOut, "Recording the session to %s\n", record)
+	}
+
 	currentStdin, currentStdout, currentStderr, err := setupConsole()
 	defer func() error {
 		if err := cleanupConsole(currentStdin, currentStdout, currentStderr); err != nil {
@@ -236,9 +326,18 @@ func runConsole(ctx context.Context) error {
 		return nil
 	}()
 
-	if err := sshc.Shell(params.Ctx, sessIO, params.Cmd); err != nil {
-		captureError(err, app)
-		return errors.Wrap(err, "ssh shell")
+	shellErr := sshc.Shell(params.Ctx, sessIO, params.Cmd)
+
+	// write what the shell printed last before the recording file is closed
+	if sessIO.Recorder != nil {
+		if err := sessIO.Recorder.Close(); err != nil && shellErr == nil {
+			return errors.Wrap(err, "record session")
+		}
+	}
+
+	if shellErr != nil {
+		captureError(shellErr, app)
+		return errors.Wrap(shellErr, "ssh shell")
 	}
 
 	return err
@@ -0,0 +1,382 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/ssh"
+)
+
+func newExec() *cobra.Command {
+	const (
+		short = "Run a command on several machines of an app at once"
+		long  = short + `
+
+Pick the machines with --all or --machine, and narrow them down with --region,
+--process-group and --metadata. Every line the command prints is prefixed with
+the ID of the machine it ran on, and a table of exit codes and durations is
+printed once it's done everywhere.
+
+A single argument is run as a shell command line, like "uptime -p". Several
+arguments are quoted each, like 'ls' 'my dir'.`
+		usage = "exec <command> [args...]"
+	)
+
+	cmd := command.New(usage, short, long, runExec, command.RequireSession, command.RequireAppName)
+
+	cmd.Args = cobra.MinimumNArgs(1)
+	// flags past the command are its own
+	cmd.Flags().SetInterspersed(false)
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.Bool{
+			Name:        "all",
+			Description: "Run the command on every started machine of the app",
+		},
+		flag.StringSlice{
+			Name:        "machine",
+			Description: "ID of a machine to run the command on. Can be specified multiple times.",
+		},
+		flag.String{
+			Name:        "region",
+			Description: "Comma separated list of regions to only run the command on machines of",
+		},
+		flag.StringSlice{
+			Name:        "process-group",
+			Description: "Only run the command on machines of this process group. Can be specified multiple times.",
+		},
+		flag.StringSlice{
+			Name:        "metadata",
+			Description: "Only run the command on machines with this metadata, as key=value. Can be specified multiple times.",
+		},
+		flag.Int{
+			Name:        "parallel",
+			Description: "Number of machines to run the command on at once",
+			Default:     8,
+		},
+		flag.String{
+			Name:        "user",
+			Shorthand:   "u",
+			Description: "Unix username to connect as",
+			Default:     DefaultSshUsername,
+		},
+		flag.Bool{
+			Name:        "quiet",
+			Shorthand:   "q",
+			Description: "Don't print progress indicators for WireGuard",
+		},
+	)
+
+	return cmd
+}
+
+// machineFilter picks the machines to run a command on.
+type machineFilter struct {
+	all      bool
+	ids      []string
+	regions  []string
+	groups   []string
+	metadata map[string]string
+}
+
+// splitRegions splits a comma separated list of regions.
+func splitRegions(v string) (regions []string) {
+	for _, region := range strings.Split(v, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// execCommandLine returns the command line running args. A single argument is a command
+// line already, several ones are quoted so that they reach the command as they are.
+func execCommandLine(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func newMachineFilter(ctx context.Context) (*machineFilter, error) {
+	f := &machineFilter{
+		all:      flag.GetBool(ctx, "all"),
+		ids:      flag.GetStringSlice(ctx, "machine"),
+		regions:  splitRegions(flag.GetRegion(ctx)),
+		groups:   flag.GetStringSlice(ctx, "process-group"),
+		metadata: map[string]string{},
+	}
+
+	switch {
+	case !f.all && len(f.ids) == 0:
+		return nil, fmt.Errorf("pick the machines to run the command on with --all or --machine")
+	case f.all && len(f.ids) > 0:
+		return nil, fmt.Errorf("--all and --machine are mutually exclusive")
+	}
+
+	for _, kv := range flag.GetStringSlice(ctx, "metadata") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", kv)
+		}
+		f.metadata[k] = v
+	}
+
+	return f, nil
+}
+
+func (f *machineFilter) match(m *api.Machine) bool {
+	switch {
+	case m.State != api.MachineStateStarted:
+		return false
+	case len(f.ids) > 0 && !lo.Contains(f.ids, m.ID):
+		return false
+	case len(f.regions) > 0 && !lo.Contains(f.regions, m.Region):
+		return false
+	case len(f.groups) > 0 && !lo.Contains(f.groups, m.ProcessGroup()):
+		return false
+	}
+
+	for k, v := range f.metadata {
+		if m.Config == nil || m.Config.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the machines matching f. Machines picked by ID must all match.
+func (f *machineFilter) filter(machines []*api.Machine) ([]*api.Machine, error) {
+	matched := lo.Filter(machines, func(m *api.Machine, _ int) bool {
+		return f.match(m)
+	})
+
+	for _, id := range f.ids {
+		if !lo.ContainsBy(matched, func(m *api.Machine) bool { return m.ID == id }) {
+			return nil, fmt.Errorf("machine %s isn't started or doesn't match the filters", id)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no started machine matches the filters")
+	}
+	return matched, nil
+}
+
+// execResult is the outcome of a command on a machine.
+type execResult struct {
+	machine  *api.Machine
+	exitCode int
+	duration time.Duration
+	err      error
+}
+
+func (r execResult) failed() bool {
+	return r.err != nil || r.exitCode != 0
+}
+
+func runExec(ctx context.Context) error {
+	var (
+		io      = iostreams.FromContext(ctx)
+		client  = client.FromContext(ctx).API()
+		appName = appconfig.NameFromContext(ctx)
+		cmdline = execCommandLine(flag.Args(ctx))
+	)
+
+	filter, err := newMachineFilter(ctx)
+	if err != nil {
+		return err
+	}
+
+	parallel := flag.GetInt(ctx, "parallel")
+	if parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+
+	app, err := client.GetAppCompact(ctx, appName)
+	if err != nil {
+		return fmt.Errorf("get app: %w", err)
+	}
+	if app.PlatformVersion != "machines" {
+		return fmt.Errorf("app %s isn't on the machines platform", appName)
+	}
+
+	flapsClient, err := flaps.New(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	machines, err := flapsClient.ListActive(ctx)
+	if err != nil {
+		return err
+	}
+	if machines, err = filter.filter(machines); err != nil {
+		return err
+	}
+
+	_, dialer, err := bringUp(ctx, client, app)
+	if err != nil {
+		return err
+	}
+
+	// one certificate is enough for all the connections of the command
+	cert, pk, err := singleUseSSHCertificate(ctx, app.Organization)
+	if err != nil {
+		return fmt.Errorf("create ssh certificate: %w (if you haven't created a key for your org yet, try `flyctl ssh issue`)", err)
+	}
+	pemkey := ssh.MarshalED25519PrivateKey(pk, "single-use certificate")
+
+	results := execAll(ctx, machines, parallel, cmdline, io.Out, io.ErrOut, func(machine *api.Machine) *ssh.Client {
+		return &ssh.Client{
+			Addr:        net.JoinHostPort(machine.PrivateIP, "22"),
+			User:        flag.GetString(ctx, "user"),
+			Dial:        dialer.DialContext,
+			Certificate: cert.Certificate,
+			PrivateKey:  string(pemkey),
+		}
+	})
+
+	fmt.Fprintln(io.Out)
+	if err := printExecResults(io.Out, results); err != nil {
+		return err
+	}
+
+	failed := lo.CountBy(results, execResult.failed)
+	if failed > 0 {
+		return fmt.Errorf("command failed on %d of %d machines", failed, len(results))
+	}
+	return nil
+}
+
+// execAll runs cmdline on machines, parallel at once, over the clients newClient returns.
+// The lines they print are written to stdout and stderr prefixed with the ID of their
+// machine, and their results are returned in the order of machines.
+func execAll(ctx context.Context, machines []*api.Machine, parallel int, cmdline string, stdout, stderr io.Writer, newClient func(*api.Machine) *ssh.Client) []execResult {
+	var (
+		mu      sync.Mutex
+		results = make([]execResult, len(machines))
+		g       errgroup.Group
+	)
+	g.SetLimit(parallel)
+
+	for i, machine := range machines {
+		i, machine := i, machine
+
+		g.Go(func() error {
+			prefix := machine.ID + " | "
+			out := &prefixWriter{mu: &mu, w: stdout, prefix: prefix}
+			errOut := &prefixWriter{mu: &mu, w: stderr, prefix: prefix}
+
+			results[i] = execOn(ctx, newClient(machine), machine, cmdline, out, errOut)
+
+			out.Flush()
+			errOut.Flush()
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
+
+// execOn runs cmdline on machine over sshClient.
+func execOn(ctx context.Context, sshClient *ssh.Client, machine *api.Machine, cmdline string, stdout, stderr io.Writer) (result execResult) {
+	result.machine = machine
+
+	start := time.Now()
+	defer func() {
+		result.duration = time.Since(start)
+	}()
+
+	if err := sshClient.Connect(ctx); err != nil {
+		result.err = fmt.Errorf("connect: %w", err)
+		return result
+	}
+	defer sshClient.Close()
+
+	result.exitCode, result.err = sshClient.Run(ctx, cmdline, stdout, stderr)
+	return result
+}
+
+func printExecResults(w io.Writer, results []execResult) error {
+	rows := make([][]string, 0, len(results))
+	for _, r := range results {
+		exitCode, errMsg := strconv.Itoa(r.exitCode), ""
+		if r.err != nil {
+			exitCode, errMsg = "-", r.err.Error()
+		}
+
+		rows = append(rows, []string{
+			r.machine.ID,
+			r.machine.Region,
+			exitCode,
+			r.duration.Round(time.Millisecond).String(),
+			errMsg,
+		})
+	}
+
+	return render.Table(w, "", rows, "Machine", "Region", "Exit Code", "Duration", "Error")
+}
+
+// prefixWriter writes every line written to it to w with a prefix. Several of them can
+// share w, since they only write whole lines, holding mu.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.w.Write(append([]byte(p.prefix), line...))
+}
+
+// Flush writes what's left of the last line, if it didn't end with a newline.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
@@ -0,0 +1,228 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sshCrypt "golang.org/x/crypto/ssh"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/ssh"
+)
+
+func TestMachineFilter(t *testing.T) {
+	machines := []*api.Machine{
+		{ID: "a", State: "started", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "app", "role": "primary"}}},
+		{ID: "b", State: "started", Region: "ord", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "app"}}},
+		{ID: "c", State: "started", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "worker"}}},
+		{ID: "d", State: "stopped", Region: "ams"},
+	}
+
+	ids := func(machines []*api.Machine) []string {
+		var ids []string
+		for _, m := range machines {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	for _, tc := range []struct {
+		filter   machineFilter
+		expected []string
+		err      string
+	}{
+		{filter: machineFilter{all: true}, expected: []string{"a", "b", "c"}},
+		{filter: machineFilter{all: true, regions: []string{"ams"}}, expected: []string{"a", "c"}},
+		{filter: machineFilter{all: true, groups: []string{"app"}}, expected: []string{"a", "b"}},
+		{filter: machineFilter{all: true, metadata: map[string]string{"role": "primary"}}, expected: []string{"a"}},
+		{filter: machineFilter{ids: []string{"b", "c"}}, expected: []string{"b", "c"}},
+		{filter: machineFilter{ids: []string{"d"}}, err: "machine d isn't started"},
+		{filter: machineFilter{all: true, regions: []string{"syd"}}, err: "no started machine"},
+	} {
+		t.Run(fmt.Sprintf("%+v", tc.filter), func(t *testing.T) {
+			matched, err := tc.filter.filter(machines)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ids(matched))
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		out bytes.Buffer
+	)
+	a := &prefixWriter{mu: &mu, w: &out, prefix: "a | "}
+	b := &prefixWriter{mu: &mu, w: &out, prefix: "b | "}
+
+	fmt.Fprint(a, "first ")
+	fmt.Fprint(b, "one\ntwo\n")
+	fmt.Fprint(a, "line\nunterminated")
+	a.Flush()
+	b.Flush()
+
+	assert.Equal(t, "b | one\nb | two\na | first line\na | unterminated\n", out.String())
+}
+
+func TestExecCommandLine(t *testing.T) {
+	assert.Equal(t, "uptime -p", execCommandLine([]string{"uptime -p"}))
+	assert.Equal(t, `'ls' '-l' 'my dir' 'it'\''s'`, execCommandLine([]string{"ls", "-l", "my dir", "it's"}))
+}
+
+func TestSplitRegions(t *testing.T) {
+	assert.Equal(t, []string{"ams", "syd"}, splitRegions("ams, syd,"))
+	assert.Empty(t, splitRegions(""))
+}
+
+// newExecServer starts an in-process SSH server running commands as its users say: "ok"
+// prints two lines, "fail" prints to stderr and exits with 3, and "hang" prints until
+// the session is closed. It returns the address of the server and a client for it.
+func newExecServer(t *testing.T) (string, func(user, addr string) *ssh.Client) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := sshCrypt.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	config := &sshCrypt.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveExec(conn, config)
+		}
+	}()
+
+	// clients connect with a certificate, like the ones issued for commands
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	caSigner, err := sshCrypt.NewSignerFromKey(caKey)
+	require.NoError(t, err)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := sshCrypt.NewPublicKey(pub)
+	require.NoError(t, err)
+	cert := &sshCrypt.Certificate{Key: sshPub, CertType: sshCrypt.UserCert, ValidBefore: sshCrypt.CertTimeInfinity}
+	require.NoError(t, cert.SignCert(rand.Reader, caSigner))
+
+	return listener.Addr().String(), func(user, addr string) *ssh.Client {
+		var dialer net.Dialer
+		return &ssh.Client{
+			Addr:        addr,
+			User:        user,
+			Dial:        dialer.DialContext,
+			Certificate: string(sshCrypt.MarshalAuthorizedKey(cert)),
+			PrivateKey:  string(ssh.MarshalED25519PrivateKey(priv, "test")),
+		}
+	}
+}
+
+func serveExec(tcpConn net.Conn, config *sshCrypt.ServerConfig) {
+	conn, chans, reqs, err := sshCrypt.NewServerConn(tcpConn, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go sshCrypt.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+
+				exitCode := 0
+				switch conn.User() {
+				case "ok":
+					fmt.Fprint(ch, "one\ntwo")
+				case "fail":
+					fmt.Fprint(ch.Stderr(), "oops\n")
+					exitCode = 3
+				case "hang":
+					for {
+						if _, err := fmt.Fprint(ch, "tick\n"); err != nil {
+							return
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+				ch.SendRequest("exit-status", false, sshCrypt.Marshal(struct{ Status uint32 }{uint32(exitCode)}))
+				return
+			}
+		}()
+	}
+}
+
+func TestExecAll(t *testing.T) {
+	addr, newClient := newExecServer(t)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed.Close()
+
+	machines := []*api.Machine{
+		{ID: "ok", Region: "ams"},
+		{ID: "fail", Region: "ord"},
+		{ID: "unreachable", Region: "syd"},
+		{ID: "hang", Region: "cdg"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	results := execAll(ctx, machines, 2, "whatever", &stdout, &stderr, func(m *api.Machine) *ssh.Client {
+		if m.ID == "unreachable" {
+			return newClient(m.ID, closed.Addr().String())
+		}
+		return newClient(m.ID, addr)
+	})
+
+	require.Len(t, results, len(machines))
+	for i, r := range results {
+		assert.Equal(t, machines[i], r.machine)
+	}
+
+	assert.Equal(t, 0, results[0].exitCode)
+	assert.NoError(t, results[0].err)
+	assert.Equal(t, 3, results[1].exitCode)
+	assert.NoError(t, results[1].err)
+	assert.ErrorContains(t, results[2].err, "connect:")
+	// the output of a command is flushed once it's stopped, not while it's still copied
+	assert.ErrorIs(t, results[3].err, context.DeadlineExceeded)
+	assert.Equal(t, 3, lo.CountBy(results, execResult.failed))
+
+	assert.Contains(t, stdout.String(), "ok | one\nok | two\n")
+	assert.Contains(t, stdout.String(), "hang | tick\n")
+	assert.Equal(t, "fail | oops\n", stderr.String())
+}
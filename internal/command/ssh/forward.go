@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/ssh"
+)
+
+// portForwards are the ports to forward through an SSH connection.
+type portForwards struct {
+	local, remote, dynamic []ssh.Forward
+}
+
+// parseForwards parses the -L, -R and -D flags.
+func parseForwards(ctx context.Context) (forwards portForwards, err error) {
+	for _, spec := range flag.GetStringSlice(ctx, "local-forward") {
+		f, err := ssh.ParseForward(spec)
+		if err != nil {
+			return forwards, fmt.Errorf("-L: %w", err)
+		}
+		forwards.local = append(forwards.local, f)
+	}
+	for _, spec := range flag.GetStringSlice(ctx, "remote-forward") {
+		f, err := ssh.ParseForward(spec)
+		if err != nil {
+			return forwards, fmt.Errorf("-R: %w", err)
+		}
+		forwards.remote = append(forwards.remote, f)
+	}
+	for _, spec := range flag.GetStringSlice(ctx, "dynamic-forward") {
+		f, err := ssh.ParseDynamicForward(spec)
+		if err != nil {
+			return forwards, fmt.Errorf("-D: %w", err)
+		}
+		forwards.dynamic = append(forwards.dynamic, f)
+	}
+	return forwards, nil
+}
+
+func (p portForwards) empty() bool {
+	return len(p.local) == 0 && len(p.remote) == 0 && len(p.dynamic) == 0
+}
+
+// start starts every forward through client until ctx is done or stop is called. When
+// a forward fails, the ones started before it are stopped.
+func (p portForwards) start(ctx context.Context, client *ssh.Client) (stop func(), err error) {
+	errOut := iostreams.FromContext(ctx).ErrOut
+
+	ctx, stop = context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			stop()
+		}
+	}()
+
+	for _, f := range p.local {
+		if err := client.ForwardLocal(ctx, f); err != nil {
+			return stop, fmt.Errorf("forward %s: %w", f.Bind(), err)
+		}
+		fmt.Fprintf(errOut, "Forwarding %s to %s from the instance\n", f.Bind(), f.Target())
+	}
+	for _, f := range p.remote {
+		if err := client.ForwardRemote(ctx, f); err != nil {
+			return stop, fmt.Errorf("forward %s: %w", f.Bind(), err)
+		}
+		fmt.Fprintf(errOut, "Forwarding %s on the instance to %s\n", f.Bind(), f.Target())
+	}
+	for _, f := range p.dynamic {
+		if err := client.ForwardDynamic(ctx, f); err != nil {
+			return stop, fmt.Errorf("forward %s: %w", f.Bind(), err)
+		}
+		fmt.Fprintf(errOut, "SOCKS5 proxy listening on %s\n", f.Bind())
+	}
+	return stop, nil
+}
@@ -0,0 +1,61 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/ssh"
+)
+
+// released reports whether addr can be listened on again, without connecting to what
+// may still listen there.
+func released(addr string) bool {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+func TestPortForwardsStopStartedOnFailure(t *testing.T) {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host, port, err := net.SplitHostPort(free.Addr().String())
+	require.NoError(t, err)
+	free.Close()
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+	busyHost, busyPort, err := net.SplitHostPort(busy.Addr().String())
+	require.NoError(t, err)
+
+	ios, _, _, _ := iostreams.Test()
+	ctx := iostreams.NewContext(context.Background(), ios)
+
+	started := ssh.Forward{BindAddr: host, BindPort: port, Host: "db.internal", HostPort: "5432"}
+	p := portForwards{
+		local:   []ssh.Forward{started},
+		dynamic: []ssh.Forward{{BindAddr: busyHost, BindPort: busyPort}},
+	}
+	// nothing is dialed through the client until a connection comes in
+	_, err = p.start(ctx, &ssh.Client{})
+	require.ErrorContains(t, err, "forward "+busy.Addr().String())
+
+	assert.Eventually(t, func() bool { return released(started.Bind()) }, 5*time.Second, 10*time.Millisecond)
+
+	// and once they all start, they're stopped by stop
+	p.dynamic = nil
+	stop, err := p.start(ctx, &ssh.Client{})
+	require.NoError(t, err)
+	assert.False(t, released(started.Bind()))
+	stop()
+	assert.Eventually(t, func() bool { return released(started.Bind()) }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,67 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/ssh"
+)
+
+func newReplay() *cobra.Command {
+	const (
+		short = "Play back a session recorded with ssh console --record"
+		long  = short + `
+
+Recordings are asciicast v2 files, which asciinema plays back too.`
+		usage = "replay <file>"
+	)
+
+	cmd := command.New(usage, short, long, runReplay)
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	flag.Add(cmd,
+		flag.String{
+			Name:        "speed",
+			Description: "Playback speed multiplier, like 2 or 0.5",
+			Default:     "1",
+		},
+		flag.Duration{
+			Name:        "idle-limit",
+			Description: "Cap pauses between events to this duration",
+		},
+	)
+
+	return cmd
+}
+
+func runReplay(ctx context.Context) error {
+	speed, err := strconv.ParseFloat(flag.GetString(ctx, "speed"), 64)
+	if err != nil || speed <= 0 {
+		return fmt.Errorf("invalid --speed %q, expected a positive number", flag.GetString(ctx, "speed"))
+	}
+
+	f, err := os.Open(flag.FirstArg(ctx))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	io := iostreams.FromContext(ctx)
+
+	_, err = ssh.Replay(ctx, f, io.Out, ssh.ReplayOptions{
+		Speed:     speed,
+		IdleLimit: flag.GetDuration(ctx, "idle-limit"),
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("replay %s: %w", flag.FirstArg(ctx), err)
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ import (
 	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/ssh"
 
 	"github.com/chzyer/readline"
 	"github.com/google/shlex"
@@ -36,6 +38,7 @@ func NewSFTP() *cobra.Command {
 		newFind(),
 		newSFTPShell(),
 		newGet(),
+		newPut(),
 	)
 
 	return cmd
@@ -71,9 +74,11 @@ func newFind() *cobra.Command {
 
 func newGet() *cobra.Command {
 	const (
-		long  = `The SFTP GET retrieves a file from a remote VM.`
-		short = long
-		usage = "get <path>"
+		long = `The SFTP GET retrieves a file, or a directory tree with --recursive, from a remote VM.
+Files are copied in parallel and verified against their checksums once copied. Interrupted
+transfers resume where they stopped when run again.`
+		short = "The SFTP GET retrieves a file from a remote VM."
+		usage = "get <path> [local-path]"
 	)
 
 	cmd := command.New(usage, short, long, runGet, command.RequireSession, command.LoadAppNameIfPresent)
@@ -81,27 +86,120 @@ func newGet() *cobra.Command {
 	cmd.Args = cobra.MaximumNArgs(2)
 
 	stdArgsSSH(cmd)
+	flag.Add(cmd, transferFlags)
+
+	return cmd
+}
+
+func newPut() *cobra.Command {
+	const (
+		long = `The SFTP PUT uploads a file, or a directory tree with --recursive, to a remote VM.
+Files are copied in parallel and verified against their checksums once copied. Interrupted
+transfers resume where they stopped when run again.`
+		short = "The SFTP PUT uploads a file to a remote VM."
+		usage = "put <local-path> [path]"
+	)
+
+	cmd := command.New(usage, short, long, runPut, command.RequireSession, command.LoadAppNameIfPresent)
+
+	cmd.Args = cobra.RangeArgs(1, 2)
+
+	stdArgsSSH(cmd)
+	flag.Add(cmd, transferFlags)
 
 	return cmd
 }
 
+var transferFlags = flag.Set{
+	flag.Bool{
+		Name:        "recursive",
+		Shorthand:   "R",
+		Description: "Copy directories and their contents",
+	},
+	flag.Int{
+		Name:        "parallel",
+		Description: "Number of files to copy at once",
+		Default:     4,
+	},
+	flag.Bool{
+		Name:        "overwrite",
+		Description: "Overwrite existing files",
+	},
+	flag.Bool{
+		Name:        "skip-existing",
+		Description: "Skip existing files",
+	},
+	flag.Bool{
+		Name:        "no-checksum",
+		Description: "Don't verify the SHA-256 checksum of files once copied",
+	},
+}
+
+// newTransfer returns a transfer set up from the flags of the get and put commands.
+func newTransfer(ctx context.Context) (*transfer, error) {
+	t := &transfer{
+		Recursive: flag.GetBool(ctx, "recursive"),
+		Parallel:  flag.GetInt(ctx, "parallel"),
+		Verify:    !flag.GetBool(ctx, "no-checksum"),
+	}
+
+	if t.Parallel < 1 {
+		return nil, fmt.Errorf("--parallel must be at least 1")
+	}
+
+	switch overwrite, skip := flag.GetBool(ctx, "overwrite"), flag.GetBool(ctx, "skip-existing"); {
+	case overwrite && skip:
+		return nil, fmt.Errorf("--overwrite and --skip-existing are mutually exclusive")
+	case overwrite:
+		t.Existing = overwriteExisting
+	case skip:
+		t.Existing = skipExisting
+	}
+
+	if io := iostreams.FromContext(ctx); io.IsStderrTTY() {
+		t.Progress = io.ErrOut
+	}
+	return t, nil
+}
+
+func runTransfer(ctx context.Context, t *transfer, src, dst string) error {
+	if err := t.plan(src, dst); err != nil {
+		return err
+	}
+
+	if err := t.run(ctx); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(iostreams.FromContext(ctx).Out, t.summary())
+	return nil
+}
+
 func newSFTPConnection(ctx context.Context) (*sftp.Client, error) {
+	_, ftp, err := connectSFTP(ctx, sftp.UseConcurrentWrites(true))
+	return ftp, err
+}
+
+// connectSFTP returns an SFTP client, reading several packets at once and set up with opts,
+// and the SSH connection it runs over. Transfers don't write several packets at once, which
+// can leave holes in partial files when interrupted, for them to resume from their size.
+func connectSFTP(ctx context.Context, opts ...sftp.ClientOption) (*ssh.Client, *sftp.Client, error) {
 	client := client.FromContext(ctx).API()
 	appName := appconfig.NameFromContext(ctx)
 
 	app, err := client.GetAppCompact(ctx, appName)
 	if err != nil {
-		return nil, fmt.Errorf("get app: %w", err)
+		return nil, nil, fmt.Errorf("get app: %w", err)
 	}
 
 	agentclient, dialer, err := bringUp(ctx, client, app)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	addr, err := lookupAddress(ctx, agentclient, dialer, app, false)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	params := &SSHParams{
@@ -119,13 +217,14 @@ func newSFTPConnection(ctx context.Context) (*sftp.Client, error) {
 	conn, err := sshConnect(params, addr)
 	if err != nil {
 		captureError(err, app)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return sftp.NewClient(conn.Client,
-		sftp.UseConcurrentReads(true),
-		sftp.UseConcurrentWrites(true),
-	)
+	ftp, err := sftp.NewClient(conn.Client, append([]sftp.ClientOption{sftp.UseConcurrentReads(true)}, opts...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, ftp, nil
 }
 
 func runLs(ctx context.Context) error {
@@ -155,64 +254,67 @@ func runLs(ctx context.Context) error {
 func runGet(ctx context.Context) error {
 	args := flag.Args(ctx)
 
-	var remote, local string
-
-	switch len(args) {
-	case 0:
+	if len(args) == 0 {
 		fmt.Printf("get <remote-path> [local-path]\n")
 		return nil
+	}
 
-	case 1:
-		remote = args[0]
-		local = remote
-
-	default:
-		remote = args[0]
+	// like scp, copy to the current directory by default
+	remote, local := args[0], "."
+	if len(args) > 1 {
 		local = args[1]
 	}
 
-	if _, err := os.Stat(local); err == nil {
-		return fmt.Errorf("get: local file %s: already exists", remote)
+	t, err := newTransfer(ctx)
+	if err != nil {
+		return err
 	}
 
-	ftp, err := newSFTPConnection(ctx)
+	conn, ftp, err := connectSFTP(ctx)
 	if err != nil {
 		return err
 	}
+	defer ftp.Close()
 
-	rf, err := ftp.Open(remote)
-	if err != nil {
-		return fmt.Errorf("get: remote file %s: %w", remote, err)
+	t.Src, t.Dst = remoteFS{ftp, conn}, localFS{}
+	if err := runTransfer(ctx, t, remote, local); err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	return nil
+}
+
+func runPut(ctx context.Context) error {
+	args := flag.Args(ctx)
+
+	// relative remote paths are relative to the home directory of the user
+	local, remote := args[0], "."
+	if len(args) > 1 {
+		remote = args[1]
 	}
-	defer rf.Close()
 
-	f, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	t, err := newTransfer(ctx)
 	if err != nil {
-		return fmt.Errorf("get: local file %s: %w", local, err)
+		return err
 	}
-	defer f.Close()
 
-	bytes, err := rf.WriteTo(f)
+	conn, ftp, err := connectSFTP(ctx)
 	if err != nil {
-		return fmt.Errorf("get: copy file: %w (%d bytes written)", err, bytes)
+		return err
 	}
+	defer ftp.Close()
 
-	fmt.Printf("%d bytes written to %s\n", bytes, local)
+	t.Src, t.Dst = localFS{}, remoteFS{ftp, conn}
+	if err := runTransfer(ctx, t, local, remote); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
 	return nil
 }
 
-var completer = readline.NewPrefixCompleter(
-	readline.PcItem("ls"),
-	readline.PcItem("cd"),
-	readline.PcItem("get"),
-	readline.PcItem("put"),
-	readline.PcItem("chmod"),
-)
-
 type sftpContext struct {
-	ftp *sftp.Client
-	wd  string
-	out func(string, ...interface{})
+	ftp    *sftp.Client
+	wd     string
+	stdout io.Writer
+	out    func(string, ...interface{})
 }
 
 func (sc *sftpContext) cd(args ...string) error {
@@ -249,8 +351,6 @@ func (sc *sftpContext) cd(args ...string) error {
 	return nil
 }
 
-// BUG(tqbf): these return `error` because in theory an error might be bad enough
-// that we want to kill the session, but nothing does that right now.
 func (sc *sftpContext) ls(args ...string) error {
 	fgs := goflag.NewFlagSet("ls", goflag.ContinueOnError)
 
@@ -524,9 +624,11 @@ func runShell(ctx context.Context) error {
 		return err
 	}
 
+	sc := newSFTPContext(ftp, os.Stdout)
+
 	l, err := readline.NewEx(&readline.Config{
 		Prompt:          "\033[31m»\033[0m ",
-		AutoComplete:    completer,
+		AutoComplete:    sftpCompleter{sc},
 		InterruptPrompt: "^C",
 		EOFPrompt:       "exit",
 
@@ -540,16 +642,6 @@ func runShell(ctx context.Context) error {
 	defer l.Close()
 	l.CaptureExitSignal()
 
-	out := func(format string, args ...interface{}) {
-		fmt.Printf(format+"\n", args...)
-	}
-
-	sc := &sftpContext{
-		wd:  "/",
-		out: out,
-		ftp: ftp,
-	}
-
 	for {
 		line, err := l.Readline()
 		if err == readline.ErrInterrupt {
@@ -564,7 +656,7 @@ func runShell(ctx context.Context) error {
 
 		args, err := shlex.Split(strings.TrimSpace(line))
 		if err != nil {
-			out("read command: %s", err)
+			sc.out("read command: %s", err)
 			continue
 		}
 
@@ -572,35 +664,7 @@ func runShell(ctx context.Context) error {
 			continue
 		}
 
-		switch args[0] {
-		case "cd":
-			if err = sc.cd(args...); err != nil {
-				return err
-			}
-
-		case "ls":
-			if err = sc.ls(args...); err != nil {
-				return err
-			}
-
-		case "get":
-			if err = sc.get(args...); err != nil {
-				return err
-			}
-
-		case "put":
-			if err = sc.put(args...); err != nil {
-				return err
-			}
-
-		case "chmod":
-			if err = sc.chmod(args...); err != nil {
-				return err
-			}
-
-		default:
-			out("unrecognized command; try 'cd', 'ls', 'get', 'put', or 'chmod'")
-		}
+		sc.exec(args...)
 	}
 
 	return nil
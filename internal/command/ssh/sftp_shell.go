@@ -0,0 +1,379 @@
+package ssh
+
+import (
+	"errors"
+	goflag "flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/sftp"
+)
+
+// shellCommand is a command of the SFTP shell. Errors it returns are reported, and the
+// shell keeps running.
+type shellCommand struct {
+	run   func(sc *sftpContext, args ...string) error
+	usage string
+	// local is set for commands whose arguments are local paths.
+	local bool
+}
+
+var shellCommands = map[string]shellCommand{
+	"cat":   {run: (*sftpContext).cat, usage: "cat <file>..."},
+	"cd":    {run: (*sftpContext).cd, usage: "cd [dir]"},
+	"chmod": {run: (*sftpContext).chmod, usage: "chmod <numeric-mode> <file>"},
+	"du":    {run: (*sftpContext).du, usage: "du [path]..."},
+	"get":   {run: (*sftpContext).get, usage: "get <filename> [local-filename]"},
+	"lcd":   {run: (*sftpContext).lcd, usage: "lcd [local-dir]", local: true},
+	"lls":   {run: (*sftpContext).lls, usage: "lls [-l] [local-dir]", local: true},
+	"ls":    {run: (*sftpContext).ls, usage: "ls [-l] [dir]"},
+	"mkdir": {run: (*sftpContext).mkdir, usage: "mkdir [-p] <dir>..."},
+	"mv":    {run: (*sftpContext).mv, usage: "mv <path> <new-path>"},
+	"put":   {run: (*sftpContext).put, usage: "put [-m mode] <local-filename> [filename]", local: true},
+	"pwd":   {run: (*sftpContext).pwd, usage: "pwd"},
+	"rm":    {run: (*sftpContext).rm, usage: "rm <file>..."},
+	"rmdir": {run: (*sftpContext).rmdir, usage: "rmdir <dir>..."},
+	"stat":  {run: (*sftpContext).stat, usage: "stat <path>..."},
+}
+
+func shellCommandNames() []string {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newSFTPContext(ftp *sftp.Client, stdout io.Writer) *sftpContext {
+	return &sftpContext{
+		ftp:    ftp,
+		wd:     "/",
+		stdout: stdout,
+		out: func(format string, args ...interface{}) {
+			fmt.Fprintf(stdout, format+"\n", args...)
+		},
+	}
+}
+
+// exec runs the shell command args, reporting its errors.
+func (sc *sftpContext) exec(args ...string) {
+	cmd, ok := shellCommands[args[0]]
+	if !ok {
+		sc.out("unrecognized command; try %s", strings.Join(shellCommandNames(), ", "))
+		return
+	}
+
+	if err := cmd.run(sc, args...); err != nil {
+		if errors.Is(err, errUsage) {
+			sc.out("usage: %s", cmd.usage)
+		} else {
+			sc.out("%s: %s", args[0], err)
+		}
+	}
+}
+
+var errUsage = errors.New("invalid arguments")
+
+// abs returns p relative to the working directory, unless it's absolute.
+func (sc *sftpContext) abs(p string) string {
+	if !path.IsAbs(p) {
+		p = sc.wd + p
+	}
+	return path.Clean(p)
+}
+
+// each calls fn with every path of args, past the command name, and reports its errors.
+func (sc *sftpContext) each(args []string, fn func(p string) error) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	for _, arg := range args[1:] {
+		if err := fn(sc.abs(arg)); err != nil {
+			sc.out("%s %s: %s", args[0], arg, err)
+		}
+	}
+	return nil
+}
+
+func (sc *sftpContext) pwd(args ...string) error {
+	sc.out("%s", sc.wd)
+	return nil
+}
+
+func (sc *sftpContext) rm(args ...string) error {
+	return sc.each(args, func(p string) error {
+		info, err := sc.ftp.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return errors.New("is a directory, use rmdir")
+		}
+		return sc.ftp.Remove(p)
+	})
+}
+
+func (sc *sftpContext) rmdir(args ...string) error {
+	return sc.each(args, sc.ftp.RemoveDirectory)
+}
+
+func (sc *sftpContext) mkdir(args ...string) error {
+	fgs := goflag.NewFlagSet("mkdir", goflag.ContinueOnError)
+	fgs.SetOutput(io.Discard)
+	parents := fgs.Bool("p", false, "create parent directories")
+
+	if err := fgs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	mkdir := sc.ftp.Mkdir
+	if *parents {
+		mkdir = sc.ftp.MkdirAll
+	}
+	return sc.each(append(args[:1:1], fgs.Args()...), mkdir)
+}
+
+func (sc *sftpContext) mv(args ...string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+
+	from, to := sc.abs(args[1]), sc.abs(args[2])
+
+	// like mv, move into existing directories
+	if info, err := sc.ftp.Stat(to); err == nil && info.IsDir() {
+		to = path.Join(to, path.Base(from))
+	}
+
+	// plain SFTP renames fail when the new path exists, so prefer the OpenSSH extension
+	if err := sc.ftp.PosixRename(from, to); err != nil {
+		if err := sc.ftp.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *sftpContext) stat(args ...string) error {
+	return sc.each(args, func(p string) error {
+		info, err := sc.ftp.Lstat(p)
+		if err != nil {
+			return err
+		}
+
+		sc.out("  File: %s", p)
+		sc.out("  Size: %d\tType: %s", info.Size(), fileType(info.Mode()))
+		if st, ok := info.Sys().(*sftp.FileStat); ok {
+			sc.out("  Mode: %s (%04o)\tUid: %d\tGid: %d", info.Mode(), info.Mode().Perm(), st.UID, st.GID)
+		} else {
+			sc.out("  Mode: %s (%04o)", info.Mode(), info.Mode().Perm())
+		}
+		sc.out("Modify: %s", info.ModTime())
+		return nil
+	})
+}
+
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symbolic link"
+	case mode.IsRegular():
+		return "regular file"
+	default:
+		return "special file"
+	}
+}
+
+func (sc *sftpContext) du(args ...string) error {
+	if len(args) < 2 {
+		args = append(args, ".")
+	}
+
+	return sc.each(args, func(p string) error {
+		var total int64
+
+		walker := sc.ftp.Walk(p)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				return err
+			}
+			if info := walker.Stat(); info.Mode().IsRegular() {
+				total += info.Size()
+			}
+		}
+
+		sc.out("%s\t%s", humanize.Bytes(uint64(total)), p)
+		return nil
+	})
+}
+
+func (sc *sftpContext) cat(args ...string) error {
+	return sc.each(args, func(p string) error {
+		f, err := sc.ftp.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = f.WriteTo(sc.stdout)
+		return err
+	})
+}
+
+func (sc *sftpContext) lcd(args ...string) error {
+	var dir string
+	switch len(args) {
+	case 1:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = home
+	case 2:
+		dir = args[1]
+	default:
+		return errUsage
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	sc.out("[%s]", wd)
+	return nil
+}
+
+func (sc *sftpContext) lls(args ...string) error {
+	fgs := goflag.NewFlagSet("lls", goflag.ContinueOnError)
+	fgs.SetOutput(io.Discard)
+	long := fgs.Bool("l", false, "detailed file output")
+
+	if err := fgs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	dir := "."
+	if fgs.NArg() > 0 {
+		dir = fgs.Arg(0)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		suffix := ""
+		if entry.IsDir() {
+			suffix = "/"
+		}
+
+		if !*long {
+			sc.out("%s%s", entry.Name(), suffix)
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		sc.out("%s  %d\t%s\t%s%s", info.Mode(), info.Size(), info.ModTime(), entry.Name(), suffix)
+	}
+	return nil
+}
+
+// sftpCompleter completes the names of shell commands, then paths listed on the VM as
+// they're typed, or locally for the commands working with local files.
+type sftpCompleter struct {
+	sc *sftpContext
+}
+
+func (c sftpCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	words := strings.Split(string(line[:pos]), " ")
+	word := words[len(words)-1]
+
+	if len(words) == 1 {
+		return completeWord(word, shellCommandNames()), len([]rune(word))
+	}
+
+	list := c.listRemote
+	if cmd, ok := shellCommands[words[0]]; ok && cmd.local {
+		list = listLocal
+	}
+
+	candidates, base := completePath(word, list)
+	return candidates, len([]rune(base))
+}
+
+func (c sftpCompleter) listRemote(dir string) ([]fs.FileInfo, error) {
+	return c.sc.ftp.ReadDir(c.sc.abs(dir))
+}
+
+func listLocal(dir string) ([]fs.FileInfo, error) {
+	if dir == "" {
+		dir = "."
+	}
+	return localFS{}.ReadDir(dir)
+}
+
+// completeWord returns the rest of the words starting with prefix.
+func completeWord(prefix string, words []string) [][]rune {
+	var candidates [][]rune
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			candidates = append(candidates, []rune(w[len(prefix):]+" "))
+		}
+	}
+	return candidates
+}
+
+// completePath returns the rest of the names starting like the last element of p in the
+// directory list returns for the ones before it, and that last element. Directories are
+// completed with a slash, and dotfiles only once a dot is typed.
+func completePath(p string, list func(dir string) ([]fs.FileInfo, error)) ([][]rune, string) {
+	dir, base := "", p
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		dir, base = p[:i+1], p[i+1:]
+	}
+
+	infos, err := list(dir)
+	if err != nil {
+		return nil, base
+	}
+
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+
+		if info.IsDir() {
+			name += "/"
+		} else {
+			name += " "
+		}
+		names = append(names, name[len(base):])
+	}
+	sort.Strings(names)
+
+	candidates := make([][]rune, 0, len(names))
+	for _, name := range names {
+		candidates = append(candidates, []rune(name))
+	}
+	return candidates, base
+}
@@ -0,0 +1,144 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSFTPClient returns an SFTP client set up with opts, connected to an in-process
+// server serving the local filesystem.
+func newTestSFTPClient(t *testing.T, opts ...sftp.ClientOption) *sftp.Client {
+	t.Helper()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	require.NoError(t, err)
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(cr, cw, opts...)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+// newTestShell returns an SFTP shell connected to an in-process server, in a temporary
+// directory.
+func newTestShell(t *testing.T) (*sftpContext, *bytes.Buffer, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	out := &bytes.Buffer{}
+	sc := newSFTPContext(newTestSFTPClient(t), out)
+	sc.wd = filepath.ToSlash(dir) + "/"
+
+	return sc, out, dir
+}
+
+func TestShellFileCommands(t *testing.T) {
+	sc, out, dir := newTestShell(t)
+
+	sc.exec("mkdir", "-p", "a/b")
+	assert.DirExists(t, filepath.Join(dir, "a", "b"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "hello.txt"), []byte("hello\n"), 0644))
+
+	sc.exec("cat", "a/hello.txt")
+	assert.Equal(t, "hello\n", out.String())
+
+	out.Reset()
+	sc.exec("mv", "a/hello.txt", "a/b")
+	assert.FileExists(t, filepath.Join(dir, "a", "b", "hello.txt"))
+
+	sc.exec("du", "a")
+	assert.Contains(t, out.String(), "6 B")
+
+	out.Reset()
+	sc.exec("stat", "a/b/hello.txt")
+	assert.Contains(t, out.String(), "Size: 6")
+	assert.Contains(t, out.String(), "regular file")
+
+	sc.exec("rm", "a/b/hello.txt")
+	assert.NoFileExists(t, filepath.Join(dir, "a", "b", "hello.txt"))
+
+	sc.exec("rmdir", "a/b")
+	assert.NoDirExists(t, filepath.Join(dir, "a", "b"))
+
+	out.Reset()
+	sc.exec("pwd")
+	assert.Equal(t, sc.wd+"\n", out.String())
+}
+
+func TestShellKeepsGoingAfterErrors(t *testing.T) {
+	sc, out, dir := newTestShell(t)
+
+	sc.exec("rm", "missing", "also-missing")
+	assert.Contains(t, out.String(), "rm missing:")
+	assert.Contains(t, out.String(), "rm also-missing:")
+
+	out.Reset()
+	sc.exec("mv", "just-one")
+	assert.Equal(t, "usage: mv <path> <new-path>\n", out.String())
+
+	out.Reset()
+	sc.exec("nope")
+	assert.Contains(t, out.String(), "unrecognized command")
+
+	sc.exec("mkdir", "still-there")
+	assert.DirExists(t, filepath.Join(dir, "still-there"))
+}
+
+func TestShellCompletesRemotePaths(t *testing.T) {
+	sc, _, dir := newTestShell(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data", "logs"), 0755))
+	for _, name := range []string{"data/db.sqlite", "data/.hidden", "readme.md"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	complete := func(line string) ([]string, int) {
+		candidates, n := sftpCompleter{sc}.Do([]rune(line), len(line))
+
+		var words []string
+		for _, c := range candidates {
+			words = append(words, string(c))
+		}
+		return words, n
+	}
+
+	words, n := complete("rm")
+	assert.Equal(t, []string{" ", "dir "}, words)
+	assert.Equal(t, 2, n)
+
+	words, n = complete("cat da")
+	assert.Equal(t, []string{"ta/"}, words)
+	assert.Equal(t, 2, n)
+
+	words, n = complete("cat data/")
+	assert.Equal(t, []string{"db.sqlite ", "logs/"}, words)
+	assert.Equal(t, 0, n)
+
+	words, _ = complete("cat data/.")
+	assert.Equal(t, []string{"hidden "}, words)
+
+	words, _ = complete("cat " + filepath.ToSlash(dir) + "/re")
+	assert.Equal(t, []string{"adme.md "}, words)
+
+	words, _ = complete("cat xyz")
+	assert.Empty(t, words)
+}
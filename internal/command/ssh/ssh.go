@@ -16,9 +16,12 @@ func New() *cobra.Command {
 
 	cmd.AddCommand(
 		newConsole(),
+		newExec(),
 		newIssue(),
 		newLog(),
 		NewSFTP(),
+		newSync(),
+		newReplay(),
 	)
 
 	return cmd
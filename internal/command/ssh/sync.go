@@ -0,0 +1,418 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/builder/dockerignore"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func newSync() *cobra.Command {
+	const (
+		long = `Synchronize a local directory with a directory on a remote VM, like rsync.
+Only the files that differ in size or modification time, or in content with --checksum,
+are copied. Files are pushed to the VM unless --pull is set.`
+		short = "Synchronize a local directory with a directory on a remote VM"
+		usage = "sync <local-dir> <remote-dir>"
+	)
+
+	cmd := command.New(usage, short, long, runSync, command.RequireSession, command.LoadAppNameIfPresent)
+
+	cmd.Args = cobra.ExactArgs(2)
+
+	stdArgsSSH(cmd)
+	flag.Add(cmd,
+		flag.Bool{
+			Name:        "pull",
+			Description: "Copy the files of the remote directory to the local one",
+		},
+		flag.Bool{
+			Name:        "delete",
+			Description: "Delete the files of the destination missing from the source",
+		},
+		flag.Bool{
+			Name:        "checksum",
+			Description: "Compare the SHA-256 checksum of files of the same size, and verify the ones copied",
+		},
+		flag.Bool{
+			Name:        "dry-run",
+			Description: "Print the changes without making them",
+		},
+		flag.StringSlice{
+			Name:        "exclude",
+			Description: "Exclude paths matching the pattern, in the .dockerignore syntax",
+		},
+		flag.String{
+			Name:        "ignorefile",
+			Description: "Path to a file of patterns to exclude, in the .dockerignore syntax. Defaults to the .dockerignore file of the local directory.",
+		},
+		flag.Int{
+			Name:        "parallel",
+			Description: "Number of files to copy at once",
+			Default:     4,
+		},
+	)
+
+	return cmd
+}
+
+func runSync(ctx context.Context) error {
+	args := flag.Args(ctx)
+	local, remote := args[0], args[1]
+
+	parallel := flag.GetInt(ctx, "parallel")
+	if parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+
+	excluded, err := syncExcludes(ctx, local)
+	if err != nil {
+		return err
+	}
+
+	conn, ftp, err := connectSFTP(ctx)
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	t := &transfer{
+		Src:      localFS{},
+		Dst:      remoteFS{ftp, conn},
+		Existing: overwriteExisting,
+		Parallel: parallel,
+		Verify:   flag.GetBool(ctx, "checksum"),
+	}
+	src, dst := local, remote
+	if flag.GetBool(ctx, "pull") {
+		t.Src, t.Dst = t.Dst, t.Src
+		src, dst = remote, local
+	}
+
+	s := &syncer{
+		transfer: t,
+		Delete:   flag.GetBool(ctx, "delete"),
+		Checksum: flag.GetBool(ctx, "checksum"),
+		Excluded: excluded,
+	}
+	if err := s.plan(src, dst); err != nil {
+		return err
+	}
+
+	io := iostreams.FromContext(ctx)
+	if flag.GetBool(ctx, "dry-run") {
+		s.printPlan(io.Out)
+		fmt.Fprintf(io.Out, "Dry run: %s\n", s.summary(false))
+		return nil
+	}
+
+	if io.IsStderrTTY() {
+		t.Progress = io.ErrOut
+	}
+	if err := s.run(ctx); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(io.Out, s.summary(true))
+	return nil
+}
+
+// syncExcludes returns a func reporting whether relative paths are excluded by the
+// --exclude patterns and the ignore file.
+func syncExcludes(ctx context.Context, local string) (func(rel string) bool, error) {
+	ignorefile := flag.GetString(ctx, "ignorefile")
+	if ignorefile == "" {
+		ignorefile = filepath.Join(local, ".dockerignore")
+		if _, err := os.Stat(ignorefile); err != nil {
+			ignorefile = ""
+		}
+	}
+
+	var patterns []string
+	if ignorefile != "" {
+		data, err := os.ReadFile(ignorefile)
+		if err != nil {
+			return nil, err
+		}
+		patterns = strings.Split(string(data), "\n")
+	}
+
+	// unlike builds, the ignore file and Dockerfile aren't kept when a pattern matches them
+	return newExcludeMatcher(append(patterns, flag.GetStringSlice(ctx, "exclude")...))
+}
+
+// newExcludeMatcher returns whether a relative path matches any of patterns, in the
+// .dockerignore format, or nil without any pattern.
+func newExcludeMatcher(patterns []string) (func(rel string) bool, error) {
+	patterns, err := dockerignore.ReadAll(strings.NewReader(strings.Join(patterns, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	pm, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+
+	return func(rel string) bool {
+		match, _ := pm.Matches(filepath.FromSlash(rel))
+		return match
+	}, nil
+}
+
+// syncAction is what a sync does with a path.
+type syncAction string
+
+const (
+	syncCopy   syncAction = "copy"
+	syncUpdate syncAction = "update"
+	syncDelete syncAction = "delete"
+)
+
+type syncChange struct {
+	Action syncAction
+	// Path is relative to the synchronized directories, with slashes.
+	Path string
+	Info fs.FileInfo
+}
+
+// syncer synchronizes the destination of its transfer with its source.
+type syncer struct {
+	*transfer
+
+	// Delete removes the files of the destination missing from the source.
+	Delete bool
+	// Checksum compares the checksums of files of the same size whose modification
+	// time differs, rather than copying them right away.
+	Checksum bool
+	// Excluded, if set, reports the relative paths to leave alone at both ends.
+	Excluded func(rel string) bool
+
+	srcRoot  string
+	dstRoot  string
+	changes  []syncChange
+	upToDate int
+}
+
+// syncTree is the files and directories of a synchronized tree, by relative path.
+type syncTree map[string]fs.FileInfo
+
+// listTree returns the files and directories below root, minus the excluded ones. A
+// missing root is empty.
+func (s *syncer) listTree(fsys transferFS, root string) (syncTree, error) {
+	entries := syncTree{}
+
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		infos, err := fsys.ReadDir(joinRel(fsys, root, rel))
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			p := path.Join(rel, info.Name())
+			if isPartialName(p) || (s.Excluded != nil && s.Excluded(p)) {
+				continue
+			}
+
+			switch {
+			case info.IsDir():
+				entries[p] = info
+				if err := walk(p); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				entries[p] = info
+			}
+		}
+		return nil
+	}
+
+	switch info, err := fsys.Stat(root); {
+	case errors.Is(err, fs.ErrNotExist):
+		return entries, nil
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// plan lists the changes that make dst like src.
+func (s *syncer) plan(src, dst string) error {
+	s.srcRoot, s.dstRoot = src, dst
+
+	// a missing source isn't empty, or --delete would empty the destination
+	if _, err := s.Src.Stat(src); err != nil {
+		return err
+	}
+
+	srcEntries, err := s.listTree(s.Src, src)
+	if err != nil {
+		return err
+	}
+	dstEntries, err := s.listTree(s.Dst, dst)
+	if err != nil {
+		return err
+	}
+
+	s.dirs = append(s.dirs, dst)
+
+	for _, p := range sortedPaths(srcEntries) {
+		info, existing := srcEntries[p], dstEntries[p]
+
+		if existing != nil && existing.IsDir() != info.IsDir() {
+			return fmt.Errorf("%s is a directory at one end and a file at the other", p)
+		}
+
+		switch {
+		case info.IsDir():
+			if existing == nil {
+				s.dirs = append(s.dirs, joinRel(s.Dst, dst, p))
+			}
+			continue
+		case existing == nil:
+			s.changes = append(s.changes, syncChange{syncCopy, p, info})
+		default:
+			same, err := s.same(p, info, existing)
+			if err != nil {
+				return err
+			}
+			if same {
+				s.upToDate++
+				continue
+			}
+			s.changes = append(s.changes, syncChange{syncUpdate, p, info})
+		}
+
+		err := s.planFile(joinRel(s.Src, src, p), joinRel(s.Dst, dst, p), info)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Delete {
+		// children first, so directories are empty once deleted
+		paths := sortedPaths(dstEntries)
+		for i := len(paths) - 1; i >= 0; i-- {
+			if p := paths[i]; srcEntries[p] == nil {
+				s.changes = append(s.changes, syncChange{syncDelete, p, dstEntries[p]})
+			}
+		}
+	}
+	return nil
+}
+
+// same reports whether the file at p is the same at both ends.
+func (s *syncer) same(p string, src, dst fs.FileInfo) (bool, error) {
+	if src.Size() != dst.Size() {
+		return false, nil
+	}
+
+	// SFTP only keeps modification times to the second
+	if src.ModTime().Unix() == dst.ModTime().Unix() {
+		return true, nil
+	}
+	if !s.Checksum {
+		return false, nil
+	}
+
+	srcSum, err := s.Src.Checksum(joinRel(s.Src, s.srcRoot, p))
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := s.Dst.Checksum(joinRel(s.Dst, s.dstRoot, p))
+	if err != nil {
+		return false, err
+	}
+	return srcSum == dstSum, nil
+}
+
+// joinRel joins root and rel, a path relative to it with slashes, on fsys.
+func joinRel(fsys transferFS, root, rel string) string {
+	return fsys.Join(append([]string{root}, strings.Split(rel, "/")...)...)
+}
+
+func sortedPaths(entries syncTree) []string {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// run makes the planned changes, copying files before deleting any.
+func (s *syncer) run(ctx context.Context) error {
+	if err := s.transfer.run(ctx); err != nil {
+		return err
+	}
+
+	for _, change := range s.changes {
+		if change.Action != syncDelete {
+			continue
+		}
+
+		if err := s.Dst.Remove(joinRel(s.Dst, s.dstRoot, change.Path)); err != nil {
+			return fmt.Errorf("delete %s: %w", change.Path, err)
+		}
+	}
+	return nil
+}
+
+func (s *syncer) printPlan(w io.Writer) {
+	for _, change := range s.changes {
+		switch {
+		case change.Info.IsDir():
+			fmt.Fprintf(w, "%-6s %s/\n", change.Action, change.Path)
+		default:
+			fmt.Fprintf(w, "%-6s %s (%s)\n", change.Action, change.Path, humanize.Bytes(uint64(change.Info.Size())))
+		}
+	}
+}
+
+// summary counts the changes of the sync, once done or to be made.
+func (s *syncer) summary(done bool) string {
+	counts := map[syncAction]int{}
+	sizes := map[syncAction]int64{}
+	for _, change := range s.changes {
+		counts[change.Action]++
+		if !change.Info.IsDir() {
+			sizes[change.Action] += change.Info.Size()
+		}
+	}
+
+	format := "%d to copy (%s), %d to update (%s), %d to delete, %d up to date"
+	if done {
+		format = "%d copied (%s), %d updated (%s), %d deleted, %d up to date"
+	}
+
+	return fmt.Sprintf(format,
+		counts[syncCopy], humanize.Bytes(uint64(sizes[syncCopy])),
+		counts[syncUpdate], humanize.Bytes(uint64(sizes[syncUpdate])),
+		counts[syncDelete], s.upToDate,
+	)
+}
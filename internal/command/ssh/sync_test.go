@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalSyncer(s *syncer) *syncer {
+	s.transfer = &transfer{
+		Src:      localFS{},
+		Dst:      localFS{},
+		Existing: overwriteExisting,
+		Parallel: 2,
+		Verify:   s.Checksum,
+	}
+	return s
+}
+
+func TestSync(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"same.txt":        "same",
+		"changed.txt":     "new content",
+		"new/nested.txt":  "nested",
+		"node_modules/x":  "excluded",
+		"touched.txt":     "touched",
+		"new/.hidden.txt": "hidden",
+	})
+	writeFiles(t, dst, map[string]string{
+		"same.txt":       "same",
+		"changed.txt":    "old",
+		"touched.txt":    "touched",
+		"stale/gone.txt": "gone",
+		"node_modules/y": "kept, since excluded",
+	})
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"same.txt", "touched.txt"} {
+		require.NoError(t, os.Chtimes(filepath.Join(src, name), mtime, mtime))
+	}
+	require.NoError(t, os.Chtimes(filepath.Join(dst, "same.txt"), mtime, mtime))
+
+	excluded := func(rel string) bool {
+		return rel == "node_modules"
+	}
+
+	s := newLocalSyncer(&syncer{Delete: true, Checksum: true, Excluded: excluded})
+	require.NoError(t, s.plan(src, dst))
+
+	var plan []string
+	for _, change := range s.changes {
+		plan = append(plan, string(change.Action)+" "+change.Path)
+	}
+	assert.Equal(t, []string{
+		"update changed.txt",
+		"copy new/.hidden.txt",
+		"copy new/nested.txt",
+		"delete stale/gone.txt",
+		"delete stale",
+	}, plan)
+	// same size and content with a different modification time
+	assert.Equal(t, 2, s.upToDate)
+	assert.Equal(t, "2 to copy (12 B), 1 to update (11 B), 2 to delete, 2 up to date", s.summary(false))
+
+	require.NoError(t, s.run(context.Background()))
+	assertFiles(t, dst, map[string]string{
+		"same.txt":        "same",
+		"changed.txt":     "new content",
+		"new/nested.txt":  "nested",
+		"new/.hidden.txt": "hidden",
+		"node_modules/y":  "kept, since excluded",
+	})
+	assert.NoDirExists(t, filepath.Join(dst, "stale"))
+	assert.NoFileExists(t, filepath.Join(dst, "node_modules", "x"))
+
+	// copies keep their modification time, so only the file the checksums matched is
+	// left with a different one
+	s = newLocalSyncer(&syncer{Delete: true, Excluded: excluded})
+	require.NoError(t, s.plan(src, dst))
+	assert.Equal(t, []string{"touched.txt"}, changedPaths(s))
+}
+
+func changedPaths(s *syncer) []string {
+	var paths []string
+	for _, change := range s.changes {
+		paths = append(paths, change.Path)
+	}
+	return paths
+}
+
+func TestSyncMissingSource(t *testing.T) {
+	dst := t.TempDir()
+	writeFiles(t, dst, map[string]string{"keep.txt": "keep"})
+
+	s := newLocalSyncer(&syncer{Delete: true})
+	assert.Error(t, s.plan(filepath.Join(t.TempDir(), "missing"), dst))
+}
+
+func TestSyncCreatesDestination(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "a", "b")
+	writeFiles(t, src, map[string]string{"dir/file": strings.Repeat("x", 10)})
+
+	s := newLocalSyncer(&syncer{})
+	require.NoError(t, s.plan(src, dst))
+	require.NoError(t, s.run(context.Background()))
+	assertFiles(t, dst, map[string]string{"dir/file": strings.Repeat("x", 10)})
+}
+
+func TestExcludeMatcher(t *testing.T) {
+	excluded, err := newExcludeMatcher([]string{"# comment", ".dockerignore", "Dockerfile", "*.log", "!keep.log", ""})
+	require.NoError(t, err)
+
+	assert.True(t, excluded(".dockerignore"))
+	assert.True(t, excluded("Dockerfile"))
+	assert.True(t, excluded("debug.log"))
+	assert.False(t, excluded("keep.log"))
+	assert.False(t, excluded("main.go"))
+
+	excluded, err = newExcludeMatcher([]string{"# nothing", ""})
+	require.NoError(t, err)
+	assert.Nil(t, excluded)
+}
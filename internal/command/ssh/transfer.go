@@ -0,0 +1,684 @@
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/sftp"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/superfly/flyctl/ssh"
+	"github.com/superfly/flyctl/terminal"
+)
+
+// transferFS is one end of a file transfer, either the local filesystem or the one of a VM.
+type transferFS interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.FileInfo, error)
+	Open(name string) (transferFile, error)
+	OpenFile(name string, flag int) (transferFile, error)
+	MkdirAll(name string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	// Checksum returns the hex encoded SHA-256 checksum of the named file.
+	Checksum(name string) (string, error)
+	Join(elem ...string) string
+	Base(name string) string
+}
+
+type transferFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+type localFS struct{}
+
+func (localFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) Open(name string) (transferFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localFS) OpenFile(name string, flag int) (transferFile, error) {
+	f, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localFS) MkdirAll(name string) error {
+	return os.MkdirAll(name, 0755)
+}
+
+func (localFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (localFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (localFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (localFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (localFS) Checksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return checksum(f)
+}
+
+func (localFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (localFS) Base(name string) string {
+	return filepath.Base(name)
+}
+
+// remoteFS is the filesystem of a VM, over SFTP. Checksums are computed on the VM when
+// the SSH connection is set and sha256sum is available there, or else by reading the
+// file back.
+type remoteFS struct {
+	ftp  *sftp.Client
+	conn *ssh.Client
+}
+
+func (fsys remoteFS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.ftp.Stat(name)
+}
+
+func (fsys remoteFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	return fsys.ftp.ReadDir(name)
+}
+
+func (fsys remoteFS) Open(name string) (transferFile, error) {
+	f, err := fsys.ftp.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fsys remoteFS) OpenFile(name string, flag int) (transferFile, error) {
+	f, err := fsys.ftp.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fsys remoteFS) MkdirAll(name string) error {
+	return fsys.ftp.MkdirAll(name)
+}
+
+func (fsys remoteFS) Chmod(name string, mode fs.FileMode) error {
+	return fsys.ftp.Chmod(name, mode)
+}
+
+func (fsys remoteFS) Chtimes(name string, atime, mtime time.Time) error {
+	return fsys.ftp.Chtimes(name, atime, mtime)
+}
+
+func (fsys remoteFS) Rename(oldname, newname string) error {
+	// plain SFTP renames fail when newname exists, so prefer the OpenSSH extension
+	if err := fsys.ftp.PosixRename(oldname, newname); err == nil {
+		return nil
+	}
+
+	if err := fsys.ftp.Remove(newname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return fsys.ftp.Rename(oldname, newname)
+}
+
+func (fsys remoteFS) Remove(name string) error {
+	return fsys.ftp.Remove(name)
+}
+
+func (fsys remoteFS) Checksum(name string) (string, error) {
+	if fsys.conn != nil {
+		sum, err := fsys.remoteChecksum(name)
+		if err == nil {
+			return sum, nil
+		}
+		terminal.Debugf("sha256sum %s failed, reading the file back: %v\n", name, err)
+	}
+
+	f, err := fsys.ftp.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return checksum(f)
+}
+
+// remoteChecksum runs sha256sum on the VM.
+func (fsys remoteFS) remoteChecksum(name string) (string, error) {
+	sess, err := fsys.conn.Client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+
+	out, err := sess.Output("sha256sum -- " + shellQuote(name))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("unexpected sha256sum output %q", out)
+	}
+	return fields[0], nil
+}
+
+func (remoteFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (remoteFS) Base(name string) string {
+	return path.Base(name)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+
+	// sftp files only read several packets at once in WriteTo
+	var err error
+	if wt, ok := r.(io.WriterTo); ok {
+		_, err = wt.WriteTo(h)
+	} else {
+		_, err = io.Copy(h, r)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// existingPolicy is what a transfer does with the files that already exist at its
+// destination.
+type existingPolicy int
+
+const (
+	failExisting existingPolicy = iota
+	overwriteExisting
+	skipExisting
+)
+
+// partialSuffix is appended to the name of files while they're transferred. They're
+// renamed once done, so that transfers interrupted midway resume from the end of them.
+const partialSuffix = ".flypart"
+
+// partialSourceSuffix is appended to the name of files for the partialSource of their
+// partial file, which is kept next to it until the transfer is done.
+const partialSourceSuffix = partialSuffix + ".src"
+
+// partialSource is the size and modification time of the source of a partial file. A
+// partial file is only resumed from when its source still has them.
+type partialSource struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type transferJob struct {
+	src   string
+	dst   string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+	// offset is the size of the partial file left by an earlier transfer.
+	offset int64
+}
+
+// transfer copies files and directory trees from one transferFS to another, Parallel
+// files at a time.
+type transfer struct {
+	Src       transferFS
+	Dst       transferFS
+	Existing  existingPolicy
+	Recursive bool
+	Parallel  int
+	// Verify compares the checksums of every file at both ends once transferred.
+	Verify bool
+	// Progress, if set, is where a progress bar is drawn.
+	Progress io.Writer
+
+	dirs    []string
+	jobs    []transferJob
+	skipped int
+	resumed int
+
+	total     int64
+	done      atomic.Int64
+	moved     atomic.Int64
+	filesDone atomic.Int64
+	started   time.Time
+}
+
+// plan lists the files to copy from src to dst. Like cp, src is copied into dst when
+// dst is an existing directory.
+func (t *transfer) plan(src, dst string) error {
+	info, err := t.Src.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() && !t.Recursive {
+		return fmt.Errorf("%s is a directory, use --recursive to copy it", src)
+	}
+
+	if dinfo, err := t.Dst.Stat(dst); err == nil && dinfo.IsDir() {
+		dst = t.Dst.Join(dst, t.Src.Base(src))
+	}
+
+	if info.IsDir() {
+		return t.planDir(src, dst)
+	}
+	return t.planFile(src, dst, info)
+}
+
+func (t *transfer) planDir(src, dst string) error {
+	t.dirs = append(t.dirs, dst)
+
+	infos, err := t.Src.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		s, d := t.Src.Join(src, info.Name()), t.Dst.Join(dst, info.Name())
+
+		switch {
+		case info.IsDir():
+			err = t.planDir(s, d)
+		case info.Mode().IsRegular() && !isPartialName(info.Name()):
+			err = t.planFile(s, d, info)
+		default:
+			terminal.Debugf("skipping %s, not a regular file\n", s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *transfer) planFile(src, dst string, info fs.FileInfo) error {
+	switch _, err := t.Dst.Stat(dst); {
+	case err == nil && t.Existing == skipExisting:
+		t.skipped++
+		return nil
+	case err == nil && t.Existing == failExisting:
+		return fmt.Errorf("%s already exists, use --overwrite or --skip-existing", dst)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	job := transferJob{
+		src:   src,
+		dst:   dst,
+		size:  info.Size(),
+		mode:  info.Mode().Perm(),
+		mtime: info.ModTime(),
+	}
+
+	// partial files of another version of the source, or larger than it, start over
+	if part, err := t.Dst.Stat(dst + partialSuffix); err == nil && part.Size() <= job.size && t.samePartialSource(job) {
+		job.offset = part.Size()
+		t.resumed++
+	}
+
+	t.jobs = append(t.jobs, job)
+	t.total += job.size
+	t.done.Add(job.offset)
+	return nil
+}
+
+// run copies the planned files. Files copied before an error are kept, as are the
+// partial ones, for the next attempt to resume.
+func (t *transfer) run(ctx context.Context) error {
+	for _, dir := range t.dirs {
+		if err := t.Dst.MkdirAll(dir); err != nil {
+			return fmt.Errorf("create directory %s: %w", dir, err)
+		}
+	}
+
+	t.started = time.Now()
+	stop := t.drawProgress()
+	defer stop()
+
+	g, ctx := errgroup.WithContext(ctx)
+	if t.Parallel > 0 {
+		g.SetLimit(t.Parallel)
+	}
+
+	for _, job := range t.jobs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		job := job
+		g.Go(func() error {
+			return t.copyFile(ctx, job)
+		})
+	}
+	return g.Wait()
+}
+
+func (t *transfer) copyFile(ctx context.Context, job transferJob) error {
+	part := job.dst + partialSuffix
+
+	if err := t.copyPartial(ctx, job, part); err != nil {
+		return fmt.Errorf("copy %s: %w", job.src, err)
+	}
+
+	if t.Verify {
+		if err := t.verify(job.src, part); err != nil {
+			// resuming a corrupt file would only fail again
+			t.Dst.Remove(part)
+			t.Dst.Remove(job.dst + partialSourceSuffix)
+			return fmt.Errorf("verify %s: %w", job.src, err)
+		}
+	}
+
+	if err := t.Dst.Rename(part, job.dst); err != nil {
+		return fmt.Errorf("rename %s: %w", part, err)
+	}
+	if err := t.Dst.Remove(job.dst + partialSourceSuffix); err != nil {
+		terminal.Debugf("failed removing %s: %v\n", job.dst+partialSourceSuffix, err)
+	}
+	if err := t.Dst.Chmod(job.dst, job.mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", job.dst, err)
+	}
+	if err := t.Dst.Chtimes(job.dst, time.Now(), job.mtime); err != nil {
+		return fmt.Errorf("chtimes %s: %w", job.dst, err)
+	}
+
+	t.filesDone.Add(1)
+	return nil
+}
+
+// copyPartial copies the source of job to part, from its offset.
+func (t *transfer) copyPartial(ctx context.Context, job transferJob, part string) error {
+	src, err := t.Src.Open(job.src)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if job.offset == 0 {
+		flag |= os.O_TRUNC
+		if err := t.writePartialSource(job); err != nil {
+			return err
+		}
+	}
+
+	dst, err := t.Dst.OpenFile(part, flag)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if job.offset > 0 {
+		if _, err := src.Seek(job.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(job.offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	switch s := src.(type) {
+	case *sftp.File:
+		// sftp files only read several packets at once in WriteTo
+		_, err = s.WriteTo(progressWriter{ctx, dst, t})
+	default:
+		// sftp files are written in order by ReadFrom, so that partial files have no holes
+		_, err = io.Copy(dst, &io.LimitedReader{R: progressReader{ctx, src, t}, N: job.size - job.offset})
+	}
+	if err != nil {
+		return err
+	}
+
+	return dst.Close()
+}
+
+// isPartialName reports whether name is the one of a partial file or of its partialSource.
+func isPartialName(name string) bool {
+	return strings.HasSuffix(name, partialSuffix) || strings.HasSuffix(name, partialSourceSuffix)
+}
+
+// writePartialSource records the source of job next to its partial file.
+func (t *transfer) writePartialSource(job transferJob) error {
+	data, err := json.Marshal(partialSource{Size: job.size, ModTime: job.mtime})
+	if err != nil {
+		return err
+	}
+
+	f, err := t.Dst.OpenFile(job.dst+partialSourceSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// samePartialSource reports whether the partial file of job was copied from the same
+// source, going by the partialSource recorded next to it.
+func (t *transfer) samePartialSource(job transferJob) bool {
+	f, err := t.Dst.Open(job.dst + partialSourceSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var source partialSource
+	if err := json.NewDecoder(f).Decode(&source); err != nil {
+		return false
+	}
+	return source.Size == job.size && source.ModTime.Equal(job.mtime)
+}
+
+// verify compares the checksums of src and its copy, dst.
+func (t *transfer) verify(src, dst string) error {
+	var (
+		wg             sync.WaitGroup
+		srcSum, dstSum string
+		srcErr, dstErr error
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srcSum, srcErr = t.Src.Checksum(src)
+	}()
+	dstSum, dstErr = t.Dst.Checksum(dst)
+	wg.Wait()
+
+	switch {
+	case srcErr != nil:
+		return srcErr
+	case dstErr != nil:
+		return dstErr
+	case srcSum != dstSum:
+		return fmt.Errorf("checksum mismatch, %s at the source but %s once copied", srcSum, dstSum)
+	}
+	return nil
+}
+
+func (t *transfer) add(n int) {
+	t.done.Add(int64(n))
+	t.moved.Add(int64(n))
+}
+
+// progressWriter counts the bytes written by a transfer, and stops it once ctx is done.
+type progressWriter struct {
+	ctx context.Context
+	w   io.Writer
+	t   *transfer
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := w.w.Write(p)
+	w.t.add(n)
+	return n, err
+}
+
+// progressReader counts the bytes read by a transfer, and stops it once ctx is done.
+type progressReader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *transfer
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	r.t.add(n)
+	return n, err
+}
+
+// rate returns the throughput of the transfer so far, in bytes per second. Bytes of
+// resumed files copied by earlier transfers don't count.
+func (t *transfer) rate() float64 {
+	elapsed := time.Since(t.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(t.moved.Load()) / elapsed
+}
+
+const progressBarWidth = 30
+
+func (t *transfer) progressLine() string {
+	done := t.done.Load()
+
+	ratio := 1.0
+	if t.total > 0 {
+		ratio = float64(done) / float64(t.total)
+	}
+	filled := int(ratio * progressBarWidth)
+
+	return fmt.Sprintf("[%s%s] %3.0f%% %s/%s %s/s %d/%d files",
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		ratio*100,
+		humanize.Bytes(uint64(done)), humanize.Bytes(uint64(t.total)),
+		humanize.Bytes(uint64(t.rate())),
+		t.filesDone.Load(), len(t.jobs),
+	)
+}
+
+// drawProgress redraws the progress bar until the returned func is called.
+func (t *transfer) drawProgress() func() {
+	if t.Progress == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			fmt.Fprintf(t.Progress, "\r%s\033[K", t.progressLine())
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				fmt.Fprintln(t.Progress)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// summary describes the transfer once done.
+func (t *transfer) summary() string {
+	elapsed := time.Since(t.started).Round(time.Millisecond)
+
+	s := fmt.Sprintf("%d files, %s copied in %s (%s/s)",
+		t.filesDone.Load(), humanize.Bytes(uint64(t.moved.Load())), elapsed, humanize.Bytes(uint64(t.rate())))
+	if t.resumed > 0 {
+		s += fmt.Sprintf(", %d resumed", t.resumed)
+	}
+	if t.skipped > 0 {
+		s += fmt.Sprintf(", %d skipped", t.skipped)
+	}
+	return s
+}
@@ -0,0 +1,366 @@
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func assertFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, content, string(data), name)
+		}
+	}
+}
+
+// writePartialSource records src as the source of the partial file of dst, as an
+// interrupted transfer would.
+func writePartialSource(t *testing.T, src, dst string) {
+	t.Helper()
+
+	info, err := os.Stat(src)
+	require.NoError(t, err)
+	tr := &transfer{Dst: localFS{}}
+	require.NoError(t, tr.writePartialSource(transferJob{dst: dst, size: info.Size(), mtime: info.ModTime()}))
+}
+
+func runLocalTransfer(t *testing.T, tr *transfer, src, dst string) error {
+	t.Helper()
+
+	tr.Src, tr.Dst = localFS{}, localFS{}
+	if err := tr.plan(src, dst); err != nil {
+		return err
+	}
+	return tr.run(context.Background())
+}
+
+// newTestRemoteFS returns the local filesystem as the one of a VM, over an in-process SFTP
+// server, with the client set up like the one of transfers.
+func newTestRemoteFS(t *testing.T) remoteFS {
+	t.Helper()
+
+	return remoteFS{ftp: newTestSFTPClient(t, sftp.UseConcurrentReads(true))}
+}
+
+// interruptedFS is a localFS whose files cancel the transfer reading them once n bytes
+// were read.
+type interruptedFS struct {
+	localFS
+	cancel context.CancelFunc
+	n      int64
+}
+
+func (fsys interruptedFS) Open(name string) (transferFile, error) {
+	f, err := fsys.localFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &interruptedFile{f, fsys.cancel, fsys.n}, nil
+}
+
+type interruptedFile struct {
+	transferFile
+	cancel context.CancelFunc
+	left   int64
+}
+
+func (f *interruptedFile) Read(p []byte) (int, error) {
+	n, err := f.transferFile.Read(p)
+	if f.left -= int64(n); f.left <= 0 {
+		f.cancel()
+	}
+	return n, err
+}
+
+func TestTransferRecursive(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"a.txt":       "a",
+		"sub/b.txt":   strings.Repeat("b", 100000),
+		"sub/c/d.txt": "d",
+	}
+	writeFiles(t, src, files)
+	require.NoError(t, os.Chmod(filepath.Join(src, "a.txt"), 0600))
+
+	tr := &transfer{Recursive: true, Parallel: 2, Verify: true}
+	require.NoError(t, runLocalTransfer(t, tr, src, dst))
+
+	// copied into the existing destination directory, like cp
+	root := filepath.Join(dst, filepath.Base(src))
+	assertFiles(t, root, files)
+
+	info, err := os.Stat(filepath.Join(root, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	partials, err := filepath.Glob(filepath.Join(root, "*", "*.flypart*"))
+	require.NoError(t, err)
+	assert.Empty(t, partials)
+	assert.EqualValues(t, 3, tr.filesDone.Load())
+}
+
+func TestTransferDirectoryNeedsRecursive(t *testing.T) {
+	tr := &transfer{Parallel: 1}
+	err := runLocalTransfer(t, tr, t.TempDir(), t.TempDir())
+	assert.ErrorContains(t, err, "--recursive")
+}
+
+func TestTransferResumes(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+
+	content := strings.Repeat("0123456789", 1000)
+	writeFiles(t, dir, map[string]string{
+		"src":                 content,
+		"dst" + partialSuffix: content[:4000],
+	})
+	writePartialSource(t, src, dst)
+
+	tr := &transfer{Parallel: 1, Verify: true}
+	require.NoError(t, runLocalTransfer(t, tr, src, dst))
+
+	assertFiles(t, dir, map[string]string{"dst": content})
+	assert.Equal(t, 1, tr.resumed)
+	assert.EqualValues(t, len(content)-4000, tr.moved.Load())
+	assert.NoFileExists(t, dst+partialSuffix)
+	assert.NoFileExists(t, dst+partialSourceSuffix)
+}
+
+func TestTransferRestartsChangedSource(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+
+	writeFiles(t, dir, map[string]string{"src": "old content"})
+	writePartialSource(t, src, dst)
+	writeFiles(t, dir, map[string]string{
+		"src":                 "new content, longer",
+		"dst" + partialSuffix: "old",
+	})
+	require.NoError(t, os.Chtimes(src, time.Now(), time.Now().Add(time.Hour)))
+
+	// the partial file isn't larger than the source, but it was copied from another version
+	// of it, and resuming would mix both without --verify to notice
+	tr := &transfer{Parallel: 1}
+	require.NoError(t, runLocalTransfer(t, tr, src, dst))
+	assertFiles(t, dir, map[string]string{"dst": "new content, longer"})
+	assert.Equal(t, 0, tr.resumed)
+
+	// partial files without a recorded source start over too
+	writeFiles(t, dir, map[string]string{"dst" + partialSuffix: "new"})
+	require.NoError(t, os.Remove(dst))
+	tr = &transfer{Parallel: 1}
+	require.NoError(t, runLocalTransfer(t, tr, src, dst))
+	assert.Equal(t, 0, tr.resumed)
+	assertFiles(t, dir, map[string]string{"dst": "new content, longer"})
+}
+
+func TestTransferRejectsCorruptResume(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+
+	writeFiles(t, dir, map[string]string{
+		"src":                 "the real content",
+		"dst" + partialSuffix: "not the",
+	})
+	writePartialSource(t, src, dst)
+
+	tr := &transfer{Parallel: 1, Verify: true}
+	assert.ErrorContains(t, runLocalTransfer(t, tr, src, dst), "checksum mismatch")
+	assert.NoFileExists(t, dst)
+	assert.NoFileExists(t, dst+partialSuffix)
+	assert.NoFileExists(t, dst+partialSourceSuffix)
+
+	// and starts over the next time
+	tr = &transfer{Parallel: 1, Verify: true}
+	require.NoError(t, runLocalTransfer(t, tr, src, dst))
+	assertFiles(t, dir, map[string]string{"dst": "the real content"})
+}
+
+func TestTransferExistingPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   existingPolicy
+		err      string
+		expected string
+	}{
+		{name: "fail", policy: failExisting, err: "already exists", expected: "old"},
+		{name: "overwrite", policy: overwriteExisting, expected: "new"},
+		{name: "skip", policy: skipExisting, expected: "old"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"src": "new", "dst": "old"})
+
+			tr := &transfer{Existing: tc.policy, Parallel: 1, Verify: true}
+			err := runLocalTransfer(t, tr, filepath.Join(dir, "src"), filepath.Join(dir, "dst"))
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assertFiles(t, dir, map[string]string{"dst": tc.expected})
+		})
+	}
+}
+
+func TestTransferGetResumes(t *testing.T) {
+	remote, local := t.TempDir(), t.TempDir()
+	src, dst := filepath.Join(remote, "src"), filepath.Join(local, "dst")
+
+	content := strings.Repeat("0123456789", 100000)
+	writeFiles(t, remote, map[string]string{"src": content})
+	writeFiles(t, local, map[string]string{"dst" + partialSuffix: content[:40000]})
+	// SFTP only has modification times to the second
+	mtime := time.Now().Truncate(time.Second)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+	writePartialSource(t, src, dst)
+
+	// remote files are read with WriteTo, from the end of the partial file
+	tr := &transfer{Src: newTestRemoteFS(t), Dst: localFS{}, Parallel: 1, Verify: true}
+	require.NoError(t, tr.plan(src, dst))
+	require.NoError(t, tr.run(context.Background()))
+
+	assertFiles(t, local, map[string]string{"dst": content})
+	assert.Equal(t, 1, tr.resumed)
+	assert.EqualValues(t, len(content)-40000, tr.moved.Load())
+	assert.NoFileExists(t, dst+partialSuffix)
+	assert.NoFileExists(t, dst+partialSourceSuffix)
+}
+
+func TestTransferPutResumes(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	src, dst := filepath.Join(local, "src"), filepath.Join(remote, "dst")
+
+	content := strings.Repeat("0123456789", 100000)
+	writeFiles(t, local, map[string]string{"src": content})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := &transfer{Src: interruptedFS{cancel: cancel, n: 100000}, Dst: newTestRemoteFS(t), Parallel: 1, Verify: true}
+	require.NoError(t, tr.plan(src, dst))
+	require.ErrorIs(t, tr.run(ctx), context.Canceled)
+
+	// the partial file is written in order, so that it has no holes to resume after
+	part, err := os.ReadFile(dst + partialSuffix)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(part), 100000)
+	require.Less(t, len(part), len(content))
+	assert.Equal(t, content[:len(part)], string(part))
+
+	tr = &transfer{Src: localFS{}, Dst: newTestRemoteFS(t), Parallel: 1, Verify: true}
+	require.NoError(t, tr.plan(src, dst))
+	require.NoError(t, tr.run(context.Background()))
+
+	assertFiles(t, remote, map[string]string{"dst": content})
+	assert.Equal(t, 1, tr.resumed)
+	assert.EqualValues(t, len(content)-len(part), tr.moved.Load())
+	assert.NoFileExists(t, dst+partialSuffix)
+	assert.NoFileExists(t, dst+partialSourceSuffix)
+}
+
+func TestTransferRemoteOverwrites(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		remote func(fsys remoteFS) (transferFS, transferFS)
+	}{
+		{name: "get", remote: func(fsys remoteFS) (transferFS, transferFS) { return fsys, localFS{} }},
+		{name: "put", remote: func(fsys remoteFS) (transferFS, transferFS) { return localFS{}, fsys }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"src": "new", "dst": "old"})
+
+			tr := &transfer{Existing: overwriteExisting, Parallel: 1, Verify: true}
+			tr.Src, tr.Dst = tc.remote(newTestRemoteFS(t))
+			require.NoError(t, tr.plan(filepath.Join(dir, "src"), filepath.Join(dir, "dst")))
+			require.NoError(t, tr.run(context.Background()))
+			assertFiles(t, dir, map[string]string{"dst": "new"})
+		})
+	}
+}
+
+// plainRenameCmder hides the PosixRename of a FileCmder, for the SFTP server to rename
+// like plain SFTP does, failing when the new name exists.
+type plainRenameCmder struct {
+	sftp.FileCmder
+}
+
+func TestRemoteFSRenameOverExistingFile(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	handlers := sftp.InMemHandler()
+	handlers.FileCmd = plainRenameCmder{handlers.FileCmd}
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, handlers)
+	go server.Serve()
+
+	ftp, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	defer func() {
+		server.Close()
+		ftp.Close()
+	}()
+
+	for name, content := range map[string]string{"/a": "new", "/b": "old"} {
+		f, err := ftp.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	require.Error(t, ftp.PosixRename("/a", "/b"))
+
+	require.NoError(t, remoteFS{ftp: ftp}.Rename("/a", "/b"))
+	_, err = ftp.Stat("/a")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	f, err := ftp.Open("/b")
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+}
+
+func TestRemoteFSChecksumReadsTheFileBack(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 100000)
+	writeFiles(t, dir, map[string]string{"file": content})
+
+	// without an SSH connection to run sha256sum over
+	sum, err := newTestRemoteFS(t).Checksum(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	want := sha256.Sum256([]byte(content))
+	assert.Equal(t, hex.EncodeToString(want[:]), sum)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'/data/it'\''s here'`, shellQuote("/data/it's here"))
+}
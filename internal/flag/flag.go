@@ -310,6 +310,14 @@ func GetDetach(ctx context.Context) bool {
 	return GetBool(ctx, detachName)
 }
 
+// WaitForLeases returns a boolean flag for waiting on machine leases held by someone else
+func WaitForLeases() Bool {
+	return Bool{
+		Name:        "wait-for-leases",
+		Description: "Wait for machine leases held by someone else to be released or to expire instead of failing",
+	}
+}
+
 const buildOnlyName = "build-only"
 
 // BuildOnly returns a boolean flag for building without a deployment
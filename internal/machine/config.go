@@ -72,6 +72,12 @@ var cmpOptions = cmp.Options{
 			})),
 }
 
+// ConfigCompare returns a diff between two machine configs, colorized when the
+// IOStreams in ctx has colors enabled. It returns an empty string if they are equal.
+func ConfigCompare(ctx context.Context, original api.MachineConfig, new api.MachineConfig) string {
+	return configCompare(ctx, original, new)
+}
+
 func configCompare(ctx context.Context, original api.MachineConfig, new api.MachineConfig) string {
 	io := iostreams.FromContext(ctx)
 	colorize := io.ColorScheme()
@@ -94,8 +94,22 @@ func (lm *leasableMachine) FormattedMachineId() string {
 	return fmt.Sprintf("%s [%s]", res, procGroup)
 }
 
-func (lm *leasableMachine) logClearLinesAbove(count int) {
-	if lm.io.IsInteractive() {
+type noRedrawKey struct{}
+
+// WithoutRedraw returns a copy of ctx in which machines print every status change on a new line
+// instead of redrawing the last one, for when several of them report their progress at once.
+func WithoutRedraw(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRedrawKey{}, true)
+}
+
+// CanRedraw reports whether status lines can be redrawn in place with the io streams and ctx.
+func CanRedraw(ctx context.Context, io *iostreams.IOStreams) bool {
+	noRedraw, _ := ctx.Value(noRedrawKey{}).(bool)
+	return io.IsInteractive() && !noRedraw
+}
+
+func (lm *leasableMachine) logClearLinesAbove(ctx context.Context, count int) {
+	if CanRedraw(ctx, lm.io) {
 		builder := aec.EmptyBuilder
 		str := builder.Up(uint(count)).EraseLine(aec.EraseModes.All).ANSI
 		fmt.Fprint(lm.io.ErrOut, str.String())
@@ -162,7 +176,7 @@ func (lm *leasableMachine) WaitForState(ctx context.Context, desiredState string
 		Factor: 2,
 		Jitter: true,
 	}
-	lm.logClearLinesAbove(1)
+	lm.logClearLinesAbove(ctx, 1)
 	lm.logStatusWaiting(desiredState, logPrefix)
 	for {
 		err := lm.flapsClient.Wait(waitCtx, lm.Machine(), desiredState, timeout)
@@ -184,7 +198,7 @@ func (lm *leasableMachine) WaitForState(ctx context.Context, desiredState string
 			time.Sleep(b.Duration())
 			continue
 		}
-		lm.logClearLinesAbove(1)
+		lm.logClearLinesAbove(ctx, 1)
 		lm.logStatusFinished(desiredState)
 		return nil
 	}
@@ -225,15 +239,15 @@ func (lm *leasableMachine) WaitForHealthchecksToPass(ctx context.Context, timeou
 		case err != nil:
 			return fmt.Errorf("error getting machine %s from api: %w", lm.Machine().ID, err)
 		case !updateMachine.HealthCheckStatus().AllPassing():
-			if !printedFirst || lm.io.IsInteractive() {
-				lm.logClearLinesAbove(1)
+			if !printedFirst || CanRedraw(ctx, lm.io) {
+				lm.logClearLinesAbove(ctx, 1)
 				lm.logHealthCheckStatus(updateMachine.HealthCheckStatus(), logPrefix)
 				printedFirst = true
 			}
 			time.Sleep(b.Duration())
 			continue
 		}
-		lm.logClearLinesAbove(1)
+		lm.logClearLinesAbove(ctx, 1)
 		lm.logHealthCheckStatus(updateMachine.HealthCheckStatus(), logPrefix)
 		return nil
 	}
@@ -249,7 +263,7 @@ func (lm *leasableMachine) WaitForEventTypeAfterType(ctx context.Context, eventT
 		Factor: 2,
 		Jitter: true,
 	}
-	lm.logClearLinesAbove(1)
+	lm.logClearLinesAbove(ctx, 1)
 	fmt.Fprintf(lm.io.ErrOut, "  Waiting for %s to get %s event\n",
 		lm.colorize.Bold(lm.FormattedMachineId()),
 		lm.colorize.Yellow(eventType1),
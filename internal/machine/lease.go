@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/flaps"
@@ -25,7 +26,9 @@ func AcquireAllLeases(ctx context.Context) ([]*api.Machine, releaseLeasesFunc, e
 	return AcquireLeases(ctx, machines)
 }
 
-// AcquireLeases works to acquire/attach a lease for each machine specified.
+// AcquireLeases works to acquire/attach a lease for each machine specified. When a lease is held by
+// someone else and the context was created with WithWaitForLeases, the leases acquired so far are
+// released while waiting, since nothing refreshes them, and every lease is acquired again on retry.
 func AcquireLeases(ctx context.Context, machines []*api.Machine) ([]*api.Machine, releaseLeasesFunc, error) {
 	var (
 		flapsClient = flaps.FromContext(ctx)
@@ -42,16 +45,45 @@ func AcquireLeases(ctx context.Context, machines []*api.Machine) ([]*api.Machine
 		}
 	}
 
-	leaseHoldingMachines := []*api.Machine{}
-	for _, machine := range machines {
-		m, _, err := AcquireLease(ctx, machine)
-		if err != nil {
+	b := newLeaseWaitBackoff()
+	for {
+		leaseHoldingMachines := []*api.Machine{}
+		var (
+			holder *LeaseHolder
+			err    error
+		)
+		for _, machine := range machines {
+			var lease *api.MachineLease
+			lease, holder, err = tryAcquireLease(ctx, flapsClient, machine.ID)
+			if err != nil {
+				err = fmt.Errorf("failed to obtain lease: %w", err)
+				break
+			}
+			var m *api.Machine
+			m, err = refetchLeasedMachine(ctx, flapsClient, machine, lease)
+			if err != nil {
+				leaseHoldingMachines = append(leaseHoldingMachines, machine)
+				break
+			}
+			leaseHoldingMachines = append(leaseHoldingMachines, m)
+		}
+		if err == nil {
+			return leaseHoldingMachines, releaseFunc, nil
+		}
+		if holder == nil || !waitForLeases(ctx) {
 			return leaseHoldingMachines, releaseFunc, err
 		}
-		leaseHoldingMachines = append(leaseHoldingMachines, m)
-	}
 
-	return leaseHoldingMachines, releaseFunc, nil
+		releaseFunc(ctx, leaseHoldingMachines)
+		delay := b.Duration()
+		fmt.Fprintf(io.ErrOut, "Machine %s is leased by %s until %s, retrying in %s\n",
+			holder.MachineID, holder.displayOwner(), holder.ExpiresAt.Format(time.RFC3339), delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return nil, releaseFunc, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
 }
 
 // AcquireLease works to acquire/attach a lease for the specified machine.
@@ -70,22 +102,63 @@ func AcquireLease(ctx context.Context, machine *api.Machine) (*api.Machine, rele
 		}
 	}
 
-	lease, err := flapsClient.AcquireLease(ctx, machine.ID, api.IntPointer(120))
+	lease, err := acquireLeaseWaiting(ctx, flapsClient, io, machine.ID)
 	if err != nil {
 		return nil, releaseFunc, fmt.Errorf("failed to obtain lease: %w", err)
 	}
 
-	// Set lease nonce before we re-fetch the Machines latest configuration.
-	// This will ensure the lease can still be released in the event the upcoming GET fails.
+	machine, err = refetchLeasedMachine(ctx, flapsClient, machine, lease)
+	return machine, releaseFunc, err
+}
+
+// refetchLeasedMachine re-queries machine once its lease was acquired to ensure we are working against
+// the latest configuration. The lease nonce is set on machine first so the lease can still be released
+// in the event the GET fails.
+func refetchLeasedMachine(ctx context.Context, flapsClient *flaps.Client, machine *api.Machine, lease *api.MachineLease) (*api.Machine, error) {
 	machine.LeaseNonce = lease.Data.Nonce
 
-	// Re-query machine post-lease acquisition to ensure we are working against the latest configuration.
-	machine, err = flapsClient.Get(ctx, machine.ID)
+	machine, err := flapsClient.Get(ctx, machine.ID)
 	if err != nil {
-		return machine, releaseFunc, err
+		return machine, err
 	}
 
 	machine.LeaseNonce = lease.Data.Nonce
 
-	return machine, releaseFunc, nil
+	return machine, nil
+}
+
+// tryAcquireLease acquires the lease of machineID once. If someone else holds it, the holder is returned
+// along with an error naming it.
+func tryAcquireLease(ctx context.Context, flapsClient *flaps.Client, machineID string) (*api.MachineLease, *LeaseHolder, error) {
+	lease, err := flapsClient.AcquireLease(ctx, machineID, api.IntPointer(120))
+	if err == nil {
+		return lease, nil, nil
+	}
+
+	holder, findErr := findLeaseHolder(ctx, flapsClient, machineID)
+	if findErr != nil || holder == nil {
+		return nil, nil, err
+	}
+	return nil, holder, fmt.Errorf("%w; machine %s is leased by %s until %s", err, machineID, holder.displayOwner(), holder.ExpiresAt.Format(time.RFC3339))
+}
+
+// acquireLeaseWaiting acquires the lease of machineID. If someone else holds it, the error names the holder,
+// or, when the context was created with WithWaitForLeases, it is retried with backoff until it is released.
+func acquireLeaseWaiting(ctx context.Context, flapsClient *flaps.Client, io *iostreams.IOStreams, machineID string) (*api.MachineLease, error) {
+	b := newLeaseWaitBackoff()
+	for {
+		lease, holder, err := tryAcquireLease(ctx, flapsClient, machineID)
+		if holder == nil || !waitForLeases(ctx) {
+			return lease, err
+		}
+
+		delay := b.Duration()
+		fmt.Fprintf(io.ErrOut, "Machine %s is leased by %s until %s, retrying in %s\n",
+			machineID, holder.displayOwner(), holder.ExpiresAt.Format(time.RFC3339), delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
 }
@@ -0,0 +1,131 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/logger"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// fakeLeases serves the lease endpoints of the machines API from memory.
+type fakeLeases struct {
+	mu sync.Mutex
+	// heldBy lists machines leased by someone else and how many more acquisitions fail on them
+	heldBy map[string]int
+	leased map[string]bool
+	// events lists acquired and released leases in order, as "acquire m1" or "release m1"
+	events   []string
+	inFlight int
+	maxSeen  int
+}
+
+func newFakeLeases(t *testing.T) (*fakeLeases, context.Context) {
+	t.Helper()
+
+	f := &fakeLeases{heldBy: map[string]int{}, leased: map[string]bool{}}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+	ctx := logger.NewContext(context.Background(), logger.FromEnv(io.Discard))
+	client, err := flaps.NewFromAppName(ctx, "my-cool-app")
+	require.NoError(t, err)
+
+	ios, _, _, _ := iostreams.Test()
+	ctx = iostreams.NewContext(ctx, ios)
+	return f, flaps.NewContext(ctx, client)
+}
+
+func (f *fakeLeases) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/apps/my-cool-app/machines/")
+	id, action, _ := strings.Cut(path, "/")
+
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxSeen {
+		f.maxSeen = f.inFlight
+	}
+	f.mu.Unlock()
+	time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer func() { f.inFlight-- }()
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(&api.Machine{ID: id})
+	case action == "lease" && r.Method == http.MethodPost:
+		if f.heldBy[id] > 0 {
+			f.heldBy[id]--
+			http.Error(w, `{"error":"lease currently held"}`, http.StatusConflict)
+			return
+		}
+		f.leased[id] = true
+		f.events = append(f.events, "acquire "+id)
+		json.NewEncoder(w).Encode(&api.MachineLease{Status: "success", Data: &api.MachineLeaseData{Nonce: "nonce-" + id}})
+	case action == "lease" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(&api.MachineLease{
+			Data: &api.MachineLeaseData{Nonce: "other", Owner: "jane@example.com", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		})
+	case action == "lease" && r.Method == http.MethodDelete:
+		delete(f.leased, id)
+		f.events = append(f.events, "release "+id)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+	}
+}
+
+func TestAcquireLeases_WaitReleasesHeldLeases(t *testing.T) {
+	f, ctx := newFakeLeases(t)
+	f.heldBy["m2"] = 1
+
+	machines, release, err := AcquireLeases(WithWaitForLeases(ctx), []*api.Machine{{ID: "m1"}, {ID: "m2"}})
+	require.NoError(t, err)
+	assert.Len(t, machines, 2)
+	// The lease of m1 isn't kept unrefreshed while waiting for m2, it is acquired again
+	assert.Equal(t, []string{"acquire m1", "release m1", "acquire m1", "acquire m2"}, f.events)
+
+	release(ctx, machines)
+	assert.Empty(t, f.leased)
+}
+
+func TestAcquireLeases_HeldWithoutWaiting(t *testing.T) {
+	f, ctx := newFakeLeases(t)
+	f.heldBy["m2"] = 1
+
+	machines, release, err := AcquireLeases(ctx, []*api.Machine{{ID: "m1"}, {ID: "m2"}, {ID: "m3"}})
+	require.ErrorContains(t, err, "machine m2 is leased by jane@example.com")
+	assert.Equal(t, []string{"m1"}, []string{machines[0].ID})
+
+	release(ctx, machines)
+	assert.Empty(t, f.leased)
+}
+
+func TestMachineSetReleaseLeases_Bounded(t *testing.T) {
+	f, ctx := newFakeLeases(t)
+
+	var machines []*api.Machine
+	for i := 0; i < 3*maxConcurrentLeaseRequests; i++ {
+		machines = append(machines, &api.Machine{ID: fmt.Sprintf("m%d", i)})
+	}
+	set := NewMachineSet(flaps.FromContext(ctx), iostreams.FromContext(ctx), machines)
+	require.NoError(t, set.AcquireLeases(ctx, time.Minute))
+	require.NoError(t, set.ReleaseLeases(ctx))
+
+	assert.Empty(t, f.leased)
+	assert.LessOrEqual(t, f.maxSeen, maxConcurrentLeaseRequests)
+}
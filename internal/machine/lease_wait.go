@@ -0,0 +1,95 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/render"
+)
+
+type contextKeyWaitForLeases struct{}
+
+// WithWaitForLeases makes lease acquisition wait, retrying with backoff, for leases held by someone else
+// to be released or to expire instead of failing right away. It waits until ctx is done.
+func WithWaitForLeases(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyWaitForLeases{}, true)
+}
+
+func waitForLeases(ctx context.Context) bool {
+	wait, _ := ctx.Value(contextKeyWaitForLeases{}).(bool)
+	return wait
+}
+
+func newLeaseWaitBackoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    1 * time.Second,
+		Max:    30 * time.Second,
+		Factor: 2,
+		Jitter: true,
+	}
+}
+
+// LeaseHolder is the current holder of a lease that couldn't be acquired.
+type LeaseHolder struct {
+	MachineID string
+	Owner     string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+func (h LeaseHolder) displayOwner() string {
+	if h.Owner == "" {
+		return "unknown"
+	}
+	return h.Owner
+}
+
+// LeasesHeldError is returned when leases couldn't be acquired because someone else holds them.
+type LeasesHeldError struct {
+	Holders []LeaseHolder
+}
+
+func (e *LeasesHeldError) Error() string {
+	return fmt.Sprintf("error acquiring leases on all machines: %d machine(s) leased by someone else, retry once they are released or use --wait-for-leases", len(e.Holders))
+}
+
+// findLeaseHolder returns who holds the lease of machineID, or nil if it isn't leased.
+func findLeaseHolder(ctx context.Context, flapsClient *flaps.Client, machineID string) (*LeaseHolder, error) {
+	lease, err := flapsClient.FindLease(ctx, machineID)
+	if err != nil {
+		return nil, err
+	}
+	return LeaseHolderFrom(machineID, lease), nil
+}
+
+// LeaseHolderFrom returns who holds lease, the lease of machineID, or nil if it isn't held.
+func LeaseHolderFrom(machineID string, lease *api.MachineLease) *LeaseHolder {
+	if lease == nil || lease.Data == nil || lease.Data.Nonce == "" {
+		return nil
+	}
+	return &LeaseHolder{
+		MachineID: machineID,
+		Owner:     lease.Data.Owner,
+		Nonce:     lease.Data.Nonce,
+		ExpiresAt: time.Unix(lease.Data.ExpiresAt, 0),
+	}
+}
+
+// PrintLeaseHolders renders holders as a table with the time left until every lease expires.
+func PrintLeaseHolders(w io.Writer, title string, holders []LeaseHolder) error {
+	rows := make([][]string, 0, len(holders))
+	for _, h := range holders {
+		rows = append(rows, []string{
+			h.MachineID,
+			h.displayOwner(),
+			h.Nonce,
+			fmt.Sprintf("%s (in %s)", h.ExpiresAt.Format(time.RFC3339), time.Until(h.ExpiresAt).Round(time.Second)),
+		})
+	}
+	return render.Table(w, title, rows, "Machine", "Owner", "Nonce", "Expires")
+}
@@ -0,0 +1,48 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func TestLeaseHolderFrom(t *testing.T) {
+	assert.Nil(t, LeaseHolderFrom("m1", nil))
+	assert.Nil(t, LeaseHolderFrom("m1", &api.MachineLease{Status: "success"}))
+	assert.Nil(t, LeaseHolderFrom("m1", &api.MachineLease{Data: &api.MachineLeaseData{}}))
+
+	holder := LeaseHolderFrom("m1", &api.MachineLease{
+		Data: &api.MachineLeaseData{Nonce: "abc", Owner: "jane@example.com", ExpiresAt: 1700000000},
+	})
+	assert.Equal(t, &LeaseHolder{
+		MachineID: "m1",
+		Owner:     "jane@example.com",
+		Nonce:     "abc",
+		ExpiresAt: time.Unix(1700000000, 0),
+	}, holder)
+}
+
+func TestPrintLeaseHolders(t *testing.T) {
+	var buf bytes.Buffer
+	err := PrintLeaseHolders(&buf, "Machines leased by someone else", []LeaseHolder{
+		{MachineID: "m1", Owner: "jane@example.com", Nonce: "abc", ExpiresAt: time.Now().Add(time.Minute)},
+		{MachineID: "m2", Nonce: "def", ExpiresAt: time.Now().Add(time.Minute)},
+	})
+	require.NoError(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "Machines leased by someone else")
+	assert.Contains(t, out, "jane@example.com")
+	assert.Contains(t, out, "unknown")
+	assert.Contains(t, out, "def")
+}
+
+func TestWithWaitForLeases(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, waitForLeases(ctx))
+	assert.True(t, waitForLeases(WithWaitForLeases(ctx)))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/terminal"
+	"golang.org/x/exp/slices"
 )
 
 type MachineSet interface {
@@ -22,8 +23,13 @@ type MachineSet interface {
 	GetMachines() []LeasableMachine
 }
 
+// maxConcurrentLeaseRequests bounds how many lease requests are sent at once
+const maxConcurrentLeaseRequests = 10
+
 type machineSet struct {
-	machines []LeasableMachine
+	flapsClient *flaps.Client
+	io          *iostreams.IOStreams
+	machines    []LeasableMachine
 }
 
 func NewMachineSet(flapsClient *flaps.Client, io *iostreams.IOStreams, machines []*api.Machine) MachineSet {
@@ -32,7 +38,9 @@ func NewMachineSet(flapsClient *flaps.Client, io *iostreams.IOStreams, machines
 		leaseMachines = append(leaseMachines, NewLeasableMachine(flapsClient, io, m))
 	}
 	return &machineSet{
-		machines: leaseMachines,
+		flapsClient: flapsClient,
+		io:          io,
+		machines:    leaseMachines,
 	}
 }
 
@@ -44,38 +52,104 @@ func (ms *machineSet) GetMachines() []LeasableMachine {
 	return ms.machines
 }
 
+// AcquireLeases acquires the lease of every machine in the set or none at all. Leases held by someone
+// else are listed with their holder, and waited for when the context was created with WithWaitForLeases.
 func (ms *machineSet) AcquireLeases(ctx context.Context, duration time.Duration) error {
 	if len(ms.machines) == 0 {
 		return nil
 	}
 
-	results := make(chan error, len(ms.machines))
+	b := newLeaseWaitBackoff()
+	for {
+		holders, hadError := ms.acquireLeases(ctx, ms.machines, duration)
+		if !hadError && len(holders) == 0 {
+			return nil
+		}
+
+		if hadError || !waitForLeases(ctx) {
+			if len(holders) > 0 {
+				if err := PrintLeaseHolders(ms.io.ErrOut, "Machines leased by someone else", holders); err != nil {
+					terminal.Warnf("failed to list lease holders: %v\n", err)
+				}
+			}
+			if err := ms.ReleaseLeases(ctx); err != nil {
+				terminal.Warnf("error releasing machine leases: %v\n", err)
+			}
+			if !hadError {
+				return &LeasesHeldError{Holders: holders}
+			}
+			return fmt.Errorf("error acquiring leases on all machines")
+		}
+
+		// Nothing refreshes the leases acquired so far, and they would expire while waiting,
+		// so they are released and every lease is acquired again on the next try
+		if err := ms.ReleaseLeases(ctx); err != nil {
+			terminal.Warnf("error releasing machine leases: %v\n", err)
+		}
+
+		delay := b.Duration()
+		title := fmt.Sprintf("Waiting for %d lease(s) held by someone else, retrying in %s", len(holders), delay.Round(time.Second))
+		if err := PrintLeaseHolders(ms.io.ErrOut, title, holders); err != nil {
+			terminal.Warnf("failed to list lease holders: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// acquireLeases tries to acquire the leases of machines, at most maxConcurrentLeaseRequests at a time.
+// It returns the holders of the leases held by someone else, and whether any other error happened.
+func (ms *machineSet) acquireLeases(ctx context.Context, machines []LeasableMachine, duration time.Duration) ([]LeaseHolder, bool) {
+	type result struct {
+		holder *LeaseHolder
+		err    error
+	}
+
+	results := make(chan result, len(machines))
+	sem := make(chan struct{}, maxConcurrentLeaseRequests)
 	var wg sync.WaitGroup
-	for _, m := range ms.machines {
+	for _, m := range machines {
+		sem <- struct{}{}
 		wg.Add(1)
 		go func(m LeasableMachine) {
 			defer wg.Done()
-			results <- m.AcquireLease(ctx, duration)
+			defer func() { <-sem }()
+
+			err := m.AcquireLease(ctx, duration)
+			if err == nil {
+				results <- result{}
+				return
+			}
+			// Tell apart leases held by someone else from other failures
+			holder, findErr := findLeaseHolder(ctx, ms.flapsClient, m.Machine().ID)
+			if findErr != nil || holder == nil {
+				results <- result{err: err}
+				return
+			}
+			results <- result{holder: holder}
 		}(m)
 	}
 	go func() {
 		wg.Wait()
 		close(results)
 	}()
+
+	var holders []LeaseHolder
 	hadError := false
-	for err := range results {
-		if err != nil {
+	for r := range results {
+		switch {
+		case r.err != nil:
 			hadError = true
-			terminal.Warnf("failed to acquire lease: %v\n", err)
-		}
-	}
-	if hadError {
-		if err := ms.ReleaseLeases(ctx); err != nil {
-			terminal.Warnf("error releasing machine leases: %v\n", err)
+			terminal.Warnf("failed to acquire lease: %v\n", r.err)
+		case r.holder != nil:
+			holders = append(holders, *r.holder)
 		}
-		return fmt.Errorf("error acquiring leases on all machines")
 	}
-	return nil
+	slices.SortFunc(holders, func(a, b LeaseHolder) bool { return a.MachineID < b.MachineID })
+	return holders, hadError
 }
 
 func (ms *machineSet) RemoveMachines(ctx context.Context, machines []LeasableMachine) error {
@@ -119,11 +193,14 @@ func (ms *machineSet) ReleaseLeases(ctx context.Context) error {
 	}
 
 	results := make(chan error, len(ms.machines))
+	sem := make(chan struct{}, maxConcurrentLeaseRequests)
 	var wg sync.WaitGroup
 	for _, m := range ms.machines {
+		sem <- struct{}{}
 		wg.Add(1)
 		go func(m LeasableMachine) {
 			defer wg.Done()
+			defer func() { <-sem }()
 			results <- m.ReleaseLease(ctx)
 		}(m)
 	}
@@ -2,8 +2,12 @@ package render
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/logrusorgru/aurora"
@@ -17,6 +21,10 @@ type LogOptions struct {
 	RemoveNewlines bool
 	HideRegion     bool
 	HideAllocID    bool
+	// AppWidth is the width of the app name column, which is hidden when 0.
+	AppWidth int
+	// Fields lists the fields to show, all of them when empty. See LogFields.
+	Fields []string
 }
 
 // LogOption is a func type that returns a LogOption.
@@ -43,6 +51,135 @@ func HideAllocID() LogOption {
 	}
 }
 
+// ShowApp prefixes the text log output with the app name, padded to width so that
+// the logs of several apps line up.
+func ShowApp(width int) LogOption {
+	return func(o *LogOptions) {
+		o.AppWidth = width
+	}
+}
+
+// Fields only shows the given fields in the log output. See LogFields.
+func Fields(fields ...string) LogOption {
+	return func(o *LogOptions) {
+		o.Fields = append(o.Fields, fields...)
+	}
+}
+
+func (o *LogOptions) show(field string) bool {
+	if len(o.Fields) == 0 {
+		return true
+	}
+	for _, f := range o.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// LogFields are the names of the fields of a log entry, in the order they are output.
+var LogFields = []string{
+	"app",
+	"timestamp",
+	"provider",
+	"instance",
+	"region",
+	"level",
+	"error.code",
+	"error.message",
+	"request.method",
+	"request.url",
+	"request.id",
+	"response.status",
+	"message",
+}
+
+// logFieldValues returns the values of the LogFields of entry.
+func logFieldValues(entry logs.LogEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"app":             entry.App,
+		"timestamp":       entry.Timestamp,
+		"provider":        entry.Meta.Event.Provider,
+		"instance":        entry.Instance,
+		"region":          entry.Region,
+		"level":           entry.Level,
+		"error.code":      entry.Meta.Error.Code,
+		"error.message":   entry.Meta.Error.Message,
+		"request.method":  entry.Meta.HTTP.Request.Method,
+		"request.url":     entry.Meta.URL.Full,
+		"request.id":      entry.Meta.HTTP.Request.ID,
+		"response.status": entry.Meta.HTTP.Response.StatusCode,
+		"message":         entry.Message,
+	}
+}
+
+// LogFormats are the log formats supported by NewLogFormatter besides templates.
+var LogFormats = []string{"text", "json", "ndjson", "logfmt", "raw"}
+
+// LogFormatter writes a log entry to w.
+type LogFormatter func(w io.Writer, entry logs.LogEntry) error
+
+// NewLogFormatter returns the LogFormatter of format, one of LogFormats or a Go
+// text/template executed with each logs.LogEntry, like '{{.Timestamp}} {{.Message}}'.
+// Field selection applies to the text, ndjson and logfmt formats.
+func NewLogFormatter(format string, opts ...LogOption) (LogFormatter, error) {
+	options := &LogOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	for _, field := range options.Fields {
+		if !isLogField(field) {
+			return nil, fmt.Errorf("unknown log field %q, expected one of %s", field, strings.Join(LogFields, ", "))
+		}
+	}
+
+	switch format {
+	case "", "text":
+		return func(w io.Writer, entry logs.LogEntry) error {
+			return LogEntry(w, entry, opts...)
+		}, nil
+	case "json":
+		return func(w io.Writer, entry logs.LogEntry) error {
+			return JSON(w, entry)
+		}, nil
+	case "ndjson":
+		return func(w io.Writer, entry logs.LogEntry) error {
+			return LogEntryNDJSON(w, entry, opts...)
+		}, nil
+	case "logfmt":
+		return func(w io.Writer, entry logs.LogEntry) error {
+			return LogEntryLogfmt(w, entry, opts...)
+		}, nil
+	case "raw":
+		return LogEntryRaw, nil
+	}
+
+	if !strings.Contains(format, "{{") {
+		return nil, fmt.Errorf("unknown log format %q, expected one of %s or a Go template", format, strings.Join(LogFormats, ", "))
+	}
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	tmpl, err := template.New("log").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log format template: %w", err)
+	}
+	return func(w io.Writer, entry logs.LogEntry) error {
+		return tmpl.Execute(w, entry)
+	}, nil
+}
+
+func isLogField(field string) bool {
+	for _, f := range LogFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
 func LogEntry(w io.Writer, entry logs.LogEntry, opts ...LogOption) (err error) {
 	options := &LogOptions{}
 	for _, opt := range opts {
@@ -57,45 +194,51 @@ func LogEntry(w io.Writer, entry logs.LogEntry, opts ...LogOption) (err error) {
 	}
 
 	if !options.HideAllocID {
-		if entry.Meta.Event.Provider != "" {
-			if entry.Instance != "" {
-				fmt.Fprintf(w, "%s[%s]", entry.Meta.Event.Provider, entry.Instance)
-			} else {
-				fmt.Fprint(w, entry.Meta.Event.Provider)
-			}
-		} else if entry.Instance != "" {
-			fmt.Fprintf(w, "%s", entry.Instance)
+		if source := logSource(entry, options); source != "" {
+			fmt.Fprint(w, source)
 		}
 		fmt.Fprint(w, " ")
 	}
 
-	if !options.HideRegion {
+	if !options.HideRegion && options.show("region") {
 		fmt.Fprintf(w, "%s ", aurora.Green(entry.Region))
 	}
 
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "%s ", aurora.Faint(format.Time(ts)))
-
-	if entry.Meta.Event.Provider != "" {
-		if entry.Instance != "" {
-			fmt.Fprintf(&buf, "%s[%s]", entry.Meta.Event.Provider, entry.Instance)
-		} else {
-			fmt.Fprint(&buf, entry.Meta.Event.Provider)
-		}
-	} else if entry.Instance != "" {
-		fmt.Fprintf(&buf, "%s", entry.Instance)
+	if options.AppWidth > 0 && options.show("app") {
+		fmt.Fprintf(&buf, "%s ", aurora.Magenta(fmt.Sprintf("%-*s", options.AppWidth, entry.App)))
+	}
+	if options.show("timestamp") {
+		fmt.Fprintf(&buf, "%s ", aurora.Faint(format.Time(ts)))
 	}
 
-	fmt.Fprintf(&buf, " %s [%s]", aurora.Green(entry.Region), aurora.Colorize(entry.Level, levelColor(entry.Level)))
+	buf.WriteString(logSource(entry, options))
+
+	if options.show("region") {
+		fmt.Fprintf(&buf, " %s", aurora.Green(entry.Region))
+	}
+	if options.show("level") {
+		fmt.Fprintf(&buf, " [%s]", aurora.Colorize(entry.Level, levelColor(entry.Level)))
+	}
 
-	printFieldIfPresent(&buf, "error.code", entry.Meta.Error.Code)
-	hadErrorMsg := printFieldIfPresent(w, "error.message", entry.Meta.Error.Message)
-	printFieldIfPresent(&buf, "request.method", entry.Meta.HTTP.Request.Method)
-	printFieldIfPresent(&buf, "request.url", entry.Meta.URL.Full)
-	printFieldIfPresent(&buf, "request.id", entry.Meta.HTTP.Request.ID)
-	printFieldIfPresent(&buf, "response.status", entry.Meta.HTTP.Response.StatusCode)
+	if options.show("error.code") {
+		printFieldIfPresent(&buf, "error.code", entry.Meta.Error.Code)
+	}
+	hadErrorMsg := options.show("error.message") && printFieldIfPresent(w, "error.message", entry.Meta.Error.Message)
+	if options.show("request.method") {
+		printFieldIfPresent(&buf, "request.method", entry.Meta.HTTP.Request.Method)
+	}
+	if options.show("request.url") {
+		printFieldIfPresent(&buf, "request.url", entry.Meta.URL.Full)
+	}
+	if options.show("request.id") {
+		printFieldIfPresent(&buf, "request.id", entry.Meta.HTTP.Request.ID)
+	}
+	if options.show("response.status") {
+		printFieldIfPresent(&buf, "response.status", entry.Meta.HTTP.Response.StatusCode)
+	}
 
-	if !hadErrorMsg {
+	if !hadErrorMsg && options.show("message") {
 		buf.Write([]byte(entry.Message))
 	}
 
@@ -105,6 +248,103 @@ func LogEntry(w io.Writer, entry logs.LogEntry, opts ...LogOption) (err error) {
 	return err
 }
 
+// logSource returns where entry comes from, like app[instance].
+func logSource(entry logs.LogEntry, options *LogOptions) string {
+	provider := ""
+	if options.show("provider") {
+		provider = entry.Meta.Event.Provider
+	}
+	instance := ""
+	if options.show("instance") {
+		instance = entry.Instance
+	}
+
+	switch {
+	case provider != "" && instance != "":
+		return fmt.Sprintf("%s[%s]", provider, instance)
+	case provider != "":
+		return provider
+	default:
+		return instance
+	}
+}
+
+// LogEntryNDJSON writes entry as a single line of JSON. With fields selected, the
+// object only has those fields, named as in LogFields.
+func LogEntryNDJSON(w io.Writer, entry logs.LogEntry, opts ...LogOption) error {
+	options := &LogOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	var v interface{} = entry
+	if len(options.Fields) > 0 {
+		values := logFieldValues(entry)
+		selected := make(map[string]interface{}, len(options.Fields))
+		for _, field := range options.Fields {
+			selected[field] = values[field]
+		}
+		v = selected
+	}
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+// LogEntryLogfmt writes entry as logfmt key=value pairs, skipping empty values.
+func LogEntryLogfmt(w io.Writer, entry logs.LogEntry, opts ...LogOption) error {
+	options := &LogOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	var (
+		buf    bytes.Buffer
+		values = logFieldValues(entry)
+	)
+	for _, field := range LogFields {
+		if !options.show(field) {
+			continue
+		}
+
+		var value string
+		switch v := values[field].(type) {
+		case string:
+			value = v
+		case int:
+			if v != 0 {
+				value = strconv.Itoa(v)
+			}
+		}
+		if value == "" {
+			continue
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(field)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(value))
+	}
+	buf.WriteByte('\n')
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func logfmtValue(value string) string {
+	if strings.ContainsAny(value, " =\"\t\r\n\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// LogEntryRaw writes the message of entry only.
+func LogEntryRaw(w io.Writer, entry logs.LogEntry) error {
+	_, err := fmt.Fprintln(w, entry.Message)
+	return err
+}
+
 func printFieldIfPresent(w io.Writer, name string, value interface{}) (present bool) {
 	switch v := value.(type) {
 	case string:
@@ -0,0 +1,85 @@
+package render
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/logs"
+)
+
+func testLogEntry() logs.LogEntry {
+	entry := logs.LogEntry{
+		Timestamp: "2023-06-01T12:00:00Z",
+		Instance:  "148ed193b95389",
+		Region:    "ams",
+		Level:     "info",
+		Message:   `GET /users "ok"`,
+	}
+	entry.Meta.Event.Provider = "app"
+	entry.Meta.HTTP.Response.StatusCode = 200
+	return entry
+}
+
+func formatLogEntry(t *testing.T, format string, opts ...LogOption) string {
+	t.Helper()
+	formatter, err := NewLogFormatter(format, opts...)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, formatter(&buf, testLogEntry()))
+	return buf.String()
+}
+
+func TestLogFormatNDJSON(t *testing.T) {
+	out := formatLogEntry(t, "ndjson")
+	assert.Equal(t, 1, bytes.Count([]byte(out), []byte("\n")))
+	assert.Contains(t, out, `"instance":"148ed193b95389"`)
+
+	out = formatLogEntry(t, "ndjson", Fields("level", "response.status"))
+	assert.Equal(t, `{"level":"info","response.status":200}`+"\n", out)
+}
+
+func TestLogFormatLogfmt(t *testing.T) {
+	out := formatLogEntry(t, "logfmt")
+	assert.Equal(t, `timestamp=2023-06-01T12:00:00Z provider=app instance=148ed193b95389 region=ams level=info response.status=200 message="GET /users \"ok\""`+"\n", out)
+
+	out = formatLogEntry(t, "logfmt", Fields("instance", "message"))
+	assert.Equal(t, `instance=148ed193b95389 message="GET /users \"ok\""`+"\n", out)
+}
+
+func TestLogFormatRawAndTemplate(t *testing.T) {
+	assert.Equal(t, `GET /users "ok"`+"\n", formatLogEntry(t, "raw"))
+	assert.Equal(t, "ams 148ed193b95389 200\n", formatLogEntry(t, "{{.Region}} {{.Instance}} {{.Meta.HTTP.Response.StatusCode}}"))
+}
+
+func TestLogFormatText(t *testing.T) {
+	out := formatLogEntry(t, "text", HideAllocID(), HideRegion(), Fields("level", "message"))
+	assert.Contains(t, out, "[")
+	assert.Contains(t, out, `GET /users "ok"`)
+	assert.NotContains(t, out, "148ed193b95389")
+	assert.NotContains(t, out, "response.status")
+
+	entry := testLogEntry()
+	entry.App = "web"
+	formatter, err := NewLogFormatter("text", HideAllocID(), HideRegion(), ShowApp(5), Fields("app", "message"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, formatter(&buf, entry))
+	assert.Contains(t, buf.String(), "web  ")
+	assert.Contains(t, buf.String(), `GET /users "ok"`)
+
+	buf.Reset()
+	require.NoError(t, LogEntryLogfmt(&buf, entry, Fields("app", "level")))
+	assert.Equal(t, "app=web level=info\n", buf.String())
+}
+
+func TestNewLogFormatterErrors(t *testing.T) {
+	_, err := NewLogFormatter("yaml")
+	assert.Error(t, err)
+	_, err = NewLogFormatter("{{.Nope")
+	assert.Error(t, err)
+	_, err = NewLogFormatter("ndjson", Fields("hostname"))
+	assert.Error(t, err)
+}
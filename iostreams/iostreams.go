@@ -56,6 +56,10 @@ func (s *IOStreams) ColorEnabled() bool {
 	return s.colorEnabled
 }
 
+func (s *IOStreams) SetColorEnabled(enabled bool) {
+	s.colorEnabled = enabled
+}
+
 func (s *IOStreams) ColorSupport256() bool {
 	return s.is256enabled
 }
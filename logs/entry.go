@@ -1,6 +1,7 @@
 package logs
 
 type LogEntry struct {
+	App       string `json:"app,omitempty"`
 	Level     string `json:"level"`
 	Instance  string `json:"instance"`
 	Message   string `json:"message"`
@@ -0,0 +1,114 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var levels = map[string]int{
+	"trace":   0,
+	"debug":   1,
+	"info":    2,
+	"notice":  2,
+	"warn":    3,
+	"warning": 3,
+	"error":   4,
+	"crit":    5,
+	"fatal":   5,
+}
+
+// levelRank returns the rank of level, treating unknown levels as info.
+func levelRank(level string) int {
+	if rank, ok := levels[strings.ToLower(level)]; ok {
+		return rank
+	}
+	return levels["info"]
+}
+
+// StatusRange is an inclusive range of HTTP status codes.
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// ParseStatusRange parses an HTTP status code ("404"), class ("5xx") or range ("500-503").
+func ParseStatusRange(s string) (StatusRange, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if len(s) == 3 && strings.HasSuffix(s, "xx") {
+		class, err := strconv.Atoi(s[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return StatusRange{Min: class * 100, Max: class*100 + 99}, nil
+		}
+	} else if from, to, ok := strings.Cut(s, "-"); ok {
+		low, lowErr := strconv.Atoi(from)
+		high, highErr := strconv.Atoi(to)
+		if lowErr == nil && highErr == nil && low > 0 && low <= high {
+			return StatusRange{Min: low, Max: high}, nil
+		}
+	} else if code, err := strconv.Atoi(s); err == nil && code > 0 {
+		return StatusRange{Min: code, Max: code}, nil
+	}
+
+	return StatusRange{}, fmt.Errorf("invalid HTTP status %q, expected a code (404), a class (5xx) or a range (500-503)", s)
+}
+
+func (r StatusRange) contains(code int) bool {
+	return code >= r.Min && code <= r.Max
+}
+
+// Filter selects log entries on the client side. Zero fields don't filter anything.
+type Filter struct {
+	// MinLevel drops entries below this level
+	MinLevel string
+	// Grep keeps only the entries whose message matches
+	Grep *regexp.Regexp
+	// Exclude drops the entries whose message matches
+	Exclude *regexp.Regexp
+	// HTTPStatus keeps only the entries with a response status in one of the ranges
+	HTTPStatus []StatusRange
+	// Instance keeps only the entries of the instances it returns true for
+	Instance func(instance string) bool
+}
+
+// ValidateLevel returns an error if level isn't a known log level.
+func ValidateLevel(level string) error {
+	if _, ok := levels[strings.ToLower(level)]; !ok {
+		return fmt.Errorf("unknown log level %q, expected one of trace, debug, info, warn, error or fatal", level)
+	}
+	return nil
+}
+
+// Match reports whether entry passes every filter of f.
+func (f *Filter) Match(entry LogEntry) bool {
+	if f == nil {
+		return true
+	}
+	if f.MinLevel != "" && levelRank(entry.Level) < levelRank(f.MinLevel) {
+		return false
+	}
+	if f.Grep != nil && !f.Grep.MatchString(entry.Message) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.MatchString(entry.Message) {
+		return false
+	}
+	if len(f.HTTPStatus) > 0 && !f.matchHTTPStatus(entry.Meta.HTTP.Response.StatusCode) {
+		return false
+	}
+	if f.Instance != nil && !f.Instance(entry.Instance) {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) matchHTTPStatus(code int) bool {
+	for _, r := range f.HTTPStatus {
+		if r.contains(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package logs
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	for input, want := range map[string]StatusRange{
+		"404":     {Min: 404, Max: 404},
+		"5xx":     {Min: 500, Max: 599},
+		"2XX":     {Min: 200, Max: 299},
+		"500-503": {Min: 500, Max: 503},
+	} {
+		got, err := ParseStatusRange(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"", "abc", "9xx", "503-500", "-1"} {
+		_, err := ParseStatusRange(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	entry := func(level, message string, status int) LogEntry {
+		e := LogEntry{Level: level, Message: message, Instance: "m1"}
+		e.Meta.HTTP.Response.StatusCode = status
+		return e
+	}
+
+	var nilFilter *Filter
+	assert.True(t, nilFilter.Match(entry("debug", "hello", 0)))
+
+	level := &Filter{MinLevel: "warn"}
+	assert.False(t, level.Match(entry("info", "hello", 0)))
+	assert.True(t, level.Match(entry("warning", "hello", 0)))
+	assert.True(t, level.Match(entry("ERROR", "hello", 0)))
+	assert.False(t, level.Match(entry("something", "hello", 0)))
+
+	grep := &Filter{Grep: regexp.MustCompile(`GET /api`), Exclude: regexp.MustCompile(`healthz`)}
+	assert.True(t, grep.Match(entry("info", "GET /api/users", 0)))
+	assert.False(t, grep.Match(entry("info", "GET /api/healthz", 0)))
+	assert.False(t, grep.Match(entry("info", "POST /login", 0)))
+
+	status := &Filter{HTTPStatus: []StatusRange{{Min: 500, Max: 599}, {Min: 404, Max: 404}}}
+	assert.True(t, status.Match(entry("info", "", 502)))
+	assert.True(t, status.Match(entry("info", "", 404)))
+	assert.False(t, status.Match(entry("info", "", 200)))
+	assert.False(t, status.Match(entry("info", "", 0)))
+
+	instance := &Filter{Instance: func(id string) bool { return id == "m2" }}
+	assert.False(t, instance.Match(entry("info", "", 0)))
+}
+
+func TestValidateLevel(t *testing.T) {
+	assert.NoError(t, ValidateLevel("Warn"))
+	assert.Error(t, ValidateLevel("loud"))
+}
@@ -14,6 +14,14 @@ type LogOptions struct {
 	AppName    string
 	VMID       string
 	RegionCode string
+
+	// StartTime makes polling skip the entries logged before this time. Polling starts at
+	// the oldest logs the API returns, which may be more recent
+	StartTime time.Time
+	// EndTime makes polling stop at the first entry logged after this time
+	EndTime time.Time
+	// NoTail makes polling stop once it caught up with the most recent logs
+	NoTail bool
 }
 
 func (opts *LogOptions) toNatsSubject() (subject string) {
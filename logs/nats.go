@@ -113,6 +113,7 @@ func fromNats(ctx context.Context, out chan<- LogEntry, nc *nats.Conn, opts *Log
 		}
 
 		out <- LogEntry{
+			App:       log.Fly.App.Name,
 			Instance:  log.Fly.App.Instance,
 			Level:     log.Log.Level,
 			Message:   log.Message,
@@ -8,6 +8,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/terminal"
 )
 
 type pollingStream struct {
@@ -45,6 +46,7 @@ func Poll(ctx context.Context, out chan<- LogEntry, client *api.Client, opts *Lo
 		errorCount int
 		nextToken  string
 		waitFor    = minWait
+		firstPage  = true
 	)
 
 	for {
@@ -70,6 +72,9 @@ func Poll(ctx context.Context, out chan<- LogEntry, client *api.Client, opts *Lo
 
 		errorCount = 0
 		if len(entries) == 0 {
+			if opts.NoTail {
+				return nil
+			}
 			waitFor = backoff(minWait, maxWait)
 
 			continue
@@ -77,12 +82,28 @@ func Poll(ctx context.Context, out chan<- LogEntry, client *api.Client, opts *Lo
 
 		waitFor = 0
 
+		if firstPage {
+			firstPage = false
+			if !reachesStartTime(entries[0], opts) {
+				return nil
+			}
+		}
+
 		if token != "" {
 			nextToken = token
 		}
 
 		for _, entry := range entries {
+			if !inTimeRange(entry.Timestamp, opts.StartTime, time.Time{}) {
+				continue
+			}
+			if !inTimeRange(entry.Timestamp, time.Time{}, opts.EndTime) {
+				// entries come in order, so we're past the end of the range
+				return nil
+			}
+
 			out <- LogEntry{
+				App:       opts.AppName,
 				Instance:  entry.Instance,
 				Level:     entry.Level,
 				Message:   entry.Message,
@@ -91,7 +112,46 @@ func Poll(ctx context.Context, out chan<- LogEntry, client *api.Client, opts *Lo
 				Meta:      entry.Meta,
 			}
 		}
+
+		if opts.NoTail && token == "" {
+			return nil
+		}
+	}
+}
+
+// reachesStartTime reports whether the logs of opts can be shown from their start time on, given
+// the oldest entry the API returned. The API only pages forward from its oldest entry, so the
+// part of the range before it is missing, which is reported. It returns false when the whole
+// range is.
+func reachesStartTime(oldest api.LogEntry, opts *LogOptions) bool {
+	if opts.StartTime.IsZero() {
+		return true
+	}
+	ts, err := time.Parse(time.RFC3339Nano, oldest.Timestamp)
+	if err != nil || !ts.After(opts.StartTime) {
+		return true
+	}
+
+	if !opts.EndTime.IsZero() && ts.After(opts.EndTime) {
+		terminal.Warnf("No logs of %s are available until %s, the oldest ones available are from %s\n",
+			opts.AppName, opts.EndTime.Format(time.RFC3339), ts.Format(time.RFC3339))
+		return false
+	}
+	terminal.Warnf("Logs of %s from before %s are no longer available\n", opts.AppName, ts.Format(time.RFC3339))
+	return true
+}
+
+// inTimeRange reports whether timestamp is within [start, end]. Zero bounds aren't checked,
+// and timestamps that fail to parse are always in range.
+func inTimeRange(timestamp string, start, end time.Time) bool {
+	if start.IsZero() && end.IsZero() {
+		return true
+	}
+	ts, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return true
 	}
+	return (start.IsZero() || !ts.Before(start)) && (end.IsZero() || !ts.After(end))
 }
 
 func backoff(current, max time.Duration) (val time.Duration) {
@@ -0,0 +1,26 @@
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/superfly/flyctl/api"
+)
+
+func TestReachesStartTime(t *testing.T) {
+	oldest := api.LogEntry{Timestamp: "2023-06-01T11:00:00Z"}
+	at := func(value string) time.Time {
+		ts, _ := time.Parse(time.RFC3339, value)
+		return ts
+	}
+
+	assert.True(t, reachesStartTime(oldest, &LogOptions{}))
+	assert.True(t, reachesStartTime(oldest, &LogOptions{StartTime: at("2023-06-01T11:30:00Z")}))
+	// part of the range is missing, what's left is shown
+	assert.True(t, reachesStartTime(oldest, &LogOptions{StartTime: at("2023-06-01T10:00:00Z")}))
+	assert.True(t, reachesStartTime(oldest, &LogOptions{StartTime: at("2023-06-01T10:00:00Z"), EndTime: at("2023-06-01T11:30:00Z")}))
+	// all of it is
+	assert.False(t, reachesStartTime(oldest, &LogOptions{StartTime: at("2023-06-01T09:00:00Z"), EndTime: at("2023-06-01T10:00:00Z")}))
+}
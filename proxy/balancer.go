@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+// BalanceStrategies are the strategies a Balancer spreads connections across instances with.
+var BalanceStrategies = []string{"round-robin", "least-conn", "random"}
+
+const (
+	// balancerRefreshInterval is how often the instances of a Balancer are resolved again
+	balancerRefreshInterval = 30 * time.Second
+	// ejectTimeout is how long an instance that failed to dial is left out
+	ejectTimeout = 30 * time.Second
+)
+
+// ErrNoInstance is returned by a Balancer without any instance left to dial.
+var ErrNoInstance = errors.New("no instance available")
+
+// Balancer spreads the connections of one or more servers across the instances of an app.
+type Balancer struct {
+	// Sticky sends every connection of a client address to the same instance, as long
+	// as it's available. Connections from loopback addresses and unix sockets can't be
+	// told apart and are balanced as usual.
+	Sticky bool
+	// OnEject, if set, is called when an instance that failed to dial is left out.
+	OnEject func(instance string, err error)
+
+	strategy  string
+	resolve   func(ctx context.Context) ([]string, error)
+	now       func() time.Time
+	intn      func(n int) int
+	mu        sync.Mutex
+	instances []string
+	resolved  time.Time
+	next      int
+	active    map[string]int
+	ejected   map[string]time.Time
+	sticky    map[string]string
+}
+
+// NewBalancer returns a Balancer spreading connections with strategy, one of
+// BalanceStrategies, across the instances returned by resolve.
+func NewBalancer(strategy string, resolve func(ctx context.Context) ([]string, error)) (*Balancer, error) {
+	valid := false
+	for _, s := range BalanceStrategies {
+		valid = valid || s == strategy
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown balance strategy %q, expected one of %s", strategy, strings.Join(BalanceStrategies, ", "))
+	}
+
+	return &Balancer{
+		strategy: strategy,
+		resolve:  resolve,
+		now:      time.Now,
+		intn:     rand.Intn,
+		active:   map[string]int{},
+		ejected:  map[string]time.Time{},
+		sticky:   map[string]string{},
+	}, nil
+}
+
+// Dial dials port of an instance for the connection of client with dial. Instances
+// which fail to dial are ejected and the next one is tried. The returned func must be
+// called once the connection is closed.
+func (b *Balancer) Dial(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, port, client string) (net.Conn, func(), error) {
+	if err := b.refresh(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		instance, err := b.pick(client)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		conn, err := dial(ctx, network, fmt.Sprintf("[%s]:%s", instance, port))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			b.eject(instance, err)
+			continue
+		}
+
+		b.mu.Lock()
+		b.active[instance]++
+		b.mu.Unlock()
+
+		var once sync.Once
+		return conn, func() {
+			once.Do(func() {
+				b.mu.Lock()
+				b.active[instance]--
+				b.mu.Unlock()
+			})
+		}, nil
+	}
+}
+
+// refresh resolves the instances again when they weren't for balancerRefreshInterval.
+func (b *Balancer) refresh(ctx context.Context) error {
+	b.mu.Lock()
+	stale := b.now().Sub(b.resolved) >= balancerRefreshInterval
+	b.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	instances, err := b.resolve(ctx)
+	if err != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if len(b.instances) > 0 {
+			// keep going with the instances resolved last
+			terminal.Debug("failed to resolve instances: ", err)
+			return nil
+		}
+		return fmt.Errorf("failed resolving instances: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.instances = instances
+	b.resolved = b.now()
+	return nil
+}
+
+// pick returns the instance the next connection of client goes to.
+func (b *Balancer) pick(client string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	available := make([]string, 0, len(b.instances))
+	for _, instance := range b.instances {
+		if until, ok := b.ejected[instance]; ok && now.Before(until) {
+			continue
+		}
+		delete(b.ejected, instance)
+		available = append(available, instance)
+	}
+	if len(available) == 0 {
+		return "", ErrNoInstance
+	}
+
+	if b.Sticky && client != "" {
+		if instance, ok := b.sticky[client]; ok && contains(available, instance) {
+			return instance, nil
+		}
+	}
+
+	var instance string
+	switch b.strategy {
+	case "least-conn":
+		instance = available[0]
+		for _, candidate := range available[1:] {
+			if b.active[candidate] < b.active[instance] {
+				instance = candidate
+			}
+		}
+	case "random":
+		instance = available[b.intn(len(available))]
+	default:
+		instance = available[b.next%len(available)]
+		b.next++
+	}
+
+	if b.Sticky && client != "" {
+		b.sticky[client] = instance
+	}
+	return instance, nil
+}
+
+// eject leaves instance out for ejectTimeout.
+func (b *Balancer) eject(instance string, err error) {
+	b.mu.Lock()
+	b.ejected[instance] = b.now().Add(ejectTimeout)
+	b.mu.Unlock()
+
+	terminal.Debug("ejected instance ", instance, ": ", err)
+	if b.OnEject != nil {
+		b.OnEject(instance, err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKey identifies the client of a connection from addr for sticky sessions. It's empty
+// for unix sockets and loopback addresses, which every local client shares.
+func clientKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBalancer(t *testing.T, strategy string, instances ...string) *Balancer {
+	t.Helper()
+	b, err := NewBalancer(strategy, func(context.Context) ([]string, error) {
+		return instances, nil
+	})
+	require.NoError(t, err)
+	return b
+}
+
+// dialer returns a dial func recording the addresses dialed, failing for the ones in failing.
+func dialer(dialed *[]string, failing ...string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		*dialed = append(*dialed, addr)
+		for _, f := range failing {
+			if addr == f {
+				return nil, errors.New("connection refused")
+			}
+		}
+		local, remote := net.Pipe()
+		remote.Close()
+		return local, nil
+	}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := testBalancer(t, "round-robin", "fdaa::1", "fdaa::2")
+
+	var dialed []string
+	for i := 0; i < 3; i++ {
+		_, release, err := b.Dial(context.Background(), dialer(&dialed), "tcp", "80", "127.0.0.1")
+		require.NoError(t, err)
+		release()
+	}
+	assert.Equal(t, []string{"[fdaa::1]:80", "[fdaa::2]:80", "[fdaa::1]:80"}, dialed)
+}
+
+func TestBalancerLeastConn(t *testing.T) {
+	b := testBalancer(t, "least-conn", "fdaa::1", "fdaa::2")
+
+	var dialed []string
+	_, releaseFirst, err := b.Dial(context.Background(), dialer(&dialed), "tcp", "80", "")
+	require.NoError(t, err)
+	_, releaseSecond, err := b.Dial(context.Background(), dialer(&dialed), "tcp", "80", "")
+	require.NoError(t, err)
+	releaseSecond()
+	_, _, err = b.Dial(context.Background(), dialer(&dialed), "tcp", "80", "")
+	require.NoError(t, err)
+	releaseFirst()
+
+	assert.Equal(t, []string{"[fdaa::1]:80", "[fdaa::2]:80", "[fdaa::2]:80"}, dialed)
+}
+
+func TestBalancerSticky(t *testing.T) {
+	b := testBalancer(t, "random", "fdaa::1", "fdaa::2", "fdaa::3")
+	b.Sticky = true
+
+	var dialed []string
+	for i := 0; i < 5; i++ {
+		_, _, err := b.Dial(context.Background(), dialer(&dialed), "tcp", "80", "10.0.0.1")
+		require.NoError(t, err)
+	}
+	for _, addr := range dialed {
+		assert.Equal(t, dialed[0], addr)
+	}
+}
+
+func TestBalancerEjectsFailingInstances(t *testing.T) {
+	b := testBalancer(t, "round-robin", "fdaa::1", "fdaa::2")
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	var ejected []string
+	b.OnEject = func(instance string, err error) {
+		ejected = append(ejected, instance)
+	}
+
+	var dialed []string
+	for i := 0; i < 2; i++ {
+		_, _, err := b.Dial(context.Background(), dialer(&dialed, "[fdaa::1]:80"), "tcp", "80", "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"[fdaa::1]:80", "[fdaa::2]:80", "[fdaa::2]:80"}, dialed)
+	assert.Equal(t, []string{"fdaa::1"}, ejected)
+
+	// every instance failing
+	_, _, err := b.Dial(context.Background(), dialer(&dialed, "[fdaa::2]:80"), "tcp", "80", "")
+	assert.ErrorIs(t, err, ErrNoInstance)
+
+	// ejected instances come back after a while
+	now = now.Add(ejectTimeout)
+	dialed = nil
+	_, _, err = b.Dial(context.Background(), dialer(&dialed), "tcp", "80", "")
+	require.NoError(t, err)
+	assert.Len(t, dialed, 1)
+}
+
+func TestNewBalancerUnknownStrategy(t *testing.T) {
+	_, err := NewBalancer("fastest", nil)
+	assert.Error(t, err)
+}
+
+func TestClientKey(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", clientKey(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51234}))
+	assert.Equal(t, "fdaa::9", clientKey(&net.UDPAddr{IP: net.ParseIP("fdaa::9"), Port: 53}))
+
+	// every local client looks the same
+	assert.Empty(t, clientKey(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 51234}))
+	assert.Empty(t, clientKey(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 51234}))
+	assert.Empty(t, clientKey(&net.UnixAddr{Name: "@", Net: "unix"}))
+}
@@ -2,13 +2,18 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
+	"golang.org/x/sync/errgroup"
+
 	"github.com/superfly/flyctl/agent"
 	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/ip"
 )
@@ -17,33 +22,91 @@ type ConnectParams struct {
 	AppName          string
 	OrganizationSlug string
 	Dialer           agent.Dialer
-	Ports            []string
-	RemoteHost       string
-	PromptInstance   bool
-	DisableSpinner   bool
+	// Ports are the local port, or unix socket, and the remote port of a single forward.
+	// They are ignored when Forwards is set.
+	Ports []string
+	// Forwards are the ports to forward, each with its own listener.
+	Forwards       []Forward
+	RemoteHost     string
+	PromptInstance bool
+	DisableSpinner bool
+	// Conns, if set, tracks the connections of every forward.
+	Conns *ConnTable
+	// Balancer, if set, spreads the connections of every forward across instances
+	// instead of dialing RemoteHost.
+	Balancer *Balancer
+	// IdleTimeout and MaxConns apply to the server of every forward, see Server.
+	IdleTimeout time.Duration
+	MaxConns    int
+	// BindAddr is the local address TCP and UDP ports are forwarded from, 127.0.0.1 when empty.
+	BindAddr string
 }
 
 func Connect(ctx context.Context, p *ConnectParams) (err error) {
-	server, err := NewServer(ctx, p)
+	servers, err := NewServers(ctx, p)
 	if err != nil {
 		return err
 	}
+	return Serve(ctx, servers)
+}
+
+// Serve proxies the connections of every server until ctx is done or one of them fails.
+func Serve(ctx context.Context, servers []*Server) error {
+	var eg *errgroup.Group
+	eg, ctx = errgroup.WithContext(ctx)
+
+	for _, server := range servers {
+		server := server
 
-	return server.ProxyServer(ctx)
+		eg.Go(func() error {
+			return server.ProxyServer(ctx)
+		})
+	}
+
+	return eg.Wait()
 }
 
+// NewServer returns the server of the single forward of p.
 func NewServer(ctx context.Context, p *ConnectParams) (*Server, error) {
+	servers, err := NewServers(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(servers) != 1 {
+		closeServers(servers)
+		return nil, fmt.Errorf("expected a single port to forward, got %d", len(servers))
+	}
+	return servers[0], nil
+}
+
+// NewServers returns a server listening on the local end of every forward of p. All of
+// them dial the remote host through the dialer of p.
+func NewServers(ctx context.Context, p *ConnectParams) (servers []*Server, err error) {
 	var (
 		io         = iostreams.FromContext(ctx)
 		client     = client.FromContext(ctx).API()
 		orgSlug    = p.OrganizationSlug
-		localPort  = p.Ports[0]
-		remotePort = localPort
-		remoteAddr string
+		forwards   = p.Forwards
+		remoteHost string
 	)
 
-	if len(p.Ports) > 1 {
-		remotePort = p.Ports[1]
+	// with --json, stdout only gets the JSON output
+	out := io.Out
+	if config.FromContext(ctx).JSONOutput {
+		out = io.ErrOut
+	}
+
+	if len(forwards) == 0 {
+		if len(p.Ports) == 0 {
+			return nil, errors.New("no port to forward")
+		}
+
+		f := Forward{Local: p.Ports[0], Remote: p.Ports[0], Network: "tcp"}
+		if len(p.Ports) > 1 {
+			f.Remote = p.Ports[1]
+		}
+		forwards = []Forward{f}
 	}
 
 	agentclient, err := agent.Establish(ctx, client)
@@ -58,10 +121,10 @@ func NewServer(ctx context.Context, p *ConnectParams) (*Server, error) {
 			return nil, err
 		}
 
-		remoteAddr = fmt.Sprintf("[%s]:%s", instance, remotePort)
+		remoteHost = instance
 	}
 
-	if remoteAddr == "" && p.RemoteHost != "" {
+	if remoteHost == "" && p.RemoteHost != "" {
 
 		// If a host is specified that isn't an IpV6 address, assume it's a DNS entry and wait for that
 		// entry to resolve
@@ -71,42 +134,111 @@ func NewServer(ctx context.Context, p *ConnectParams) (*Server, error) {
 			}
 		}
 
-		remoteAddr = fmt.Sprintf("[%s]:%s", p.RemoteHost, remotePort)
+		remoteHost = p.RemoteHost
 	}
 
-	var listener net.Listener
-
-	if _, err := strconv.Atoi(localPort); err == nil {
-		// just numbers
-		addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%s", localPort))
+	defer func() {
 		if err != nil {
+			closeServers(servers)
+		}
+	}()
+
+	for _, f := range forwards {
+		var remoteAddr string
+		if remoteHost != "" {
+			remoteAddr = fmt.Sprintf("[%s]:%s", remoteHost, f.Remote)
+		}
+
+		server := &Server{
+			LocalAddr: f.Local,
+			Addr:      remoteAddr,
+			Dial:      p.Dialer.DialContext,
+			Conns:     p.Conns,
+			Balancer:  p.Balancer,
+			Port:      f.Remote,
+
+			IdleTimeout: p.IdleTimeout,
+			MaxConns:    p.MaxConns,
+		}
+		if server.Listener, server.PacketConn, err = listen(f, p.BindAddr); err != nil {
 			return nil, err
 		}
+		servers = append(servers, server)
+
+		fmt.Fprintf(out, "Proxying local port %s to remote %s\n", f, remoteAddr)
+	}
 
-		listener, err = net.ListenTCP("tcp", addr)
+	return servers, nil
+}
+
+// listen listens on the local end of f, with a packet connection for UDP. Ports are
+// bound to bindAddr, or 127.0.0.1 when empty.
+func listen(f Forward, bindAddr string) (net.Listener, net.PacketConn, error) {
+	if bindAddr == "" {
+		bindAddr = "127.0.0.1"
+	}
+
+	if f.Network == "udp" {
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(bindAddr, f.Local))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-	} else {
-		// probably a unix path
-		addr, err := net.ResolveUnixAddr("unix", localPort)
+
+		conn, err := net.ListenUDP("udp", addr)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		return nil, conn, nil
+	}
 
-		listener, err = net.ListenUnix("unix", addr)
+	if _, err := strconv.Atoi(f.Local); err == nil {
+		// just numbers
+		addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(bindAddr, f.Local))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+
+		listener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return listener, nil, nil
 	}
 
-	fmt.Fprintf(io.Out, "Proxying local port %s to remote %s\n", localPort, remoteAddr)
+	// probably a unix path
+	addr, err := net.ResolveUnixAddr("unix", f.Local)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return &Server{
-		Addr:     remoteAddr,
-		Listener: listener,
-		Dial:     p.Dialer.DialContext,
-	}, nil
+	listener, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return listener, nil, nil
+}
+
+func closeServers(servers []*Server) {
+	for _, server := range servers {
+		if server.Listener != nil {
+			server.Listener.Close()
+		}
+		if server.PacketConn != nil {
+			server.PacketConn.Close()
+		}
+	}
+}
+
+// InstanceResolver returns a func resolving the addresses of the instances of app,
+// for a Balancer.
+func InstanceResolver(c *agent.Client, org, app string) func(ctx context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		instances, err := c.Instances(ctx, org, app)
+		if err != nil {
+			return nil, fmt.Errorf("look up %s: %w", app, err)
+		}
+		return instances.Addresses, nil
+	}
 }
 
 func selectInstance(ctx context.Context, org, app string, c *agent.Client) (instance string, err error) {
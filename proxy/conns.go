@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats describes a connection proxied by a Server.
+type ConnStats struct {
+	ID            int       `json:"id"`
+	Network       string    `json:"network"`
+	Local         string    `json:"local"`
+	Source        string    `json:"source"`
+	Remote        string    `json:"remote"`
+	Instance      string    `json:"instance"`
+	OpenedAt      time.Time `json:"opened_at"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+}
+
+// ConnEvent is passed to the OnChange func of a ConnTable when a connection opens or closes.
+type ConnEvent string
+
+const (
+	ConnOpened ConnEvent = "open"
+	ConnClosed ConnEvent = "close"
+)
+
+// ConnTable tracks the connections proxied by one or more servers.
+type ConnTable struct {
+	// OnChange, if set, is called when a connection opens or closes.
+	OnChange func(event ConnEvent, conn ConnStats)
+
+	mu     sync.Mutex
+	nextID int
+	conns  map[int]*trackedConn
+}
+
+type trackedConn struct {
+	stats    ConnStats
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (c *trackedConn) snapshot() ConnStats {
+	stats := c.stats
+	stats.BytesSent = c.sent.Load()
+	stats.BytesReceived = c.received.Load()
+	return stats
+}
+
+// open starts tracking a connection described by stats.
+func (t *ConnTable) open(stats ConnStats) *trackedConn {
+	if t == nil {
+		return &trackedConn{stats: stats}
+	}
+
+	t.mu.Lock()
+	if t.conns == nil {
+		t.conns = map[int]*trackedConn{}
+	}
+	t.nextID++
+	stats.ID = t.nextID
+	stats.OpenedAt = time.Now()
+	conn := &trackedConn{stats: stats}
+	t.conns[stats.ID] = conn
+	t.mu.Unlock()
+
+	if t.OnChange != nil {
+		t.OnChange(ConnOpened, conn.snapshot())
+	}
+	return conn
+}
+
+// close stops tracking conn.
+func (t *ConnTable) close(conn *trackedConn) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	delete(t.conns, conn.stats.ID)
+	t.mu.Unlock()
+
+	if t.OnChange != nil {
+		t.OnChange(ConnClosed, conn.snapshot())
+	}
+}
+
+// Conns returns the open connections, oldest first.
+func (t *ConnTable) Conns() []ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]ConnStats, 0, len(t.conns))
+	for _, conn := range t.conns {
+		conns = append(conns, conn.snapshot())
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+	return conns
+}
+
+// countingReader counts the bytes read from an io.Reader and calls onRead, if set,
+// after every read.
+type countingReader struct {
+	io.Reader
+	n      *atomic.Int64
+	onRead func()
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	if r.onRead != nil {
+		r.onRead()
+	}
+	return n, err
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Forward is a local port, or unix socket, forwarded to a remote port.
+type Forward struct {
+	Local   string
+	Remote  string
+	Network string // tcp or udp
+}
+
+func (f Forward) String() string {
+	local := f.Local
+	if f.Network == "udp" {
+		local += "/udp"
+	}
+	return local
+}
+
+// ParseForward parses spec, a local port or unix socket with an optional remote port
+// and protocol, like 5432, 8080:80, /tmp/db.sock:5432 or 53:53/udp. The remote port
+// defaults to the local one.
+func ParseForward(spec string) (f Forward, err error) {
+	f.Network = "tcp"
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		switch proto := spec[i+1:]; proto {
+		case "tcp", "udp":
+			f.Network = proto
+			spec = spec[:i]
+		}
+	}
+
+	f.Local, f.Remote = spec, spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		f.Local, f.Remote = spec[:i], spec[i+1:]
+	}
+
+	if f.Local == "" {
+		return f, fmt.Errorf("invalid port %q: missing local port", spec)
+	}
+	if !isPort(f.Remote) {
+		return f, fmt.Errorf("invalid port %q: remote port %q must be a number between 1 and 65535", spec, f.Remote)
+	}
+	if f.Network == "udp" && !isPort(f.Local) {
+		return f, fmt.Errorf("invalid port %q: UDP can't be forwarded from a unix socket", spec)
+	}
+	return f, nil
+}
+
+// ParseForwards parses every one of specs with ParseForward.
+func ParseForwards(specs []string) ([]Forward, error) {
+	forwards := make([]Forward, 0, len(specs))
+	for _, spec := range specs {
+		f, err := ParseForward(spec)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
+
+// IsForward reports whether spec is a forward of ports, like 8080:80 or 53/udp,
+// as opposed to a host name or address.
+func IsForward(spec string) bool {
+	f, err := ParseForward(spec)
+	return err == nil && isPort(f.Local)
+}
+
+func isPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port > 0 && port <= 65535
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForward(t *testing.T) {
+	cases := map[string]Forward{
+		"5432":              {Local: "5432", Remote: "5432", Network: "tcp"},
+		"8080:80":           {Local: "8080", Remote: "80", Network: "tcp"},
+		"53:53/udp":         {Local: "53", Remote: "53", Network: "udp"},
+		"6379/tcp":          {Local: "6379", Remote: "6379", Network: "tcp"},
+		"/tmp/db.sock:5432": {Local: "/tmp/db.sock", Remote: "5432", Network: "tcp"},
+	}
+	for spec, want := range cases {
+		got, err := ParseForward(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, got, spec)
+	}
+
+	for _, spec := range []string{":80", "8080:http", "8080:70000", "/tmp/db.sock:53/udp"} {
+		_, err := ParseForward(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestIsForward(t *testing.T) {
+	assert.True(t, IsForward("8080:80"))
+	assert.True(t, IsForward("53/udp"))
+	assert.False(t, IsForward("my-app.internal"))
+	assert.False(t, IsForward("fdaa:0:1::3"))
+	assert.False(t, IsForward("/tmp/db.sock:5432"))
+}
+
+func TestConnTable(t *testing.T) {
+	var events []ConnEvent
+	table := &ConnTable{OnChange: func(event ConnEvent, conn ConnStats) {
+		events = append(events, event)
+	}}
+
+	first := table.open(ConnStats{Network: "tcp", Source: "127.0.0.1:50000"})
+	second := table.open(ConnStats{Network: "udp", Source: "127.0.0.1:50001"})
+	first.sent.Add(10)
+	first.received.Add(20)
+
+	conns := table.Conns()
+	require.Len(t, conns, 2)
+	assert.Equal(t, 1, conns[0].ID)
+	assert.Equal(t, int64(10), conns[0].BytesSent)
+	assert.Equal(t, int64(20), conns[0].BytesReceived)
+
+	table.close(first)
+	table.close(second)
+	assert.Empty(t, table.Conns())
+	assert.Equal(t, []ConnEvent{ConnOpened, ConnOpened, ConnClosed, ConnClosed}, events)
+}
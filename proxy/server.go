@@ -2,83 +2,337 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/superfly/flyctl/terminal"
 )
 
+const (
+	// DefaultShutdownTimeout is how long connections are given to finish once the context
+	// of ProxyServer is done, unless the server sets its own.
+	DefaultShutdownTimeout = 5 * time.Second
+
+	maxAcceptDelay = time.Second
+)
+
 type Server struct {
 	LocalAddr string
 	Addr      string
 	Listener  net.Listener
-	Dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	// PacketConn receives the datagrams to forward to Addr over UDP, in place of Listener.
+	PacketConn net.PacketConn
+	Dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Conns, if set, tracks the proxied connections.
+	Conns *ConnTable
+	// Balancer, if set, picks the instance every connection is proxied to, on Port, in
+	// place of Addr.
+	Balancer *Balancer
+	Port     string
+	// IdleTimeout, if set, closes connections without any traffic for that long.
+	IdleTimeout time.Duration
+	// MaxConns, if set, caps the number of concurrent connections. Connections past it
+	// wait to be accepted until another one closes.
+	MaxConns int
+	// ShutdownTimeout is how long connections are given to finish once the context of
+	// ProxyServer is done, DefaultShutdownTimeout if not set.
+	ShutdownTimeout time.Duration
+
+	mu      sync.Mutex
+	closing bool
+	active  map[*proxyConn]struct{}
+	wg      sync.WaitGroup
+}
+
+// proxyConn is an accepted connection and the one to its target, once dialed.
+type proxyConn struct {
+	source    net.Conn
+	target    net.Conn
+	closeOnce sync.Once
 }
 
+func (c *proxyConn) close() {
+	c.closeOnce.Do(func() {
+		c.source.Close()
+		if c.target != nil {
+			c.target.Close()
+		}
+	})
+}
+
+// dialTarget dials the target of a connection from source. The returned func must be
+// called once the connection is closed.
+func (srv *Server) dialTarget(ctx context.Context, network string, source net.Addr) (net.Conn, func(), error) {
+	if srv.Balancer == nil {
+		conn, err := srv.Dial(ctx, network, srv.Addr)
+		return conn, func() {}, err
+	}
+	return srv.Balancer.Dial(ctx, srv.Dial, network, srv.Port, clientKey(source))
+}
+
+// ProxyServer proxies the connections of the server until ctx is done. Open connections
+// are then given ShutdownTimeout to finish before being closed.
 func (srv *Server) ProxyServer(ctx context.Context) error {
-	defer srv.Listener.Close()
+	if srv.PacketConn != nil {
+		return srv.proxyPackets(ctx)
+	}
 
-	for {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
 		select {
-
 		case <-ctx.Done():
-			return nil
-		default:
-			if ls, ok := srv.Listener.(*net.TCPListener); ok {
-				if err := ls.SetDeadline(time.Now().Add(time.Second)); err != nil {
-					return err
-				}
-			} else if ls, ok := srv.Listener.(*net.UnixListener); ok {
-				if err := ls.SetDeadline(time.Now().Add(time.Second)); err != nil {
-					return err
-				}
+		case <-stop:
+		}
+		srv.Listener.Close()
+	}()
+
+	err := srv.serve(ctx)
+	srv.Listener.Close()
+	srv.drain()
+
+	return err
+}
+
+// serve accepts connections until ctx is done or the listener fails.
+func (srv *Server) serve(ctx context.Context) error {
+	var slots chan struct{}
+	if srv.MaxConns > 0 {
+		slots = make(chan struct{}, srv.MaxConns)
+	}
+
+	var delay time.Duration
+	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil
 			}
+		}
 
-			source, err := srv.Listener.Accept()
-			if err != nil {
-				if os.IsTimeout(err) {
-					continue
-				}
-				terminal.Debug("Error accepting connection: ", err)
+		source, err := srv.Listener.Accept()
+		if err != nil {
+			if slots != nil {
+				<-slots
 			}
-			defer source.Close()
 
-			terminal.Debug("accepted new connection from: ", source.RemoteAddr())
+			switch {
+			case ctx.Err() != nil:
+				return nil
+			case errors.Is(err, net.ErrClosed):
+				return err
+			}
 
-			go func() {
-				target, err := srv.Dial(ctx, "tcp", srv.Addr)
-				if err != nil {
-					terminal.Debug("failed to connect to target: ", err)
-					return
-				}
-				defer target.Close()
+			// back off on errors like running out of file descriptors
+			terminal.Debug("Error accepting connection: ", err)
+			if delay = 2 * delay; delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		delay = 0
 
-				wg := &sync.WaitGroup{}
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
 
-				wg.Add(2)
+			srv.handle(ctx, source)
+		}()
+	}
+}
 
-				copyFunc := func(dst net.Conn, src net.Conn) {
-					defer wg.Done()
-					io.Copy(dst, src)
+// handle proxies source to its target and closes both once either is done.
+func (srv *Server) handle(ctx context.Context, source net.Conn) {
+	conn := &proxyConn{source: source}
+	if !srv.track(conn) {
+		source.Close()
+		return
+	}
+	defer srv.untrack(conn)
+	defer conn.close()
 
-					// close the write half if it exports a CloseWrite() method
-					if conn, ok := dst.(ClosableWrite); ok {
-						conn.CloseWrite()
-					}
-				}
+	terminal.Debug("accepted new connection from: ", source.RemoteAddr())
 
-				go copyFunc(target, source)
-				go copyFunc(source, target)
+	target, release, err := srv.dialTarget(ctx, "tcp", source.RemoteAddr())
+	if err != nil {
+		terminal.Debug("failed to connect to target: ", err)
+		return
+	}
+	defer release()
+
+	if !srv.setTarget(conn, target) {
+		target.Close()
+		return
+	}
+
+	stats := srv.Conns.open(ConnStats{
+		Network:  "tcp",
+		Local:    srv.Listener.Addr().String(),
+		Source:   source.RemoteAddr().String(),
+		Remote:   srv.Addr,
+		Instance: target.RemoteAddr().String(),
+	})
+	defer srv.Conns.close(stats)
+
+	var idle *idleTimer
+	if srv.IdleTimeout > 0 {
+		idle = newIdleTimer(srv.IdleTimeout, conn.close)
+		defer idle.stop()
+	}
+
+	wg := &sync.WaitGroup{}
+
+	wg.Add(2)
 
-				wg.Wait()
+	copyFunc := func(dst net.Conn, src io.Reader) {
+		defer wg.Done()
+		io.Copy(dst, src)
 
-				terminal.Debug("connection closed")
-			}()
+		// close the write half if it exports a CloseWrite() method, or else the whole
+		// connection since the other end would never know the copy is done
+		if c, ok := dst.(ClosableWrite); ok {
+			c.CloseWrite()
+		} else {
+			conn.close()
 		}
 	}
+
+	go copyFunc(target, countingReader{source, &stats.sent, idle.touch})
+	go copyFunc(source, countingReader{target, &stats.received, idle.touch})
+
+	wg.Wait()
+
+	terminal.Debug("connection closed")
+}
+
+// track adds conn to the active connections, unless the server is closing.
+func (srv *Server) track(conn *proxyConn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.closing {
+		return false
+	}
+	if srv.active == nil {
+		srv.active = map[*proxyConn]struct{}{}
+	}
+	srv.active[conn] = struct{}{}
+	return true
+}
+
+func (srv *Server) untrack(conn *proxyConn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delete(srv.active, conn)
+}
+
+// setTarget sets the target of conn, unless the server is closing.
+func (srv *Server) setTarget(conn *proxyConn, target net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.closing {
+		return false
+	}
+	conn.target = target
+	return true
+}
+
+// drain waits for the active connections to finish, closing them after ShutdownTimeout.
+func (srv *Server) drain() {
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+
+	timeout := srv.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	terminal.Debug("closing the connections still open after ", timeout)
+
+	srv.mu.Lock()
+	srv.closing = true
+	for conn := range srv.active {
+		conn.close()
+	}
+	srv.mu.Unlock()
+
+	<-done
+}
+
+// idleTimer calls onIdle once it wasn't touched for its timeout.
+type idleTimer struct {
+	timeout time.Duration
+	last    atomic.Int64
+
+	mu      sync.Mutex
+	stopped bool
+	timer   *time.Timer
+}
+
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	t.touch()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.timer = time.AfterFunc(timeout, func() { t.check(onIdle) })
+	return t
+}
+
+func (t *idleTimer) check(onIdle func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return
+	}
+	if idle := time.Since(time.Unix(0, t.last.Load())); idle < t.timeout {
+		t.timer.Reset(t.timeout - idle)
+		return
+	}
+	onIdle()
+}
+
+// touch records activity, on a nil timer too.
+func (t *idleTimer) touch() {
+	if t != nil {
+		t.last.Store(time.Now().UnixNano())
+	}
+}
+
+func (t *idleTimer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	t.timer.Stop()
 }
 
 type ClosableWrite interface {
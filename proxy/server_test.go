@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memListener is an in-memory net.Listener, whose connections are made with dial.
+type memListener struct {
+	conns  chan net.Conn
+	errs   chan error
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newMemListener() *memListener {
+	return &memListener{
+		conns:  make(chan net.Conn),
+		errs:   make(chan error, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case err := <-l.errs:
+		return nil, err
+	default:
+	}
+
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "mem", Net: "unix"}
+}
+
+func (l *memListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+// echoDial dials an in-memory server echoing everything it reads.
+func echoDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		io.Copy(server, server)
+	}()
+	return client, nil
+}
+
+func startServer(t *testing.T, srv *Server) (*memListener, context.CancelFunc, <-chan error) {
+	t.Helper()
+
+	l := newMemListener()
+	srv.Listener = l
+	srv.Addr = "[fdaa::1]:80"
+	if srv.Dial == nil {
+		srv.Dial = echoDial
+	}
+	if srv.Conns == nil {
+		srv.Conns = &ConnTable{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ProxyServer(ctx)
+	}()
+	t.Cleanup(cancel)
+
+	return l, cancel, done
+}
+
+func assertEcho(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(buf))
+}
+
+func assertClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	// pipes fail to set deadlines once closed
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerProxiesAndClosesConnections(t *testing.T) {
+	srv := &Server{}
+	l, cancel, done := startServer(t, srv)
+
+	for i := 0; i < 3; i++ {
+		conn := l.dial()
+		assertEcho(t, conn, "hello")
+		conn.Close()
+	}
+
+	// every connection is closed once its copy is done, not when the server returns
+	assert.Eventually(t, func() bool {
+		return len(srv.Conns.Conns()) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestServerClosesSourceWhenDialFails(t *testing.T) {
+	srv := &Server{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+	l, _, _ := startServer(t, srv)
+
+	assertClosed(t, l.dial())
+}
+
+func TestServerKeepsAcceptingAfterErrors(t *testing.T) {
+	srv := &Server{}
+	l, _, _ := startServer(t, srv)
+
+	l.errs <- errors.New("too many open files")
+	assertEcho(t, l.dial(), "still there")
+}
+
+func TestServerMaxConns(t *testing.T) {
+	srv := &Server{MaxConns: 1}
+	l, _, _ := startServer(t, srv)
+
+	first := l.dial()
+	assertEcho(t, first, "first")
+
+	accepted := make(chan net.Conn)
+	go func() {
+		accepted <- l.dial()
+	}()
+
+	select {
+	case <-accepted:
+		t.Fatal("accepted a connection past MaxConns")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first.Close()
+	select {
+	case second := <-accepted:
+		assertEcho(t, second, "second")
+	case <-time.After(time.Second):
+		t.Fatal("connection not accepted once another one closed")
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	srv := &Server{IdleTimeout: 50 * time.Millisecond}
+	l, _, _ := startServer(t, srv)
+
+	conn := l.dial()
+	assertEcho(t, conn, "hello")
+	assertClosed(t, conn)
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	srv := &Server{ShutdownTimeout: 5 * time.Second}
+	l, cancel, done := startServer(t, srv)
+
+	conn := l.dial()
+	assertEcho(t, conn, "before")
+
+	cancel()
+
+	// open connections keep working until they're done
+	assertEcho(t, conn, "draining")
+	select {
+	case <-done:
+		t.Fatal("returned before the connection was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn.Close()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("didn't return once the connection was done")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	srv := &Server{ShutdownTimeout: 50 * time.Millisecond}
+	l, cancel, done := startServer(t, srv)
+
+	conn := l.dial()
+	assertEcho(t, conn, "hello")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("didn't close the connections after the shutdown timeout")
+	}
+	assertClosed(t, conn)
+}
+
+func startPacketServer(t *testing.T, srv *Server) (net.Addr, context.CancelFunc, <-chan error) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv.PacketConn = conn
+	srv.Addr = "[fdaa::1]:53"
+	if srv.Dial == nil {
+		srv.Dial = echoDial
+	}
+	if srv.Conns == nil {
+		srv.Conns = &ConnTable{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ProxyServer(ctx)
+	}()
+	t.Cleanup(cancel)
+
+	return conn.LocalAddr(), cancel, done
+}
+
+func dialUDP(t *testing.T, addr net.Addr) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// assertNoDatagram asserts that conn receives nothing for a while.
+func assertNoDatagram(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err := conn.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "expected no datagram, got %v", err)
+}
+
+func TestServerProxiesDatagrams(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		networks []string
+	)
+	srv := &Server{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			networks = append(networks, network)
+			mu.Unlock()
+			return echoDial(ctx, network, addr)
+		},
+	}
+	addr, cancel, done := startPacketServer(t, srv)
+
+	first, second := dialUDP(t, addr), dialUDP(t, addr)
+	assertEcho(t, first, "hello")
+	assertEcho(t, first, "again")
+	assertEcho(t, second, "from another port")
+
+	// every local address gets its own session, dialed once over UDP
+	conns := srv.Conns.Conns()
+	require.Len(t, conns, 2)
+	for _, conn := range conns {
+		assert.Equal(t, "udp", conn.Network)
+	}
+	assert.Equal(t, int64(len("hello")+len("again")), conns[0].BytesSent)
+	assert.Equal(t, int64(len("hello")+len("again")), conns[0].BytesReceived)
+	mu.Lock()
+	assert.Equal(t, []string{"udp", "udp"}, networks)
+	mu.Unlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Eventually(t, func() bool {
+		return len(srv.Conns.Conns()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerUDPSessionIdleTimeout(t *testing.T) {
+	srv := &Server{IdleTimeout: 50 * time.Millisecond}
+	addr, _, _ := startPacketServer(t, srv)
+
+	conn := dialUDP(t, addr)
+	assertEcho(t, conn, "hello")
+	assert.Eventually(t, func() bool {
+		return len(srv.Conns.Conns()) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// a new session is opened for the next datagram
+	assertEcho(t, conn, "back")
+}
+
+func TestServerUDPMaxConns(t *testing.T) {
+	srv := &Server{MaxConns: 1}
+	addr, _, _ := startPacketServer(t, srv)
+
+	first, second := dialUDP(t, addr), dialUDP(t, addr)
+	assertEcho(t, first, "first")
+
+	// datagrams past MaxConns sessions are dropped
+	_, err := second.Write([]byte("second"))
+	require.NoError(t, err)
+	assertNoDatagram(t, second)
+	assert.Len(t, srv.Conns.Conns(), 1)
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+// udpSessionTimeout is how long the datagrams of a local address keep being relayed
+// without any traffic either way, unless the server sets an IdleTimeout.
+const udpSessionTimeout = 2 * time.Minute
+
+func (srv *Server) sessionTimeout() time.Duration {
+	if srv.IdleTimeout > 0 {
+		return srv.IdleTimeout
+	}
+	return udpSessionTimeout
+}
+
+// udpSession relays the datagrams of a local address to the remote one.
+type udpSession struct {
+	target  net.Conn
+	release func()
+	conn    *trackedConn
+	idle    *time.Timer
+}
+
+// proxyPackets relays the datagrams received by srv.PacketConn, with a session dialed
+// through srv.Dial for every local address they come from.
+func (srv *Server) proxyPackets(ctx context.Context) error {
+	defer srv.PacketConn.Close()
+
+	var (
+		mu       sync.Mutex
+		sessions = map[string]*udpSession{}
+		buf      = make([]byte, math.MaxUint16)
+	)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, session := range sessions {
+			session.target.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if err := srv.PacketConn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+
+		n, addr, err := srv.PacketConn.ReadFrom(buf)
+		if err != nil {
+			if os.IsTimeout(err) {
+				continue
+			}
+			return err
+		}
+
+		mu.Lock()
+		session := sessions[addr.String()]
+		mu.Unlock()
+
+		if session == nil {
+			mu.Lock()
+			full := srv.MaxConns > 0 && len(sessions) >= srv.MaxConns
+			mu.Unlock()
+			if full {
+				terminal.Debug("dropping datagram from ", addr, ": too many UDP sessions")
+				continue
+			}
+
+			if session, err = srv.openUDPSession(ctx, addr); err != nil {
+				terminal.Debug("failed to connect to target: ", err)
+				continue
+			}
+
+			mu.Lock()
+			sessions[addr.String()] = session
+			mu.Unlock()
+
+			go func(addr net.Addr) {
+				srv.relayUDPSession(session, addr)
+
+				mu.Lock()
+				delete(sessions, addr.String())
+				mu.Unlock()
+			}(addr)
+		}
+
+		session.idle.Reset(srv.sessionTimeout())
+		if _, err := session.target.Write(buf[:n]); err != nil {
+			terminal.Debug("failed to forward datagram: ", err)
+			continue
+		}
+		session.conn.sent.Add(int64(n))
+	}
+}
+
+func (srv *Server) openUDPSession(ctx context.Context, addr net.Addr) (*udpSession, error) {
+	target, release, err := srv.dialTarget(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	terminal.Debug("new UDP session from: ", addr)
+
+	return &udpSession{
+		target:  target,
+		release: release,
+		conn: srv.Conns.open(ConnStats{
+			Network:  "udp",
+			Local:    srv.PacketConn.LocalAddr().String(),
+			Source:   addr.String(),
+			Remote:   srv.Addr,
+			Instance: target.RemoteAddr().String(),
+		}),
+		// closing the target ends relayUDPSession
+		idle: time.AfterFunc(srv.sessionTimeout(), func() { target.Close() }),
+	}, nil
+}
+
+// relayUDPSession writes the datagrams session receives back to addr until its target is closed.
+func (srv *Server) relayUDPSession(session *udpSession, addr net.Addr) {
+	defer srv.Conns.close(session.conn)
+	defer session.release()
+	defer session.idle.Stop()
+	defer session.target.Close()
+
+	buf := make([]byte, math.MaxUint16)
+	for {
+		n, err := session.target.Read(buf)
+		if err != nil {
+			terminal.Debug("UDP session closed: ", err)
+			return
+		}
+		session.idle.Reset(srv.sessionTimeout())
+
+		if _, err := srv.PacketConn.WriteTo(buf[:n], addr); err != nil {
+			terminal.Debug("failed to write datagram: ", err)
+			return
+		}
+		session.conn.received.Add(int64(n))
+	}
+}
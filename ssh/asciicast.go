@@ -0,0 +1,275 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Asciicast event types.
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// AsciicastHeader is the first line of an asciicast v2 recording.
+type AsciicastHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// Recorder records a terminal session in the asciicast v2 format, as played back by
+// asciinema and Replay. Its methods are safe to call from several goroutines.
+type Recorder struct {
+	Title string
+	Env   map[string]string
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	start   time.Time
+	started bool
+	pending map[string][]byte
+	err     error
+}
+
+// NewRecorder returns a Recorder writing to w once started.
+func NewRecorder(w io.Writer) *Recorder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return &Recorder{
+		enc:     enc,
+		pending: map[string][]byte{},
+	}
+}
+
+// Start writes the header of the recording, for a terminal of width by height. Events
+// recorded before are dropped.
+func (r *Recorder) Start(width, height int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.start = time.Now()
+	r.started = true
+	r.encode(AsciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     r.Title,
+		Env:       r.Env,
+	})
+}
+
+// Resize records the terminal being resized to width by height, on a nil recorder too.
+func (r *Recorder) Resize(width, height int) {
+	if r != nil {
+		r.event(EventResize, []byte(fmt.Sprintf("%dx%d", width, height)))
+	}
+}
+
+// Writer returns a writer recording what's written to it as events of type typ.
+func (r *Recorder) Writer(typ string) io.Writer {
+	return recorderWriter{r, typ}
+}
+
+type recorderWriter struct {
+	r   *Recorder
+	typ string
+}
+
+func (w recorderWriter) Write(p []byte) (int, error) {
+	w.r.event(w.typ, p)
+	return len(p), nil
+}
+
+// Err returns the first error writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Close writes the bytes held back from incomplete runes and stops recording, events
+// recorded after it are dropped. It returns the first error writing the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started {
+		for _, typ := range []string{EventOutput, EventInput} {
+			if data := r.pending[typ]; len(data) > 0 {
+				r.encodeEvent(typ, data)
+			}
+			delete(r.pending, typ)
+		}
+		r.started = false
+	}
+	return r.err
+}
+
+func (r *Recorder) event(typ string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started {
+		return
+	}
+
+	// events are JSON strings, so hold the start of runes split across writes
+	data = append(r.pending[typ], data...)
+	data, r.pending[typ] = splitIncompleteRune(data)
+	if len(data) == 0 {
+		return
+	}
+	r.encodeEvent(typ, data)
+}
+
+func (r *Recorder) encodeEvent(typ string, data []byte) {
+	elapsed := float64(time.Since(r.start).Microseconds()) / 1e6
+	r.encode([]interface{}{elapsed, typ, string(data)})
+}
+
+func (r *Recorder) encode(v interface{}) {
+	if r.err == nil {
+		r.err = r.enc.Encode(v)
+	}
+}
+
+// splitIncompleteRune splits b before the rune it ends with, if incomplete.
+func splitIncompleteRune(b []byte) ([]byte, []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if !utf8.FullRune(b[i:]) {
+			return b[:i], append([]byte(nil), b[i:]...)
+		}
+		break
+	}
+	return b, nil
+}
+
+// ReplayOptions change how recordings are played back.
+type ReplayOptions struct {
+	// Speed multiplies the pace of the recording, 1 if not set.
+	Speed float64
+	// IdleLimit, if set, caps the pauses between events, as does the idle time limit of
+	// the recording.
+	IdleLimit time.Duration
+}
+
+// Replay plays the asciicast v2 recording read from r back to w, with its original
+// timing, until it's done or ctx is. It returns the header of the recording.
+func Replay(ctx context.Context, r io.Reader, w io.Writer, opts ReplayOptions) (*AsciicastHeader, error) {
+	br := bufio.NewReader(r)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	var header AsciicastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	idleLimit := opts.IdleLimit
+	if limit := time.Duration(header.IdleTimeLimit * float64(time.Second)); limit > 0 && (idleLimit == 0 || limit < idleLimit) {
+		idleLimit = limit
+	}
+
+	var (
+		last  time.Duration
+		timer = time.NewTimer(0)
+	)
+	defer timer.Stop()
+	<-timer.C
+
+	for n := 2; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			at, typ, data, perr := parseEvent(line)
+			if perr != nil {
+				return &header, fmt.Errorf("line %d: %w", n, perr)
+			}
+
+			pause := at - last
+			if idleLimit > 0 && pause > idleLimit {
+				pause = idleLimit
+			}
+			last = at
+
+			if pause > 0 {
+				timer.Reset(time.Duration(float64(pause) / speed))
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return &header, ctx.Err()
+				}
+			}
+
+			// input is echoed in the output, and terminals can't be resized from here
+			if typ == EventOutput {
+				if _, err := io.WriteString(w, data); err != nil {
+					return &header, err
+				}
+			}
+		}
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return &header, nil
+		case err != nil:
+			return &header, err
+		}
+	}
+}
+
+// parseEvent parses an event line, [time, type, data].
+func parseEvent(line []byte) (time.Duration, string, string, error) {
+	var event []json.RawMessage
+	if err := json.Unmarshal(line, &event); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event: %w", err)
+	}
+	if len(event) != 3 {
+		return 0, "", "", fmt.Errorf("invalid event, expected [time, type, data]")
+	}
+
+	seconds, err := strconv.ParseFloat(string(event[0]), 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid event time: %w", err)
+	}
+
+	var typ, data string
+	if err := json.Unmarshal(event[1], &typ); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event type: %w", err)
+	}
+	if err := json.Unmarshal(event[2], &data); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event data: %w", err)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), typ, data, nil
+}
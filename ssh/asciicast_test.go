@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.Title = "root@app"
+
+	// dropped until started
+	rec.Writer(EventOutput).Write([]byte("before"))
+
+	rec.Start(120, 40)
+	out := rec.Writer(EventOutput)
+	out.Write([]byte("$ ls\r\n"))
+	rec.Writer(EventInput).Write([]byte("l"))
+	rec.Resize(100, 30)
+
+	// é split across writes
+	out.Write([]byte{'c', 'a', 'f', 0xc3})
+	out.Write([]byte{0xa9, '\n'})
+	require.NoError(t, rec.Err())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 6)
+
+	var header AsciicastHeader
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, 120, header.Width)
+	assert.Equal(t, 40, header.Height)
+	assert.Equal(t, "root@app", header.Title)
+
+	var events []string
+	for _, line := range lines[1:] {
+		_, typ, data, err := parseEvent([]byte(line))
+		require.NoError(t, err)
+		events = append(events, typ+" "+data)
+	}
+	assert.Equal(t, []string{"o $ ls\r\n", "i l", "r 100x30", "o caf", "o é\n"}, events)
+}
+
+func TestRecorderClose(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.Start(80, 24)
+
+	// the first byte of é, held back until the rest of it
+	rec.Writer(EventOutput).Write([]byte{'o', 'k', 0xc3})
+	require.NoError(t, rec.Close())
+
+	// dropped once closed
+	rec.Writer(EventOutput).Write([]byte("after"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+
+	var events []string
+	for _, line := range lines[1:] {
+		_, typ, data, err := parseEvent([]byte(line))
+		require.NoError(t, err)
+		events = append(events, typ+" "+data)
+	}
+	assert.Equal(t, []string{"o ok", "o \ufffd"}, events)
+}
+
+func TestNilRecorderResize(t *testing.T) {
+	var rec *Recorder
+	rec.Resize(80, 24)
+}
+
+func TestReplay(t *testing.T) {
+	recording := strings.Join([]string{
+		`{"version": 2, "width": 80, "height": 24, "title": "demo"}`,
+		`[0.01, "o", "hello "]`,
+		`[0.02, "i", "ignored"]`,
+		`[0.03, "r", "100x30"]`,
+		`[60.5, "o", "world\r\n"]`,
+	}, "\n")
+
+	var out bytes.Buffer
+	start := time.Now()
+	header, err := Replay(context.Background(), strings.NewReader(recording), &out, ReplayOptions{
+		Speed:     2,
+		IdleLimit: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "demo", header.Title)
+	assert.Equal(t, "hello world\r\n", out.String())
+	// the minute long pause is capped
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.Start(80, 24)
+	w := bufio.NewWriter(rec.Writer(EventOutput))
+	w.WriteString("line one\r\nline two\r\n")
+	w.Flush()
+
+	var out bytes.Buffer
+	_, err := Replay(context.Background(), &buf, &out, ReplayOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two\r\n", out.String())
+}
+
+func TestReplayRejectsOtherVersions(t *testing.T) {
+	_, err := Replay(context.Background(), strings.NewReader(`{"version": 1}`), &bytes.Buffer{}, ReplayOptions{})
+	assert.ErrorContains(t, err, "unsupported asciicast version 1")
+}
+
+func TestReplayStopsWithContext(t *testing.T) {
+	recording := `{"version": 2, "width": 80, "height": 24}` + "\n" + `[3600, "o", "never"]`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var out bytes.Buffer
+	_, err := Replay(ctx, strings.NewReader(recording), &out, ReplayOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, out.String())
+}
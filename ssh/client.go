@@ -3,6 +3,7 @@ package ssh
 import (
 	"context"
 	"errors"
+	"io"
 	"log"
 	"net"
 
@@ -118,3 +119,47 @@ func (c *Client) Shell(ctx context.Context, sessIO *SessionIO, cmd string) error
 
 	return sessIO.attach(ctx, sess, cmd)
 }
+
+// Run runs cmd in a new session, without a terminal, and returns its exit code once its
+// output is copied to stdout and stderr. Errors are only returned when cmd couldn't be
+// run or didn't exit, like when ctx is done first.
+func (c *Client) Run(ctx context.Context, cmd string, stdout, stderr io.Writer) (int, error) {
+	if c.Client == nil {
+		if err := c.Connect(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	sess, err := c.Client.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer sess.Close()
+
+	sess.Stdout = stdout
+	sess.Stderr = stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Run(cmd)
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		sess.Close()
+		// the output is copied to stdout and stderr until Run returns
+		<-done
+		return 0, ctx.Err()
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		return 0, err
+	}
+}
@@ -0,0 +1,199 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an in-process SSH server forwarding ports like sshd does.
+type testServer struct {
+	// remoteListeners receives the address of every listener opened for a remote forward
+	remoteListeners chan net.Addr
+
+	mu        sync.Mutex
+	listeners map[uint32]net.Listener
+}
+
+// newTestClient starts a testServer and returns a Client connected to it.
+func newTestClient(t *testing.T) (*Client, *testServer) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testServer{remoteListeners: make(chan net.Addr, 10), listeners: map[uint32]net.Listener{}}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		s.serve(conn, config)
+	}()
+
+	tcpConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	conn, chans, reqs, err := ssh.NewClientConn(tcpConn, listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+
+	c := &Client{Client: ssh.NewClient(conn, chans, reqs), conn: conn}
+	t.Cleanup(func() { c.Close() })
+	return c, s
+}
+
+func (s *testServer) serve(tcpConn net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(tcpConn, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	go s.serveRequests(conn, reqs)
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "direct-tcpip":
+			go s.serveDirectTCPIP(newChan)
+		default:
+			newChan.Reject(ssh.UnknownChannelType, newChan.ChannelType())
+		}
+	}
+}
+
+// serveDirectTCPIP proxies a channel opened by a local forward to its target.
+func (s *testServer) serveDirectTCPIP(newChan ssh.NewChannel) {
+	var target struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &target); err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	proxy(ch, conn.(*net.TCPConn))
+}
+
+// serveRequests serves the remote forwards requested over conn.
+func (s *testServer) serveRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		var bind struct {
+			Addr string
+			Port uint32
+		}
+		if err := ssh.Unmarshal(req.Payload, &bind); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		switch req.Type {
+		case "tcpip-forward":
+		case "cancel-tcpip-forward":
+			s.mu.Lock()
+			if listener, ok := s.listeners[bind.Port]; ok {
+				listener.Close()
+			}
+			s.mu.Unlock()
+			req.Reply(true, nil)
+			continue
+		default:
+			req.Reply(false, nil)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(bind.Addr, strconv.Itoa(int(bind.Port))))
+		if err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		s.mu.Lock()
+		s.listeners[port] = listener
+		s.mu.Unlock()
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+		s.remoteListeners <- listener.Addr()
+
+		go func() {
+			defer listener.Close()
+			go func() {
+				conn.Wait()
+				listener.Close()
+			}()
+
+			for {
+				local, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					orig := local.RemoteAddr().(*net.TCPAddr)
+					ch, reqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+						Addr     string
+						Port     uint32
+						OrigAddr string
+						OrigPort uint32
+					}{bind.Addr, port, orig.IP.String(), uint32(orig.Port)}))
+					if err != nil {
+						local.Close()
+						return
+					}
+					go ssh.DiscardRequests(reqs)
+					proxy(ch, local.(*net.TCPConn))
+				}()
+			}
+		}()
+	}
+}
+
+// halfCloser is a connection whose write half can be closed on its own.
+type halfCloser interface {
+	io.ReadWriteCloser
+	CloseWrite() error
+}
+
+// proxy copies a to b and b to a, closing the write half of each once its copy is done.
+func proxy(a, b halfCloser) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src halfCloser) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		dst.CloseWrite()
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,224 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+// Forward is a port forwarded through an SSH connection, like the ones of the -L, -R
+// and -D flags of OpenSSH. Host and HostPort are unset for dynamic forwards.
+type Forward struct {
+	BindAddr string
+	BindPort string
+	Host     string
+	HostPort string
+}
+
+// Bind returns the address the forward listens on.
+func (f Forward) Bind() string {
+	return net.JoinHostPort(f.BindAddr, f.BindPort)
+}
+
+// Target returns the address the forward connects to.
+func (f Forward) Target() string {
+	return net.JoinHostPort(f.Host, f.HostPort)
+}
+
+// splitForward splits spec on colons, keeping bracketed IPv6 addresses whole.
+func splitForward(spec string) ([]string, error) {
+	var parts []string
+	for spec != "" {
+		if strings.HasPrefix(spec, "[") {
+			end := strings.Index(spec, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in %q", spec)
+			}
+			parts = append(parts, spec[1:end])
+			spec = strings.TrimPrefix(spec[end+1:], ":")
+			continue
+		}
+
+		part, rest, found := strings.Cut(spec, ":")
+		parts = append(parts, part)
+		spec = rest
+		if found && rest == "" {
+			parts = append(parts, "")
+		}
+	}
+	return parts, nil
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
+
+// ParseForward parses spec, [bind_address:]port:host:hostport, as given to -L and -R.
+// The bind address defaults to localhost.
+func ParseForward(spec string) (Forward, error) {
+	parts, err := splitForward(spec)
+	if err != nil {
+		return Forward{}, fmt.Errorf("invalid forward %q: %w", spec, err)
+	}
+
+	var f Forward
+	switch len(parts) {
+	case 3:
+		f = Forward{BindAddr: "localhost", BindPort: parts[0], Host: parts[1], HostPort: parts[2]}
+	case 4:
+		f = Forward{BindAddr: parts[0], BindPort: parts[1], Host: parts[2], HostPort: parts[3]}
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+
+	if !validPort(f.BindPort) || !validPort(f.HostPort) || f.Host == "" {
+		return Forward{}, fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+	return f, nil
+}
+
+// ParseDynamicForward parses spec, [bind_address:]port, as given to -D. The bind
+// address defaults to localhost.
+func ParseDynamicForward(spec string) (Forward, error) {
+	parts, err := splitForward(spec)
+	if err != nil {
+		return Forward{}, fmt.Errorf("invalid dynamic forward %q: %w", spec, err)
+	}
+
+	var f Forward
+	switch len(parts) {
+	case 1:
+		f = Forward{BindAddr: "localhost", BindPort: parts[0]}
+	case 2:
+		f = Forward{BindAddr: parts[0], BindPort: parts[1]}
+	default:
+		return Forward{}, fmt.Errorf("invalid dynamic forward %q, expected [bind_address:]port", spec)
+	}
+
+	if !validPort(f.BindPort) {
+		return Forward{}, fmt.Errorf("invalid dynamic forward %q, expected [bind_address:]port", spec)
+	}
+	return f, nil
+}
+
+// ForwardLocal listens on the bind address of f and proxies every connection to its
+// target, dialed from the remote end, in the background until ctx is done.
+func (c *Client) ForwardLocal(ctx context.Context, f Forward) error {
+	listener, err := net.Listen("tcp", f.Bind())
+	if err != nil {
+		return err
+	}
+
+	go serveForward(ctx, listener, func(conn net.Conn) {
+		target, err := c.Client.Dial("tcp", f.Target())
+		if err != nil {
+			terminal.Debugf("failed to connect to %s: %v\n", f.Target(), err)
+			conn.Close()
+			return
+		}
+		pipe(conn, target)
+	})
+	return nil
+}
+
+// ForwardRemote listens on the bind address of f on the remote end and proxies every
+// connection to its target, dialed locally, in the background until ctx is done.
+func (c *Client) ForwardRemote(ctx context.Context, f Forward) error {
+	listener, err := c.Client.Listen("tcp", f.Bind())
+	if err != nil {
+		return fmt.Errorf("remote listen on %s: %w", f.Bind(), err)
+	}
+
+	var dialer net.Dialer
+	go serveForward(ctx, listener, func(conn net.Conn) {
+		target, err := dialer.DialContext(ctx, "tcp", f.Target())
+		if err != nil {
+			terminal.Debugf("failed to connect to %s: %v\n", f.Target(), err)
+			conn.Close()
+			return
+		}
+		pipe(conn, target)
+	})
+	return nil
+}
+
+// ForwardDynamic runs a SOCKS5 proxy on the bind address of f, whose connections are
+// dialed from the remote end, in the background until ctx is done.
+func (c *Client) ForwardDynamic(ctx context.Context, f Forward) error {
+	listener, err := net.Listen("tcp", f.Bind())
+	if err != nil {
+		return err
+	}
+
+	go serveForward(ctx, listener, func(conn net.Conn) {
+		target, err := socks5Handshake(conn, func(addr string) (net.Conn, error) {
+			return c.Client.Dial("tcp", addr)
+		})
+		if err != nil {
+			terminal.Debugf("socks: %v\n", err)
+			conn.Close()
+			return
+		}
+		pipe(conn, target)
+	})
+	return nil
+}
+
+// serveForward calls handle with every connection listener accepts until ctx is done.
+func serveForward(ctx context.Context, listener net.Listener, handle func(net.Conn)) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				terminal.Debugf("forward on %s stopped: %v\n", listener.Addr(), err)
+			}
+			return
+		}
+
+		go handle(conn)
+	}
+}
+
+// pipe copies a to b and b to a until both are done, then closes them. The write half of
+// each is closed once its copy is done, or the whole connections if that isn't supported.
+func pipe(a, b net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			a.Close()
+			b.Close()
+		})
+	}
+	defer closeBoth()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			closeBoth()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+
+	wg.Wait()
+}
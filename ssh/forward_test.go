@@ -0,0 +1,319 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForward(t *testing.T) {
+	f, err := ParseForward("5432:db.internal:5432")
+	require.NoError(t, err)
+	assert.Equal(t, Forward{BindAddr: "localhost", BindPort: "5432", Host: "db.internal", HostPort: "5432"}, f)
+
+	f, err = ParseForward("0.0.0.0:8080:[fdaa::3]:80")
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:8080", f.Bind())
+	assert.Equal(t, "[fdaa::3]:80", f.Target())
+
+	for _, spec := range []string{"5432", "5432:db", "x:db:5432", "5432::5432", "[::1:80:db:5432"} {
+		_, err := ParseForward(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseDynamicForward(t *testing.T) {
+	f, err := ParseDynamicForward("1080")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:1080", f.Bind())
+
+	f, err = ParseDynamicForward("[::1]:1080")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:1080", f.Bind())
+
+	_, err = ParseDynamicForward("localhost:1080:x")
+	assert.Error(t, err)
+}
+
+func TestSOCKS5Handshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var dialed string
+	done := make(chan error, 1)
+	go func() {
+		target, err := socks5Handshake(server, func(addr string) (net.Conn, error) {
+			dialed = addr
+			local, remote := net.Pipe()
+			remote.Close()
+			return local, nil
+		})
+		if target != nil {
+			target.Close()
+		}
+		done <- err
+	}()
+
+	// no authentication, then CONNECT to example.internal:5432
+	_, err := client.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x05, 0x00}, reply)
+
+	request := append([]byte{0x05, 0x01, 0x00, 0x03, byte(len("example.internal"))}, "example.internal"...)
+	_, err = client.Write(append(request, 0x15, 0x38))
+	require.NoError(t, err)
+	reply = make([]byte, 10)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x00), reply[1])
+
+	require.NoError(t, <-done)
+	assert.Equal(t, "example.internal:5432", dialed)
+}
+
+func TestSOCKS5HandshakeDialFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := socks5Handshake(server, func(addr string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		})
+		done <- err
+	}()
+
+	_, err := client.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 2))
+	require.NoError(t, err)
+
+	_, err = client.Write([]byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x00, 0x50})
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x01), reply[1])
+
+	assert.Error(t, <-done)
+}
+
+// tcpPair returns both ends of a TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server, err := listener.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) (host, port string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	host, port, err = net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	return host, port
+}
+
+// echoServer returns the port of a server echoing what it reads until EOF.
+func echoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// assertEchoes checks what's written to conn comes back once its write half is closed.
+func assertEchoes(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+// assertStopsListening checks nothing accepts connections on addr soon.
+func assertStopsListening(t *testing.T, addr string) {
+	t.Helper()
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPipeClosesWriteHalves(t *testing.T) {
+	a, a2 := tcpPair(t)
+	b2, b := tcpPair(t)
+
+	done := make(chan struct{})
+	go func() {
+		pipe(a2, b2)
+		close(done)
+	}()
+
+	_, err := a.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, a.CloseWrite())
+	data, err := io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(data))
+
+	// the other way keeps going once one is done
+	_, err = b.Write([]byte("pong"))
+	require.NoError(t, err)
+	require.NoError(t, b.CloseWrite())
+	data, err = io.ReadAll(a)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(data))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipe didn't return once both ways were done")
+	}
+}
+
+func TestServeForwardStopsOnCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan net.Conn, 1)
+	done := make(chan struct{})
+	go func() {
+		serveForward(ctx, listener, func(conn net.Conn) { handled <- conn })
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	(<-handled).Close()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveForward didn't return once canceled")
+	}
+	assertStopsListening(t, listener.Addr().String())
+}
+
+func TestForwardLocal(t *testing.T) {
+	c, _ := newTestClient(t)
+	host, port := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := Forward{BindAddr: host, BindPort: port, Host: "127.0.0.1", HostPort: echoServer(t)}
+	require.NoError(t, c.ForwardLocal(ctx, f))
+
+	conn, err := net.Dial("tcp", f.Bind())
+	require.NoError(t, err)
+	defer conn.Close()
+	assertEchoes(t, conn)
+
+	cancel()
+	assertStopsListening(t, f.Bind())
+}
+
+func TestForwardRemote(t *testing.T) {
+	c, s := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := Forward{BindAddr: "127.0.0.1", BindPort: "0", Host: "127.0.0.1", HostPort: echoServer(t)}
+	require.NoError(t, c.ForwardRemote(ctx, f))
+	addr := (<-s.remoteListeners).String()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	assertEchoes(t, conn)
+
+	cancel()
+	assertStopsListening(t, addr)
+}
+
+func TestForwardDynamic(t *testing.T) {
+	c, _ := newTestClient(t)
+	host, port := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := Forward{BindAddr: host, BindPort: port}
+	require.NoError(t, c.ForwardDynamic(ctx, f))
+
+	conn, err := net.Dial("tcp", f.Bind())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// no authentication, then CONNECT to the echo server at 127.0.0.1
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	echoPort, err := strconv.Atoi(echoServer(t))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(echoPort >> 8), byte(echoPort)})
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x00), reply[1])
+	assertEchoes(t, conn)
+
+	cancel()
+	assertStopsListening(t, f.Bind())
+}
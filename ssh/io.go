@@ -35,6 +35,11 @@ type SessionIO struct {
 
 	AllocPTY bool
 	TermEnv  string
+
+	// Recorder, if set, records the session. Input is only recorded when RecordInput is
+	// set, since it includes what's typed at password prompts.
+	Recorder    *Recorder
+	RecordInput bool
 }
 
 func getFd(reader io.Reader) (fd int, ok bool) {
@@ -48,8 +53,8 @@ func getFd(reader io.Reader) (fd int, ok bool) {
 }
 
 func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) error {
+	width, height := DefaultWidth, DefaultHeight
 	if s.AllocPTY {
-		width, height := DefaultWidth, DefaultHeight
 		if fd, ok := getFd(s.Stdin); ok {
 			state, err := term.MakeRaw(fd)
 			if err != nil {
@@ -66,7 +71,7 @@ func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) e
 					return err
 				}
 
-				go watchWindowSize(ctx, fd, sess)
+				go watchWindowSize(ctx, fd, sess, s.Recorder)
 			}
 		}
 
@@ -75,6 +80,17 @@ func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) e
 		}
 	}
 
+	sin, sout, serr := s.Stdin, io.Writer(s.Stdout), io.Writer(s.Stderr)
+	if s.Recorder != nil {
+		s.Recorder.Start(width, height)
+
+		sout = io.MultiWriter(sout, s.Recorder.Writer(EventOutput))
+		serr = io.MultiWriter(serr, s.Recorder.Writer(EventOutput))
+		if s.RecordInput {
+			sin = io.TeeReader(sin, s.Recorder.Writer(EventInput))
+		}
+	}
+
 	var closeStdin sync.Once
 	stdin, err := sess.StdinPipe()
 	if err != nil {
@@ -98,10 +114,19 @@ func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) e
 		defer closeStdin.Do(func() {
 			stdin.Close()
 		})
-		io.Copy(stdin, s.Stdin)
+		io.Copy(stdin, sin)
+	}()
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		io.Copy(sout, stdout)
+	}()
+	go func() {
+		defer output.Done()
+		io.Copy(serr, stderr)
 	}()
-	go io.Copy(s.Stdout, stdout)
-	go io.Copy(s.Stderr, stderr)
 
 	cmdC := make(chan error, 1)
 	go func() {
@@ -118,6 +143,8 @@ func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) e
 
 	select {
 	case err := <-cmdC:
+		// the last output may still be copied once the command is done
+		output.Wait()
 		return err
 	case <-ctx.Done():
 		return errors.New("session forcibly closed; the remote process may still be running")
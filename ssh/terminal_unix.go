@@ -13,7 +13,9 @@ import (
 	"golang.org/x/term"
 )
 
-func watchWindowSize(ctx context.Context, fd int, sess *ssh.Session) error {
+// watchWindowSize forwards the size of the terminal to sess, and rec if set, whenever
+// it changes.
+func watchWindowSize(ctx context.Context, fd int, sess *ssh.Session, rec *Recorder) error {
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGWINCH)
 
@@ -32,5 +34,6 @@ func watchWindowSize(ctx context.Context, fd int, sess *ssh.Session) error {
 		if err := sess.WindowChange(height, width); err != nil {
 			return err
 		}
+		rec.Resize(width, height)
 	}
 }